@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/accrualmock"
+)
+
+type mockCfg struct {
+	RunAddress string        `env:"RUN_ADDRESS"`
+	Latency    time.Duration `env:"ACCRUAL_MOCK_LATENCY"`
+	Fixture    string        `env:"ACCRUAL_MOCK_FIXTURE"`
+}
+
+func main() {
+	var cfg mockCfg
+	flag.StringVar(&cfg.RunAddress, "a", "localhost:8082", "RUN_ADDRESS")
+	flag.DurationVar(&cfg.Latency, "l", 0, "ACCRUAL_MOCK_LATENCY")
+	flag.StringVar(&cfg.Fixture, "f", "", "ACCRUAL_MOCK_FIXTURE")
+	flag.Parse()
+	if err := env.Parse(&cfg); err != nil {
+		panic(err)
+	}
+
+	srv := accrualmock.New()
+	srv.Latency = cfg.Latency
+
+	if cfg.Fixture != "" {
+		data, err := os.ReadFile(cfg.Fixture)
+		if err != nil {
+			log.Fatal().Err(err).Msg("fail read fixture")
+		}
+		var fixture accrualmock.Fixture
+		if err = json.Unmarshal(data, &fixture); err != nil {
+			log.Fatal().Err(err).Msg("fail parse fixture")
+		}
+		srv.Load(fixture)
+	}
+
+	log.Info().Msg("accrual mock listen on " + cfg.RunAddress)
+	err := http.ListenAndServe(cfg.RunAddress, srv)
+	log.Error().Err(err).Msg("fail start accrual mock")
+}
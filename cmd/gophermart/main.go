@@ -1,15 +1,77 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/rs/zerolog/log"
-	
+
 	"github.com/e-faizov/gophermart/internal/config"
+	"github.com/e-faizov/gophermart/internal/logging"
 	"github.com/e-faizov/gophermart/internal/server"
 )
 
 func main() {
-	cfg := config.GetConfig()
+	args := os.Args[1:]
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		os.Exit(printConfig(args[2:]))
+	}
+
+	cfg := loadConfig("gophermart", args)
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid config:", err)
+		os.Exit(2)
+	}
+
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format, cfg.Log.Sample); err != nil {
+		log.Fatal().Err(err).Msg("fail setup logging")
+	}
+
+	load := func() (config.GopherMartCfg, error) {
+		return config.Load("gophermart", args)
+	}
+	// the first signal starts a graceful shutdown, a second one kills the
+	// process as usual
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := server.StartServer(ctx, cfg, load); err != nil {
+		log.Fatal().Err(err).Msg("fail start server")
+	}
+	log.Info().Msg("server stopped")
+}
+
+// printConfig writes the effective redacted config to stdout and its
+// problems to stderr.
+func printConfig(args []string) int {
+	cfg := loadConfig("gophermart config print", args)
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid config:", err)
+		return 1
+	}
+	return 0
+}
 
-	err := server.StartServer(cfg)
-	log.Error().Err(err).Msg("fail start server")
+func loadConfig(name string, args []string) config.GopherMartCfg {
+	cfg, err := config.Load(name, args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return cfg
 }
@@ -0,0 +1,370 @@
+package accrualmock
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/joeljunstrom/go-luhn"
+)
+
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
+const (
+	RewardPercent = "%"
+	RewardPoints  = "pt"
+)
+
+type Goods struct {
+	Match      string  `json:"match"`
+	Reward     float64 `json:"reward"`
+	RewardType string  `json:"reward_type"`
+}
+
+type Item struct {
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+type Order struct {
+	Order string `json:"order"`
+	Goods []Item `json:"goods"`
+}
+
+type Response struct {
+	Order   string   `json:"order"`
+	Status  string   `json:"status"`
+	Accrual *float64 `json:"accrual,omitempty"`
+}
+
+// Step is one scripted answer for GET /api/orders/{number}. Code 0 means 200,
+// an empty Status means the order's regular lifecycle status.
+type Step struct {
+	Code       int      `json:"code,omitempty"`
+	Status     string   `json:"status,omitempty"`
+	Accrual    *float64 `json:"accrual,omitempty"`
+	DelayMs    int      `json:"delay_ms,omitempty"`
+	RetryAfter int      `json:"retry_after,omitempty"`
+}
+
+// Fault replaces the next Count answers of GET /api/orders/{number} for any
+// order. Count <= 0 keeps the fault until faults are cleared.
+type Fault struct {
+	Code       int `json:"code"`
+	Count      int `json:"count"`
+	DelayMs    int `json:"delay_ms,omitempty"`
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+type order struct {
+	goods  []Item
+	status string
+}
+
+type Server struct {
+	Latency time.Duration
+
+	mu       sync.Mutex
+	goods    []Goods
+	orders   map[string]*order
+	scripts  map[string][]Step
+	faults   []Fault
+	requests map[string]int
+	router   chi.Router
+}
+
+func New() *Server {
+	s := &Server{}
+	s.Reset()
+
+	r := chi.NewRouter()
+	r.Get("/api/orders/{number}", s.getOrder)
+	r.Post("/api/orders", s.postOrder)
+	r.Post("/api/goods", s.postGoods)
+
+	r.Route("/api/mock", func(r chi.Router) {
+		r.Delete("/", s.reset)
+		r.Put("/orders/{number}/script", s.putScript)
+		r.Post("/faults", s.postFault)
+		r.Delete("/faults", s.deleteFaults)
+		r.Get("/requests/{number}", s.getRequests)
+	})
+	s.router = r
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.goods = nil
+	s.orders = map[string]*order{}
+	s.scripts = map[string][]Step{}
+	s.faults = nil
+	s.requests = map[string]int{}
+}
+
+func (s *Server) AddGoods(g Goods) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.goods = append(s.goods, g)
+}
+
+func (s *Server) RegisterOrder(o Order) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[o.Order]; ok {
+		return false
+	}
+	s.orders[o.Order] = &order{
+		goods:  o.Goods,
+		status: StatusRegistered,
+	}
+	return true
+}
+
+func (s *Server) Script(number string, steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[number] = steps
+}
+
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, f)
+}
+
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+func (s *Server) Requests(number string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[number]
+}
+
+// next decides the answer for a poll of number and advances the order state.
+func (s *Server) next(number string) Step {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[number]++
+
+	if len(s.faults) > 0 {
+		f := &s.faults[0]
+		step := Step{Code: f.Code, DelayMs: f.DelayMs, RetryAfter: f.RetryAfter}
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				s.faults = s.faults[1:]
+			}
+		}
+		return step
+	}
+
+	var step Step
+	if steps, ok := s.scripts[number]; ok && len(steps) > 0 {
+		step = steps[0]
+		if len(steps) > 1 {
+			s.scripts[number] = steps[1:]
+		}
+		if step.Code != 0 && step.Code != http.StatusOK {
+			return step
+		}
+		if step.Status != "" {
+			return step
+		}
+	}
+
+	o, ok := s.orders[number]
+	if !ok {
+		return Step{Code: http.StatusNoContent, DelayMs: step.DelayMs}
+	}
+
+	step.Status = o.status
+	if o.status == StatusProcessed {
+		acc := s.accrual(o.goods)
+		step.Accrual = &acc
+	}
+
+	switch o.status {
+	case StatusRegistered:
+		o.status = StatusProcessing
+	case StatusProcessing:
+		if luhn.Valid(number) {
+			o.status = StatusProcessed
+		} else {
+			o.status = StatusInvalid
+		}
+	}
+
+	return step
+}
+
+func (s *Server) accrual(items []Item) float64 {
+	var res float64
+	for _, it := range items {
+		for _, g := range s.goods {
+			if !strings.Contains(it.Description, g.Match) {
+				continue
+			}
+			switch g.RewardType {
+			case RewardPercent:
+				res += it.Price * g.Reward / 100
+			case RewardPoints:
+				res += g.Reward
+			}
+			break
+		}
+	}
+	return math.Round(res*100) / 100
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+
+	step := s.next(number)
+
+	delay := s.Latency
+	if step.DelayMs > 0 {
+		delay = time.Duration(step.DelayMs) * time.Millisecond
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch step.Code {
+	case 0, http.StatusOK:
+	case http.StatusTooManyRequests:
+		retryAfter := step.RetryAfter
+		if retryAfter == 0 {
+			retryAfter = 60
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "No more than N requests per minute allowed", http.StatusTooManyRequests)
+		return
+	default:
+		w.WriteHeader(step.Code)
+		return
+	}
+
+	render.JSON(w, r, Response{
+		Order:   number,
+		Status:  step.Status,
+		Accrual: step.Accrual,
+	})
+}
+
+func (s *Server) postOrder(w http.ResponseWriter, r *http.Request) {
+	var o Order
+	if err := decode(r, &o); err != nil || o.Order == "" {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	if !s.RegisterOrder(o) {
+		http.Error(w, "", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) postGoods(w http.ResponseWriter, r *http.Request) {
+	var g Goods
+	if err := decode(r, &g); err != nil || g.Match == "" {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	if g.RewardType != RewardPercent && g.RewardType != RewardPoints {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	s.AddGoods(g)
+}
+
+func (s *Server) putScript(w http.ResponseWriter, r *http.Request) {
+	var steps []Step
+	if err := decode(r, &steps); err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	s.Script(chi.URLParam(r, "number"), steps...)
+}
+
+func (s *Server) postFault(w http.ResponseWriter, r *http.Request) {
+	var f Fault
+	if err := decode(r, &f); err != nil || f.Code == 0 {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	s.InjectFault(f)
+}
+
+func (s *Server) deleteFaults(w http.ResponseWriter, r *http.Request) {
+	s.ClearFaults()
+}
+
+func (s *Server) reset(w http.ResponseWriter, r *http.Request) {
+	s.Reset()
+}
+
+func (s *Server) getRequests(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, map[string]int{"requests": s.Requests(chi.URLParam(r, "number"))})
+}
+
+func decode(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+type Fixture struct {
+	Goods   []Goods           `json:"goods"`
+	Orders  []Order           `json:"orders"`
+	Scripts map[string][]Step `json:"scripts"`
+	Faults  []Fault           `json:"faults"`
+}
+
+func (s *Server) Load(f Fixture) {
+	for _, g := range f.Goods {
+		s.AddGoods(g)
+	}
+	for _, o := range f.Orders {
+		s.RegisterOrder(o)
+	}
+	for number, steps := range f.Scripts {
+		s.Script(number, steps...)
+	}
+	for _, fl := range f.Faults {
+		s.InjectFault(fl)
+	}
+}
@@ -0,0 +1,52 @@
+package accrualmock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetOrder(t *testing.T) {
+	s := New()
+	s.AddGoods(Goods{Match: "Bork", Reward: 10, RewardType: RewardPercent})
+	s.RegisterOrder(Order{
+		Order: "12345678903",
+		Goods: []Item{{Description: "Чайник Bork", Price: 7000}},
+	})
+	s.RegisterOrder(Order{Order: "12345678904"})
+
+	for _, tt := range []struct {
+		name       string
+		number     string
+		fault      *Fault
+		code       int
+		body       string
+		retryAfter string
+	}{
+		{name: "registered", number: "12345678903", code: http.StatusOK, body: `{"order":"12345678903","status":"REGISTERED"}`},
+		{name: "processing", number: "12345678903", code: http.StatusOK, body: `{"order":"12345678903","status":"PROCESSING"}`},
+		{name: "processed", number: "12345678903", code: http.StatusOK, body: `{"order":"12345678903","status":"PROCESSED","accrual":700}`},
+		{name: "unknown", number: "2377225624", code: http.StatusNoContent},
+		{name: "rate limited", number: "12345678904", fault: &Fault{Code: http.StatusTooManyRequests, Count: 1, RetryAfter: 7}, code: http.StatusTooManyRequests, retryAfter: "7"},
+		{name: "rate limit default", number: "12345678904", fault: &Fault{Code: http.StatusTooManyRequests, Count: 1}, code: http.StatusTooManyRequests, retryAfter: "60"},
+		{name: "after the fault", number: "12345678904", code: http.StatusOK, body: `{"order":"12345678904","status":"REGISTERED"}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fault != nil {
+				s.InjectFault(*tt.fault)
+			}
+			wr := httptest.NewRecorder()
+			s.ServeHTTP(wr, httptest.NewRequest(http.MethodGet, "/api/orders/"+tt.number, nil))
+			if wr.Code != tt.code {
+				t.Fatalf("wrong code %d, want %d", wr.Code, tt.code)
+			}
+			if got := strings.TrimSpace(wr.Body.String()); tt.body != "" && got != tt.body {
+				t.Errorf("wrong body %s, want %s", got, tt.body)
+			}
+			if got := wr.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("wrong Retry-After %q, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
@@ -1,33 +1,154 @@
 package config
 
 import (
-	"flag"
-
-	"github.com/caarlos0/env/v6"
+	"time"
 )
 
+// GopherMartCfg is the whole configuration. Every field can be set in the
+// config file, by its environment variable and by its flag, see Load.
 type GopherMartCfg struct {
-	RunAddress           string `env:"RUN_ADDRESS"`
-	DatabaseURI          string `env:"DATABASE_URI"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	HTTP    HTTPCfg    `yaml:"http" toml:"http"`
+	DB      DBCfg      `yaml:"db" toml:"db"`
+	Updater UpdaterCfg `yaml:"updater" toml:"updater"`
+	Auth    AuthCfg    `yaml:"auth" toml:"auth"`
+	Accrual AccrualCfg `yaml:"accrual" toml:"accrual"`
+	Outbox  OutboxCfg  `yaml:"outbox" toml:"outbox"`
+	Partner PartnerCfg `yaml:"partner" toml:"partner"`
+	Log     LogCfg     `yaml:"log" toml:"log"`
+	Trace   TraceCfg   `yaml:"trace" toml:"trace"`
 }
 
-var (
-	cfg    GopherMartCfg
-	inited bool
-)
+type HTTPCfg struct {
+	RunAddress    string `env:"RUN_ADDRESS" yaml:"address" toml:"address"`
+	CompressLevel int    `env:"HTTP_COMPRESS_LEVEL" yaml:"compress_level" toml:"compress_level"`
+
+	BodyLimit            int64 `env:"BODY_LIMIT" yaml:"body_limit" toml:"body_limit"`
+	OrdersBatchBodyLimit int64 `env:"ORDERS_BATCH_BODY_LIMIT" yaml:"orders_batch_body_limit" toml:"orders_batch_body_limit"`
+	OrdersBatchLimit     int   `env:"ORDERS_BATCH_LIMIT" yaml:"orders_batch_limit" toml:"orders_batch_limit"`
+
+	OpenAPIValidate bool `env:"OPENAPI_VALIDATE" yaml:"openapi_validate" toml:"openapi_validate"`
+
+	// ShutdownDelay keeps serving after readiness fails, ShutdownTimeout
+	// bounds draining the requests in flight.
+	ShutdownDelay   time.Duration `env:"SHUTDOWN_DELAY" yaml:"shutdown_delay" toml:"shutdown_delay"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+type DBCfg struct {
+	URI string `env:"DATABASE_URI" yaml:"uri" toml:"uri"`
+	// ReplicaURI serves the read-only queries that tolerate replication
+	// lag, empty sends them to URI.
+	ReplicaURI string `env:"DATABASE_REPLICA_URI" yaml:"replica_uri" toml:"replica_uri"`
+	// StatementTimeout bounds every statement, zero turns the bound off.
+	StatementTimeout time.Duration `env:"DB_STATEMENT_TIMEOUT" yaml:"statement_timeout" toml:"statement_timeout"`
+
+	MaxOpenConns int `env:"DB_MAX_OPEN_CONNS" yaml:"max_open_conns" toml:"max_open_conns"`
+	// MinConns connections are kept open even when idle.
+	MinConns        int           `env:"DB_MIN_CONNS" yaml:"min_conns" toml:"min_conns"`
+	ConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME" yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `env:"DB_CONN_MAX_IDLE_TIME" yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+	// QueryExecMode is how pgx sends queries: cache_statement,
+	// cache_describe, describe_exec, exec or simple_protocol.
+	QueryExecMode string `env:"DB_QUERY_EXEC_MODE" yaml:"query_exec_mode" toml:"query_exec_mode"`
+}
+
+type UpdaterCfg struct {
+	// PollInterval is the pause between sweeps, ReconcileInterval replaces
+	// it when the accrual system pushes updates.
+	PollInterval      time.Duration `env:"ACCRUAL_POLL_INTERVAL" yaml:"poll_interval" toml:"poll_interval"`
+	ReconcileInterval time.Duration `env:"ACCRUAL_RECONCILE_INTERVAL" yaml:"reconcile_interval" toml:"reconcile_interval"`
+	ErrorBackoff      time.Duration `env:"UPDATER_ERROR_BACKOFF" yaml:"error_backoff" toml:"error_backoff"`
+	RateLimitBackoff  time.Duration `env:"UPDATER_RATE_LIMIT_BACKOFF" yaml:"rate_limit_backoff" toml:"rate_limit_backoff"`
+	// StaleAfter is how long the updater may make no progress before
+	// readiness fails, zero picks it from the intervals.
+	StaleAfter time.Duration `env:"UPDATER_STALE_AFTER" yaml:"stale_after" toml:"stale_after"`
+	// Concurrency is how many orders are checked at once.
+	Concurrency int `env:"UPDATER_CONCURRENCY" yaml:"concurrency" toml:"concurrency"`
+}
+
+type AuthCfg struct {
+	// JWTSecret signs session tokens, required.
+	JWTSecret string `env:"JWT_SECRET" yaml:"jwt_secret" toml:"jwt_secret"`
+	// PasswordSecret keys the password hashes, required; changing it locks
+	// every user out.
+	PasswordSecret string        `env:"PASSWORD_SECRET" yaml:"password_secret" toml:"password_secret"`
+	CookieTTL      time.Duration `env:"AUTH_COOKIE_TTL" yaml:"cookie_ttl" toml:"cookie_ttl"`
+	AdminToken     string        `env:"ADMIN_TOKEN" yaml:"admin_token" toml:"admin_token"`
+}
+
+type AccrualCfg struct {
+	Address        string        `env:"ACCRUAL_SYSTEM_ADDRESS" yaml:"address" toml:"address"`
+	CallbackSecret string        `env:"ACCRUAL_CALLBACK_SECRET" yaml:"callback_secret" toml:"callback_secret"`
+	Timeout        time.Duration `env:"ACCRUAL_TIMEOUT" yaml:"timeout" toml:"timeout"`
+}
+
+type OutboxCfg struct {
+	WebhookURL    string `env:"OUTBOX_WEBHOOK_URL" yaml:"webhook_url" toml:"webhook_url"`
+	WebhookSecret string `env:"OUTBOX_WEBHOOK_SECRET" yaml:"webhook_secret" toml:"webhook_secret"`
+	File          string `env:"OUTBOX_FILE" yaml:"file" toml:"file"`
+	Stdout        bool   `env:"OUTBOX_STDOUT" yaml:"stdout" toml:"stdout"`
+}
+
+type PartnerCfg struct {
+	// RateLimit calls a second on average and RateBurst at once are served
+	// per merchant.
+	RateLimit    float64       `env:"PARTNER_RATE_LIMIT" yaml:"rate_limit" toml:"rate_limit"`
+	RateBurst    int           `env:"PARTNER_RATE_BURST" yaml:"rate_burst" toml:"rate_burst"`
+	LinkTokenTTL time.Duration `env:"PARTNER_LINK_TOKEN_TTL" yaml:"link_token_ttl" toml:"link_token_ttl"`
+}
+
+type LogCfg struct {
+	Level  string `env:"LOG_LEVEL" yaml:"level" toml:"level"`
+	Format string `env:"LOG_FORMAT" yaml:"format" toml:"format"`
+	Sample uint   `env:"LOG_SAMPLE" yaml:"sample" toml:"sample"`
+}
+
+type TraceCfg struct {
+	Exporter string `env:"TRACE_EXPORTER" yaml:"exporter" toml:"exporter"`
+	File     string `env:"TRACE_FILE" yaml:"file" toml:"file"`
+}
 
-func GetConfig() GopherMartCfg {
-	if !inited {
-		flag.StringVar(&(cfg.RunAddress), "a", "localhost:8081", "RUN_ADDRESS")
-		flag.StringVar(&(cfg.DatabaseURI), "d", "", "DATABASE_URI")
-		flag.StringVar(&(cfg.AccrualSystemAddress), "r", "", "ACCRUAL_SYSTEM_ADDRESS")
-
-		flag.Parse()
-		if err := env.Parse(&cfg); err != nil {
-			panic(err)
-		}
-		inited = true
+// Default is the configuration before the file, environment and flags.
+func Default() GopherMartCfg {
+	return GopherMartCfg{
+		HTTP: HTTPCfg{
+			RunAddress:           "localhost:8081",
+			CompressLevel:        5,
+			BodyLimit:            16 << 10,
+			OrdersBatchBodyLimit: 1 << 20,
+			OrdersBatchLimit:     1000,
+			OpenAPIValidate:      true,
+			ShutdownTimeout:      5 * time.Second,
+		},
+		DB: DBCfg{
+			StatementTimeout: 30 * time.Second,
+			MaxOpenConns:     20,
+			MinConns:         2,
+			ConnMaxLifetime:  time.Hour,
+			ConnMaxIdleTime:  5 * time.Minute,
+			QueryExecMode:    "cache_describe",
+		},
+		Updater: UpdaterCfg{
+			PollInterval:      time.Second,
+			ReconcileInterval: time.Minute,
+			ErrorBackoff:      time.Second,
+			RateLimitBackoff:  time.Minute,
+			Concurrency:       1,
+		},
+		Auth: AuthCfg{
+			CookieTTL: 24 * time.Hour,
+		},
+		Accrual: AccrualCfg{
+			Timeout: 10 * time.Second,
+		},
+		Partner: PartnerCfg{
+			RateLimit:    10,
+			RateBurst:    20,
+			LinkTokenTTL: 15 * time.Minute,
+		},
+		Log: LogCfg{
+			Level:  "info",
+			Format: "json",
+		},
 	}
-	return cfg
 }
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvFile names the config file when the -config flag is not given.
+const EnvFile = "CONFIG_FILE"
+
+// Load builds the configuration from, in increasing precedence: Default,
+// the YAML or TOML file named by -config or CONFIG_FILE, the environment
+// and the flags in args. It doesn't validate the result.
+func Load(name string, args []string) (GopherMartCfg, error) {
+	return load(name, args, envMap(os.Environ()))
+}
+
+func load(name string, args []string, environ map[string]string) (GopherMartCfg, error) {
+	// The first pass only finds the file, the flags are applied again on
+	// top of it and the environment.
+	probe := Default()
+	fs := newFlagSet(name, &probe)
+	file := fs.String("config", environ[EnvFile], "config file, .yaml, .yml or .toml ("+EnvFile+")")
+	if err := fs.Parse(args); err != nil {
+		return GopherMartCfg{}, err
+	}
+
+	cfg := Default()
+	if *file != "" {
+		if err := readFile(*file, &cfg); err != nil {
+			return GopherMartCfg{}, err
+		}
+	}
+
+	if err := env.Parse(&cfg, env.Options{Environment: environ}); err != nil {
+		return GopherMartCfg{}, err
+	}
+
+	fs = newFlagSet(name, &cfg)
+	fs.String("config", "", "")
+	if err := fs.Parse(args); err != nil {
+		return GopherMartCfg{}, err
+	}
+	return cfg, nil
+}
+
+func readFile(path string, cfg *GopherMartCfg) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error read config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("error parse config %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("error parse config %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, k := range undecoded {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			return fmt.Errorf("error parse config %s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return errors.New("config must be .yaml, .yml or .toml: " + path)
+	}
+	return nil
+}
+
+// newFlagSet binds the flags to cfg, the values in cfg are the defaults.
+func newFlagSet(name string, cfg *GopherMartCfg) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.StringVar(&cfg.HTTP.RunAddress, "a", cfg.HTTP.RunAddress, "RUN_ADDRESS")
+	fs.IntVar(&cfg.HTTP.CompressLevel, "http-compress-level", cfg.HTTP.CompressLevel, "HTTP_COMPRESS_LEVEL")
+	fs.Int64Var(&cfg.HTTP.BodyLimit, "body-limit", cfg.HTTP.BodyLimit, "BODY_LIMIT")
+	fs.Int64Var(&cfg.HTTP.OrdersBatchBodyLimit, "orders-batch-body-limit", cfg.HTTP.OrdersBatchBodyLimit, "ORDERS_BATCH_BODY_LIMIT")
+	fs.IntVar(&cfg.HTTP.OrdersBatchLimit, "orders-batch-limit", cfg.HTTP.OrdersBatchLimit, "ORDERS_BATCH_LIMIT")
+	fs.BoolVar(&cfg.HTTP.OpenAPIValidate, "openapi-validate", cfg.HTTP.OpenAPIValidate, "OPENAPI_VALIDATE")
+	fs.DurationVar(&cfg.HTTP.ShutdownDelay, "shutdown-delay", cfg.HTTP.ShutdownDelay, "SHUTDOWN_DELAY")
+	fs.DurationVar(&cfg.HTTP.ShutdownTimeout, "shutdown-timeout", cfg.HTTP.ShutdownTimeout, "SHUTDOWN_TIMEOUT")
+
+	fs.StringVar(&cfg.DB.URI, "d", cfg.DB.URI, "DATABASE_URI")
+	fs.StringVar(&cfg.DB.ReplicaURI, "database-replica-uri", cfg.DB.ReplicaURI, "DATABASE_REPLICA_URI")
+	fs.DurationVar(&cfg.DB.StatementTimeout, "db-statement-timeout", cfg.DB.StatementTimeout, "DB_STATEMENT_TIMEOUT")
+	fs.IntVar(&cfg.DB.MaxOpenConns, "db-max-open-conns", cfg.DB.MaxOpenConns, "DB_MAX_OPEN_CONNS")
+	fs.IntVar(&cfg.DB.MinConns, "db-min-conns", cfg.DB.MinConns, "DB_MIN_CONNS")
+	fs.DurationVar(&cfg.DB.ConnMaxLifetime, "db-conn-max-lifetime", cfg.DB.ConnMaxLifetime, "DB_CONN_MAX_LIFETIME")
+	fs.DurationVar(&cfg.DB.ConnMaxIdleTime, "db-conn-max-idle-time", cfg.DB.ConnMaxIdleTime, "DB_CONN_MAX_IDLE_TIME")
+	fs.StringVar(&cfg.DB.QueryExecMode, "db-query-exec-mode", cfg.DB.QueryExecMode, "DB_QUERY_EXEC_MODE")
+
+	fs.DurationVar(&cfg.Updater.PollInterval, "accrual-poll-interval", cfg.Updater.PollInterval, "ACCRUAL_POLL_INTERVAL")
+	fs.DurationVar(&cfg.Updater.ReconcileInterval, "accrual-reconcile-interval", cfg.Updater.ReconcileInterval, "ACCRUAL_RECONCILE_INTERVAL")
+	fs.DurationVar(&cfg.Updater.ErrorBackoff, "updater-error-backoff", cfg.Updater.ErrorBackoff, "UPDATER_ERROR_BACKOFF")
+	fs.DurationVar(&cfg.Updater.RateLimitBackoff, "updater-rate-limit-backoff", cfg.Updater.RateLimitBackoff, "UPDATER_RATE_LIMIT_BACKOFF")
+	fs.DurationVar(&cfg.Updater.StaleAfter, "updater-stale-after", cfg.Updater.StaleAfter, "UPDATER_STALE_AFTER")
+	fs.IntVar(&cfg.Updater.Concurrency, "updater-concurrency", cfg.Updater.Concurrency, "UPDATER_CONCURRENCY")
+
+	fs.StringVar(&cfg.Auth.JWTSecret, "jwt-secret", cfg.Auth.JWTSecret, "JWT_SECRET")
+	fs.StringVar(&cfg.Auth.PasswordSecret, "password-secret", cfg.Auth.PasswordSecret, "PASSWORD_SECRET")
+	fs.DurationVar(&cfg.Auth.CookieTTL, "auth-cookie-ttl", cfg.Auth.CookieTTL, "AUTH_COOKIE_TTL")
+	fs.StringVar(&cfg.Auth.AdminToken, "admin-token", cfg.Auth.AdminToken, "ADMIN_TOKEN")
+
+	fs.StringVar(&cfg.Accrual.Address, "r", cfg.Accrual.Address, "ACCRUAL_SYSTEM_ADDRESS")
+	fs.StringVar(&cfg.Accrual.CallbackSecret, "accrual-callback-secret", cfg.Accrual.CallbackSecret, "ACCRUAL_CALLBACK_SECRET")
+	fs.DurationVar(&cfg.Accrual.Timeout, "accrual-timeout", cfg.Accrual.Timeout, "ACCRUAL_TIMEOUT")
+
+	fs.StringVar(&cfg.Outbox.WebhookURL, "outbox-webhook-url", cfg.Outbox.WebhookURL, "OUTBOX_WEBHOOK_URL")
+	fs.StringVar(&cfg.Outbox.WebhookSecret, "outbox-webhook-secret", cfg.Outbox.WebhookSecret, "OUTBOX_WEBHOOK_SECRET")
+	fs.StringVar(&cfg.Outbox.File, "outbox-file", cfg.Outbox.File, "OUTBOX_FILE")
+	fs.BoolVar(&cfg.Outbox.Stdout, "outbox-stdout", cfg.Outbox.Stdout, "OUTBOX_STDOUT")
+
+	fs.Float64Var(&cfg.Partner.RateLimit, "partner-rate-limit", cfg.Partner.RateLimit, "PARTNER_RATE_LIMIT")
+	fs.IntVar(&cfg.Partner.RateBurst, "partner-rate-burst", cfg.Partner.RateBurst, "PARTNER_RATE_BURST")
+	fs.DurationVar(&cfg.Partner.LinkTokenTTL, "partner-link-token-ttl", cfg.Partner.LinkTokenTTL, "PARTNER_LINK_TOKEN_TTL")
+
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "LOG_LEVEL")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "LOG_FORMAT")
+	fs.UintVar(&cfg.Log.Sample, "log-sample", cfg.Log.Sample, "LOG_SAMPLE")
+
+	fs.StringVar(&cfg.Trace.Exporter, "trace-exporter", cfg.Trace.Exporter, "TRACE_EXPORTER")
+	fs.StringVar(&cfg.Trace.File, "trace-file", cfg.Trace.File, "TRACE_FILE")
+
+	return fs
+}
+
+func envMap(environ []string) map[string]string {
+	res := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			res[k] = v
+		}
+	}
+	return res
+}
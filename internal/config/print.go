@@ -0,0 +1,62 @@
+package config
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted returns a copy of c with secrets and URL passwords replaced.
+func (c GopherMartCfg) Redacted() GopherMartCfg {
+	c.DB.URI = redactURI(c.DB.URI)
+	c.DB.ReplicaURI = redactURI(c.DB.ReplicaURI)
+	c.Auth.JWTSecret = redactSecret(c.Auth.JWTSecret)
+	c.Auth.PasswordSecret = redactSecret(c.Auth.PasswordSecret)
+	c.Auth.AdminToken = redactSecret(c.Auth.AdminToken)
+	c.Accrual.Address = redactURI(c.Accrual.Address)
+	c.Accrual.CallbackSecret = redactSecret(c.Accrual.CallbackSecret)
+	c.Outbox.WebhookURL = redactURI(c.Outbox.WebhookURL)
+	c.Outbox.WebhookSecret = redactSecret(c.Outbox.WebhookSecret)
+	return c
+}
+
+// Print writes the redacted configuration as YAML, the format of the
+// config file.
+func (c GopherMartCfg) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactURI hides the password of a URL or of a key=value Postgres DSN.
+func redactURI(s string) string {
+	u, err := url.Parse(s)
+	if err == nil && u.Scheme != "" {
+		if _, set := u.User.Password(); set {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(s, "${1}"+redacted)
+}
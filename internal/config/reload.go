@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+// Loader rereads the configuration the process was started with.
+type Loader func() (GopherMartCfg, error)
+
+// reloadable are the settings applied without a restart, by their keys in
+// the config file. There are no feature flags: the features are switched by
+// their own settings, http.openapi_validate among these, the others such as
+// accrual.callback_secret and auth.admin_token need a restart.
+var reloadable = map[string]bool{
+	"http.body_limit":              true,
+	"http.orders_batch_body_limit": true,
+	"http.orders_batch_limit":      true,
+	"http.openapi_validate":        true,
+
+	"updater.poll_interval":      true,
+	"updater.reconcile_interval": true,
+	"updater.error_backoff":      true,
+	"updater.rate_limit_backoff": true,
+	"updater.stale_after":        true,
+	"updater.concurrency":        true,
+
+	// the limiter is retuned, the buckets keep their tokens
+	"partner.rate_limit":     true,
+	"partner.rate_burst":     true,
+	"partner.link_token_ttl": true,
+
+	"log.level":  true,
+	"log.sample": true,
+}
+
+// Reload returns cur with the reloadable settings taken from next. applied
+// lists what changed, ignored the keys of the changed settings that need
+// a restart.
+func Reload(cur, next GopherMartCfg) (res GopherMartCfg, applied []models.ConfigChange, ignored []string) {
+	res = cur
+	resV := reflect.ValueOf(&res).Elem()
+	nextV := reflect.ValueOf(next)
+
+	for i := 0; i < resV.NumField(); i++ {
+		section := resV.Type().Field(i).Tag.Get("yaml")
+		curS, nextS := resV.Field(i), nextV.Field(i)
+		for j := 0; j < curS.NumField(); j++ {
+			key := section + "." + curS.Type().Field(j).Tag.Get("yaml")
+			oldF, newF := curS.Field(j), nextS.Field(j)
+			if oldF.Interface() == newF.Interface() {
+				continue
+			}
+			if !reloadable[key] {
+				ignored = append(ignored, key)
+				continue
+			}
+			applied = append(applied, models.ConfigChange{
+				Key: key,
+				Old: fmt.Sprint(oldF.Interface()),
+				New: fmt.Sprint(newF.Interface()),
+			})
+			oldF.Set(newF)
+		}
+	}
+	return res, applied, ignored
+}
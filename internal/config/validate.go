@@ -0,0 +1,140 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Validate reports every problem of the configuration at once.
+func (c GopherMartCfg) Validate() error {
+	var res error
+	fail := func(format string, args ...interface{}) {
+		res = multierror.Append(res, fmt.Errorf(format, args...))
+	}
+
+	if c.HTTP.RunAddress == "" {
+		fail("http.address (RUN_ADDRESS) is required")
+	}
+	if c.HTTP.CompressLevel < 1 || c.HTTP.CompressLevel > 9 {
+		fail("http.compress_level (HTTP_COMPRESS_LEVEL) must be 1..9, got %d", c.HTTP.CompressLevel)
+	}
+	if c.HTTP.BodyLimit <= 0 {
+		fail("http.body_limit (BODY_LIMIT) must be positive")
+	}
+	if c.HTTP.OrdersBatchBodyLimit <= 0 {
+		fail("http.orders_batch_body_limit (ORDERS_BATCH_BODY_LIMIT) must be positive")
+	}
+	if c.HTTP.OrdersBatchLimit <= 0 {
+		fail("http.orders_batch_limit (ORDERS_BATCH_LIMIT) must be positive")
+	}
+	nonNegative(fail, "http.shutdown_delay (SHUTDOWN_DELAY)", c.HTTP.ShutdownDelay)
+	positive(fail, "http.shutdown_timeout (SHUTDOWN_TIMEOUT)", c.HTTP.ShutdownTimeout)
+
+	if c.DB.URI == "" {
+		fail("db.uri (DATABASE_URI) is required")
+	}
+	if c.DB.MaxOpenConns < 0 || c.DB.MinConns < 0 {
+		fail("db.max_open_conns and db.min_conns must not be negative")
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MinConns > c.DB.MaxOpenConns {
+		fail("db.min_conns (DB_MIN_CONNS) must not exceed db.max_open_conns (DB_MAX_OPEN_CONNS)")
+	}
+	if c.DB.StatementTimeout < 0 || (c.DB.StatementTimeout > 0 && c.DB.StatementTimeout < time.Millisecond) {
+		fail("db.statement_timeout (DB_STATEMENT_TIMEOUT) must be 0 or at least 1ms, got %s", c.DB.StatementTimeout)
+	}
+	nonNegative(fail, "db.conn_max_lifetime (DB_CONN_MAX_LIFETIME)", c.DB.ConnMaxLifetime)
+	nonNegative(fail, "db.conn_max_idle_time (DB_CONN_MAX_IDLE_TIME)", c.DB.ConnMaxIdleTime)
+	switch c.DB.QueryExecMode {
+	case "cache_statement", "cache_describe", "describe_exec", "exec", "simple_protocol":
+	default:
+		fail("db.query_exec_mode (DB_QUERY_EXEC_MODE) must be cache_statement, cache_describe, describe_exec, exec or simple_protocol, got %q", c.DB.QueryExecMode)
+	}
+
+	positive(fail, "updater.poll_interval (ACCRUAL_POLL_INTERVAL)", c.Updater.PollInterval)
+	positive(fail, "updater.reconcile_interval (ACCRUAL_RECONCILE_INTERVAL)", c.Updater.ReconcileInterval)
+	positive(fail, "updater.error_backoff (UPDATER_ERROR_BACKOFF)", c.Updater.ErrorBackoff)
+	positive(fail, "updater.rate_limit_backoff (UPDATER_RATE_LIMIT_BACKOFF)", c.Updater.RateLimitBackoff)
+	nonNegative(fail, "updater.stale_after (UPDATER_STALE_AFTER)", c.Updater.StaleAfter)
+	if c.Updater.Concurrency < 1 {
+		fail("updater.concurrency (UPDATER_CONCURRENCY) must be at least 1, got %d", c.Updater.Concurrency)
+	}
+
+	if c.Auth.JWTSecret == "" {
+		fail("auth.jwt_secret (JWT_SECRET) is required")
+	}
+	if c.Auth.PasswordSecret == "" {
+		fail("auth.password_secret (PASSWORD_SECRET) is required, set it to \"secret\" to keep the passwords hashed by releases that defaulted to it")
+	}
+	positive(fail, "auth.cookie_ttl (AUTH_COOKIE_TTL)", c.Auth.CookieTTL)
+
+	if err := checkURL(c.Accrual.Address); err != nil {
+		fail("accrual.address (ACCRUAL_SYSTEM_ADDRESS): %v", err)
+	}
+	positive(fail, "accrual.timeout (ACCRUAL_TIMEOUT)", c.Accrual.Timeout)
+
+	if c.Outbox.WebhookURL != "" {
+		if err := checkURL(c.Outbox.WebhookURL); err != nil {
+			fail("outbox.webhook_url (OUTBOX_WEBHOOK_URL): %v", err)
+		}
+	}
+
+	if c.Partner.RateLimit <= 0 {
+		fail("partner.rate_limit (PARTNER_RATE_LIMIT) must be positive, got %g", c.Partner.RateLimit)
+	}
+	if c.Partner.RateBurst < 1 {
+		fail("partner.rate_burst (PARTNER_RATE_BURST) must be at least 1, got %d", c.Partner.RateBurst)
+	}
+	positive(fail, "partner.link_token_ttl (PARTNER_LINK_TOKEN_TTL)", c.Partner.LinkTokenTTL)
+
+	switch c.Log.Format {
+	case "json", "console":
+	default:
+		fail("log.format (LOG_FORMAT) must be json or console, got %q", c.Log.Format)
+	}
+
+	switch c.Trace.Exporter {
+	case "", "otlp":
+	case "file":
+		if c.Trace.File == "" {
+			fail("trace.file (TRACE_FILE) is required for the file exporter")
+		}
+	default:
+		fail("trace.exporter (TRACE_EXPORTER) must be otlp or file, got %q", c.Trace.Exporter)
+	}
+
+	return res
+}
+
+// checkURL accepts absolute http and https URLs.
+func checkURL(raw string) error {
+	if raw == "" {
+		return errors.New("is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must be an http or https URL", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	return nil
+}
+
+func positive(fail func(string, ...interface{}), name string, d time.Duration) {
+	if d <= 0 {
+		fail("%s must be positive, got %s", name, d)
+	}
+}
+
+func nonNegative(fail func(string, ...interface{}), name string, d time.Duration) {
+	if d < 0 {
+		fail("%s must not be negative, got %s", name, d)
+	}
+}
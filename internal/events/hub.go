@@ -0,0 +1,127 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+)
+
+// Subscription is woken up through C whenever new events of User may be in
+// the outbox. C is closed when the hub stops.
+type Subscription struct {
+	User string
+	C    chan struct{}
+}
+
+// Hub fans out outbox notifications of all replicas to local subscribers.
+type Hub struct {
+	Store interfaces.EventStorage
+
+	mu      sync.Mutex
+	subs    map[string]map[*Subscription]struct{}
+	stopped bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func (h *Hub) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.done = make(chan struct{})
+	h.cancel = cancel
+	go h.worker(ctx)
+}
+
+// Stop ends all subscriptions, so streaming handlers return before the
+// server shuts down.
+func (h *Hub) Stop() {
+	h.mu.Lock()
+	if h.stopped {
+		h.mu.Unlock()
+		return
+	}
+	h.stopped = true
+	for _, subs := range h.subs {
+		for s := range subs {
+			close(s.C)
+		}
+	}
+	h.subs = nil
+	h.mu.Unlock()
+
+	h.cancel()
+	<-h.done
+}
+
+func (h *Hub) Subscribe(user string) *Subscription {
+	s := &Subscription{
+		User: user,
+		C:    make(chan struct{}, 1),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopped {
+		close(s.C)
+		return s
+	}
+	if h.subs == nil {
+		h.subs = map[string]map[*Subscription]struct{}{}
+	}
+	if h.subs[user] == nil {
+		h.subs[user] = map[*Subscription]struct{}{}
+	}
+	h.subs[user][s] = struct{}{}
+	return s
+}
+
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s.User][s]; !ok {
+		return
+	}
+	delete(h.subs[s.User], s)
+	if len(h.subs[s.User]) == 0 {
+		delete(h.subs, s.User)
+	}
+	close(s.C)
+}
+
+// Notify wakes the subscribers of user, or everyone for an empty user.
+func (h *Hub) Notify(id int64, user string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for u, subs := range h.subs {
+		if user != "" && u != user {
+			continue
+		}
+		for s := range subs {
+			select {
+			case s.C <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (h *Hub) worker(ctx context.Context) {
+	for {
+		err := h.Store.ListenEvents(ctx, h.Notify)
+		if err != nil {
+			log.Error().Err(err).Msg("Hub.worker error listen events")
+		}
+
+		select {
+		case <-ctx.Done():
+			h.done <- struct{}{}
+			return
+		case <-time.After(time.Second):
+			// events may have been committed while nobody listened
+			h.Notify(0, "")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
+	"github.com/e-faizov/gophermart/internal/scores"
+	"github.com/e-faizov/gophermart/internal/signature"
+	"github.com/e-faizov/gophermart/internal/storage"
+)
+
+// Accrual receives order status changes pushed by the accrual system.
+type Accrual struct {
+	Store    interfaces.OrdersStorage
+	Verifier *signature.Verifier
+}
+
+func (a *Accrual) Callback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	sig := r.Header.Get(signature.HeaderSignature)
+	expires, err := a.Verifier.Check(r.Header.Get(signature.HeaderTimestamp), sig, body)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("Accrual.Callback wrong signature")
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidSignature, err.Error())
+		return
+	}
+
+	var data models.Scores
+	err = json.Unmarshal(body, &data)
+	if err != nil || data.Order == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "body must be a json object with order")
+		return
+	}
+
+	order, err := scores.ToOrder(data.Order, data)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, err.Error())
+		return
+	}
+
+	tx, err := a.Store.NewUpdaterTx(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Accrual.Callback error open tx")
+		problem.Internal(w, r)
+		return
+	}
+
+	// the signature is remembered with the update, a callback failed to
+	// apply is not a replay when retried
+	err = signature.Remember(ctx, tx, sig, expires)
+	if err == nil {
+		err = tx.UpdateOrder(ctx, order)
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else if errRoll := tx.Rollback(); errRoll != nil {
+		err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, signature.ErrReplay):
+		problem.Write(w, r, http.StatusConflict, problem.CodeReplay, "callback already received")
+		return
+	case errors.Is(err, storage.ErrOrderNotFound):
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "order not found")
+		return
+	default:
+		log.Ctx(ctx).Error().Err(err).Msg("Accrual.Callback error update order")
+		problem.Internal(w, r)
+		return
+	}
+
+	log.Ctx(ctx).Info().Msg("accrual callback order " + order.Number + " with status " + order.Status)
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/signature"
+	"github.com/e-faizov/gophermart/internal/storage"
+)
+
+var testCallbackSecret = []byte("callback secret")
+
+func newAccrualRouter(h *Accrual) *chi.Mux {
+	r := chi.NewRouter()
+	r.Post("/api/internal/accrual/callback", h.Callback)
+
+	return r
+}
+
+func signedCallback(t *testing.T, body string, ts time.Time, secret []byte) *http.Request {
+	req, err := http.NewRequest("POST", "/api/internal/accrual/callback", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(signature.HeaderTimestamp, strconv.FormatInt(ts.Unix(), 10))
+	req.Header.Set(signature.HeaderSignature, signature.Sign(secret, ts, []byte(body)))
+	return req
+}
+
+func TestAccrualCallbackHandler(t *testing.T) {
+	tStore := &testOrdersStore{}
+	tx := &testUpdateTx{}
+	sigStore := &testSignatureStore{}
+	tStore.newUpdaterTx = func(ctx context.Context) (interfaces.OrderUpdateTx, error) {
+		tx.sigs = sigStore
+		return tx, nil
+	}
+
+	testRouter := newAccrualRouter(&Accrual{
+		Store:    tStore,
+		Verifier: &signature.Verifier{Secret: testCallbackSecret},
+	})
+
+	body := `{"order":"12345678903","status":"PROCESSED","accrual":500}`
+
+	t.Run("OK", func(t *testing.T) {
+		*tx = testUpdateTx{}
+		req := signedCallback(t, body, time.Now(), testCallbackSecret)
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusOK {
+			t.Fatal("error, code not 200, code:", wr.Code)
+		}
+		if !tx.committed || tx.order.Number != "12345678903" || tx.order.Status != storage.OtProcessed ||
+			tx.order.Accrual == nil || *tx.order.Accrual != 500 {
+			t.Error("order not updated", tx)
+		}
+
+		wr = serveHTTP(testRouter, signedCallback(t, body, time.Now().Add(time.Second), testCallbackSecret))
+		if wr.Code != http.StatusOK {
+			t.Fatal("error, code not 200 for new timestamp, code:", wr.Code)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		req := signedCallback(t, body, time.Now().Add(-time.Second), testCallbackSecret)
+		serveHTTP(testRouter, req)
+
+		*tx = testUpdateTx{}
+		req = signedCallback(t, body, time.Now().Add(-time.Second), testCallbackSecret)
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusConflict {
+			t.Fatal("error, code not 409, code:", wr.Code)
+		}
+		if tx.committed {
+			t.Error("replayed callback applied")
+		}
+	})
+
+	t.Run("StoreError", func(t *testing.T) {
+		*tx = testUpdateTx{}
+		sigStore.err = errors.New("connection refused")
+		defer func() { sigStore.err = nil }()
+		req := signedCallback(t, body, time.Now().Add(-3*time.Second), testCallbackSecret)
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusInternalServerError {
+			t.Fatal("error, code not 500, code:", wr.Code)
+		}
+		if tx.committed {
+			t.Error("callback applied without the replay check")
+		}
+	})
+
+	t.Run("RetryAfterFailure", func(t *testing.T) {
+		*tx = testUpdateTx{err: errors.New("connection reset")}
+		ts := time.Now().Add(-4 * time.Second)
+		wr := serveHTTP(testRouter, signedCallback(t, body, ts, testCallbackSecret))
+		if wr.Code != http.StatusInternalServerError {
+			t.Fatal("error, code not 500, code:", wr.Code)
+		}
+		if !tx.rolledBack {
+			t.Error("tx not rolled back")
+		}
+
+		*tx = testUpdateTx{}
+		wr = serveHTTP(testRouter, signedCallback(t, body, ts, testCallbackSecret))
+		if wr.Code != http.StatusOK {
+			t.Fatal("retried callback rejected, code:", wr.Code)
+		}
+		if !tx.committed {
+			t.Error("retried callback not applied")
+		}
+	})
+
+	t.Run("WrongSecret", func(t *testing.T) {
+		req := signedCallback(t, body, time.Now(), []byte("other"))
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusUnauthorized {
+			t.Fatal("error, code not 401, code:", wr.Code)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		req := signedCallback(t, body, time.Now().Add(-time.Hour), testCallbackSecret)
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusUnauthorized {
+			t.Fatal("error, code not 401, code:", wr.Code)
+		}
+	})
+
+	t.Run("UnknownOrder", func(t *testing.T) {
+		*tx = testUpdateTx{err: storage.ErrOrderNotFound}
+		req := signedCallback(t, body, time.Now().Add(-2*time.Second), testCallbackSecret)
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusNotFound {
+			t.Fatal("error, code not 404, code:", wr.Code)
+		}
+		if !tx.rolledBack {
+			t.Error("tx not rolled back")
+		}
+	})
+
+	t.Run("WrongStatus", func(t *testing.T) {
+		req := signedCallback(t, `{"order":"12345678903","status":"DONE"}`, time.Now(), testCallbackSecret)
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusBadRequest {
+			t.Fatal("error, code not 400, code:", wr.Code)
+		}
+	})
+}
+
+type testSignatureStore struct {
+	seen map[string]bool
+	err  error
+}
+
+// testUpdateTx remembers signatures in sigs only once committed, as the
+// transaction of PgStore does.
+type testUpdateTx struct {
+	order      models.Order
+	err        error
+	sigs       *testSignatureStore
+	pending    []string
+	committed  bool
+	rolledBack bool
+}
+
+func (t *testUpdateTx) UpdateOrder(ctx context.Context, order models.Order) error {
+	t.order = order
+	return t.err
+}
+
+func (t *testUpdateTx) SaveSignature(ctx context.Context, sig string, expires time.Time) (bool, error) {
+	if t.sigs.err != nil {
+		return false, t.sigs.err
+	}
+	if t.sigs.seen[sig] {
+		return false, nil
+	}
+	t.pending = append(t.pending, sig)
+	return true, nil
+}
+
+func (t *testUpdateTx) Rollback() error {
+	t.rolledBack = true
+	t.pending = nil
+	return nil
+}
+
+func (t *testUpdateTx) Commit() error {
+	t.committed = true
+	if t.sigs.seen == nil {
+		t.sigs.seen = map[string]bool{}
+	}
+	for _, sig := range t.pending {
+		t.sigs.seen[sig] = true
+	}
+	t.pending = nil
+	return nil
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+// Admin serves the admin operations on the running server.
+type Admin struct {
+	Reloader interfaces.Reloader
+}
+
+// Reload rereads the configuration like SIGHUP does. A configuration that
+// fails to load or validate is answered with 422 and changes nothing.
+func (a *Admin) Reload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	res, err := a.Reloader.Reload(ctx)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("Admin.Reload error reload config")
+		problem.Write(w, r, http.StatusUnprocessableEntity, problem.CodeValidation, err.Error())
+		return
+	}
+
+	render.JSON(w, r, res)
+}
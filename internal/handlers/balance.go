@@ -1,16 +1,16 @@
 package handlers
 
 import (
-	"encoding/json"
-	"io"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/render"
-	"github.com/joeljunstrom/go-luhn"
 	"github.com/rs/zerolog/log"
 
 	"github.com/e-faizov/gophermart/internal/interfaces"
 	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
 )
 
 type Balances struct {
@@ -24,31 +24,80 @@ func (b *Balances) Balance(w http.ResponseWriter, r *http.Request) {
 
 	res, err := b.Store.BalanceByUser(ctx, userID)
 	if err != nil {
-		log.Error().Err(err).Msg("Orders.Balance error get balance by user")
-		http.Error(w, "", http.StatusInternalServerError)
+		log.Ctx(ctx).Error().Err(err).Msg("Orders.Balance error get balance by user")
+		problem.Internal(w, r)
 		return
 	}
 
 	render.JSON(w, r, res)
 }
 
+// Withdrawals returns a page of the user's withdrawals, newest first, as
+// JSON, CSV or NDJSON. The X-Page-Sum header holds the total of the page,
+// the next page is linked from the Link header.
 func (b *Balances) Withdrawals(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	userID := ctx.Value(models.UUIDKey).(string)
 
-	withdrawals, err := b.Store.WithdrawalsByUser(ctx, userID)
+	format, ok := negotiateFormat(r, formatJSON, formatCSV, formatNDJSON)
+	if !ok {
+		problem.Write(w, r, http.StatusNotAcceptable, problem.CodeNotAcceptable, "format must be json, csv or ndjson")
+		return
+	}
+
+	query, err := withdrawalsQuery(r)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, err.Error())
+		return
+	}
+
+	limit := query.Limit
+	if limit > 0 {
+		query.Limit++
+	}
+
+	withdrawals, err := b.Store.WithdrawalsByUser(ctx, userID, query)
 	if err != nil {
-		log.Error().Err(err).Msg("Orders.Withdrawals error WithdrawalsByUser")
-		http.Error(w, "", http.StatusInternalServerError)
+		log.Ctx(ctx).Error().Err(err).Msg("Orders.Withdrawals error WithdrawalsByUser")
+		problem.Internal(w, r)
 		return
 	}
 
+	var next string
+	if limit > 0 && len(withdrawals) > limit {
+		withdrawals = withdrawals[:limit]
+		last := withdrawals[limit-1]
+		next = encodeCursor(models.WithdrawalsCursor{Processed: last.Processed, Order: last.Order})
+	}
+	setPageLinks(w, r, next)
+
 	if len(withdrawals) == 0 {
-		http.Error(w, "", http.StatusNoContent)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var sum float64
+	for _, wd := range withdrawals {
+		sum += wd.Sum
 	}
+	w.Header().Set("X-Page-Sum", strconv.FormatFloat(math.Round(sum*100)/100, 'f', -1, 64))
 
-	render.JSON(w, r, withdrawals)
+	if format == formatJSON {
+		render.JSON(w, r, withdrawals)
+		return
+	}
+
+	rw, err := newRowWriter(w, format, withdrawHeader)
+	for i := 0; err == nil && i < len(withdrawals); i++ {
+		err = rw.write(withdrawals[i], withdrawRecord(withdrawals[i]))
+	}
+	if err == nil {
+		err = rw.flush()
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Orders.Withdrawals error write withdrawals")
+	}
 }
 
 func (b *Balances) Withdraw(w http.ResponseWriter, r *http.Request) {
@@ -56,35 +105,39 @@ func (b *Balances) Withdraw(w http.ResponseWriter, r *http.Request) {
 
 	userID := ctx.Value(models.UUIDKey).(string)
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Error().Err(err).Msg("Orders.Withdraw error read body")
-		http.Error(w, "wrong body", http.StatusInternalServerError)
+	if !requireContentType(w, r, "application/json") {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
 		return
 	}
 
 	var withdraw models.Withdraw
-	err = json.Unmarshal(body, &withdraw)
+	err := decodeJSON(body, &withdraw)
 	if err != nil {
-		log.Error().Err(err).Msg("Orders.Withdraw error unmarshal body")
-		http.Error(w, "wrong body", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "body must be a json object with order and sum: "+err.Error())
 		return
 	}
 
-	if !luhn.Valid(withdraw.Order) {
-		log.Error().Err(err).Msg("Orders.Withdraw error not luhn")
-		http.Error(w, "", http.StatusUnprocessableEntity)
+	if err = checkOrderNumber(withdraw.Order); err != nil {
+		problem.Write(w, r, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, err.Error())
+		return
+	}
+	if withdraw.Sum <= 0 {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "sum must be positive")
 		return
 	}
 
 	notEnough, err := b.Store.Withdraw(ctx, withdraw, userID)
 	if err != nil {
-		log.Error().Err(err).Msg("Orders.Withdraw error withdraw")
-		http.Error(w, "", http.StatusInternalServerError)
+		log.Ctx(ctx).Error().Err(err).Msg("Orders.Withdraw error withdraw")
+		problem.Internal(w, r)
 		return
 	}
 	if notEnough {
-		http.Error(w, "", http.StatusPaymentRequired)
+		problem.Write(w, r, http.StatusPaymentRequired, problem.CodeInsufficientFunds, "not enough points")
 		return
 	}
 }
@@ -16,6 +16,7 @@ import (
 
 	"github.com/e-faizov/gophermart/internal/middlewares"
 	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
 )
 
 func newBalanceRouter(h *Balances) *chi.Mux {
@@ -65,7 +66,7 @@ func TestWithdrawalsHandler(t *testing.T) {
 
 		tStore.Clear()
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
-		tStore.withdrawalsByUserFunc = func(ctx context.Context, uuid string) ([]models.Withdraw, error) {
+		tStore.withdrawalsByUserFunc = func(ctx context.Context, uuid string, query models.WithdrawalsQuery) ([]models.Withdraw, error) {
 			return data, nil
 		}
 		wr := serveHTTP(testRouter, req)
@@ -102,7 +103,7 @@ func TestWithdrawalsHandler(t *testing.T) {
 	t.Run("EmptyResult", func(t *testing.T) {
 		tStore.Clear()
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
-		tStore.withdrawalsByUserFunc = func(ctx context.Context, uuid string) ([]models.Withdraw, error) {
+		tStore.withdrawalsByUserFunc = func(ctx context.Context, uuid string, query models.WithdrawalsQuery) ([]models.Withdraw, error) {
 			return []models.Withdraw{}, nil
 		}
 		wr := serveHTTP(testRouter, req)
@@ -115,7 +116,7 @@ func TestWithdrawalsHandler(t *testing.T) {
 	t.Run("UserNotFound", func(t *testing.T) {
 		tStore.Clear()
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
-		tStore.withdrawalsByUserFunc = func(ctx context.Context, uuid string) ([]models.Withdraw, error) {
+		tStore.withdrawalsByUserFunc = func(ctx context.Context, uuid string, query models.WithdrawalsQuery) ([]models.Withdraw, error) {
 			return nil, errors.New("user not found")
 		}
 		wr := serveHTTP(testRouter, req)
@@ -128,6 +129,61 @@ func TestWithdrawalsHandler(t *testing.T) {
 	t.Run("WithoutJwt", withoutJwtTestFunc(req, testRouter))
 }
 
+func TestWithdrawalsPagination(t *testing.T) {
+	tStore := &testBalanceStore{}
+	testRouter := newBalanceRouter(&Balances{Store: tStore})
+
+	tm := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	var got models.WithdrawalsQuery
+	tStore.withdrawalsByUserFunc = func(ctx context.Context, uuid string, query models.WithdrawalsQuery) ([]models.Withdraw, error) {
+		got = query
+		return []models.Withdraw{
+			{Order: "3", Sum: 0.1, Processed: tm.Add(2 * time.Second)},
+			{Order: "2", Sum: 0.2, Processed: tm.Add(time.Second)},
+			{Order: "1", Sum: 5, Processed: tm},
+		}, nil
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serveHTTP(testRouter, req.WithContext(contextWithJwt(context.Background(), "test user")))
+	}
+
+	wr := get("/api/user/withdrawals?limit=2&from=2022-10-01T00:00:00Z&to=2022-10-02T00:00:00Z")
+	if wr.Code != http.StatusOK {
+		t.Fatal("error, code not 200, code:", wr.Code)
+	}
+	if got.Limit != 3 || got.From == nil || got.To == nil || got.After != nil {
+		t.Fatal("wrong query", got)
+	}
+	if wr.Header().Get("X-Page-Sum") != "0.3" {
+		t.Error("wrong page sum", wr.Header().Get("X-Page-Sum"))
+	}
+
+	links := parseLinks(wr.Header().Get("Link"))
+	if links["next"] == "" {
+		t.Fatal("no next page link", wr.Header().Get("Link"))
+	}
+
+	get(links["next"])
+	if got.After == nil || got.After.Order != "2" || !got.After.Processed.Equal(tm.Add(time.Second)) {
+		t.Fatal("wrong cursor", got.After)
+	}
+
+	for _, target := range []string{
+		"/api/user/withdrawals?limit=abc",
+		"/api/user/withdrawals?from=2022-10-01",
+		"/api/user/withdrawals?cursor=!!",
+	} {
+		if wr = get(target); wr.Code != http.StatusBadRequest {
+			t.Error(target, "error, code not 400, code:", wr.Code)
+		}
+	}
+}
+
 func TestWithdrawHandler(t *testing.T) {
 	method := "POST"
 	path := "/api/user/balance/withdraw"
@@ -151,6 +207,7 @@ func TestWithdrawHandler(t *testing.T) {
 			return false, nil
 		}
 
+		req.Header.Set("Content-Type", "application/json")
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
 		wr := serveHTTP(testRouter, req)
 
@@ -170,6 +227,7 @@ func TestWithdrawHandler(t *testing.T) {
 			return false, errors.New("error")
 		}
 
+		req.Header.Set("Content-Type", "application/json")
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
 		wr := serveHTTP(testRouter, req)
 
@@ -189,6 +247,7 @@ func TestWithdrawHandler(t *testing.T) {
 			return true, nil
 		}
 
+		req.Header.Set("Content-Type", "application/json")
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
 		wr := serveHTTP(testRouter, req)
 
@@ -203,6 +262,7 @@ func TestWithdrawHandler(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
+		req.Header.Set("Content-Type", "application/json")
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
 		wr := serveHTTP(testRouter, req)
 
@@ -211,17 +271,51 @@ func TestWithdrawHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("notPositive", func(t *testing.T) {
+		tStore.Clear()
+		req, err := http.NewRequest(method, path, strings.NewReader("{\"order\":\"176081\", \"sum\":-1.0}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusBadRequest {
+			t.Fatal("error, code not 400, code:", wr.Code)
+		}
+	})
+
 	t.Run("notJSON", func(t *testing.T) {
 		tStore.Clear()
 		req, err := http.NewRequest(method, path, strings.NewReader("{)"))
 		if err != nil {
 			t.Fatal(err)
 		}
+		req.Header.Set("Content-Type", "application/json")
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
 		wr := serveHTTP(testRouter, req)
 
-		if wr.Code != http.StatusInternalServerError {
-			t.Fatal("error, code not 500, code:", wr.Code)
+		if wr.Code != http.StatusBadRequest {
+			t.Fatal("error, code not 400, code:", wr.Code)
+		}
+		if wr.Header().Get("Content-Type") != problem.ContentType {
+			t.Error("wrong content type", wr.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("notJSONContentType", func(t *testing.T) {
+		tStore.Clear()
+		req, err := http.NewRequest(method, path, strings.NewReader("{\"order\":\"176081\", \"sum\":1.0}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusUnsupportedMediaType {
+			t.Fatal("error, code not 415, code:", wr.Code)
 		}
 	})
 
@@ -249,6 +343,7 @@ func TestBalanceHandler(t *testing.T) {
 
 	t.Run("OK", func(t *testing.T) {
 		tStore.Clear()
+		req.Header.Set("Content-Type", "application/json")
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
 
 		data := models.Balance{
@@ -292,6 +387,7 @@ func TestBalanceHandler(t *testing.T) {
 func userNotFoundTestFunc(store *testBalanceStore, req *http.Request, rt *chi.Mux) func(t *testing.T) {
 	return func(t *testing.T) {
 		store.Clear()
+		req.Header.Set("Content-Type", "application/json")
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
 		store.balanceByUserFunc = func(ctx context.Context, uuid string) (models.Balance, error) {
 			return models.Balance{}, errors.New("user not found")
@@ -323,7 +419,7 @@ func serveHTTP(handler *chi.Mux, req *http.Request) *httptest.ResponseRecorder {
 
 type testBalanceStore struct {
 	withdrawFunc          func(ctx context.Context, withdraw models.Withdraw, uuid string) (notEnough bool, err error)
-	withdrawalsByUserFunc func(ctx context.Context, uuid string) ([]models.Withdraw, error)
+	withdrawalsByUserFunc func(ctx context.Context, uuid string, query models.WithdrawalsQuery) ([]models.Withdraw, error)
 	balanceByUserFunc     func(ctx context.Context, uuid string) (models.Balance, error)
 }
 
@@ -339,9 +435,9 @@ func (t *testBalanceStore) Withdraw(ctx context.Context, withdraw models.Withdra
 	}
 	return false, nil
 }
-func (t *testBalanceStore) WithdrawalsByUser(ctx context.Context, uuid string) ([]models.Withdraw, error) {
+func (t *testBalanceStore) WithdrawalsByUser(ctx context.Context, uuid string, query models.WithdrawalsQuery) ([]models.Withdraw, error) {
 	if t.withdrawalsByUserFunc != nil {
-		return t.withdrawalsByUserFunc(ctx, uuid)
+		return t.withdrawalsByUserFunc(ctx, uuid, query)
 	}
 	return nil, nil
 }
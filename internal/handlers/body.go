@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/joeljunstrom/go-luhn"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+// maxOrderNumberLen bounds order numbers before the Luhn check and the
+// database, card-like numbers are at most 19 digits.
+const maxOrderNumberLen = 32
+
+var (
+	errOrderNumberFormat = errors.New("order number must be 1..32 digits")
+	errOrderNumberLuhn   = errors.New("order number fails the Luhn check")
+)
+
+// readBody reads the whole body. A body over the limit set by
+// middlewares.BodyLimit is answered with 413, other errors with 400.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		return body, true
+	}
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		problem.WriteDetails(w, r, http.StatusRequestEntityTooLarge, problem.CodeTooLarge,
+			"body is too large", map[string]int64{"limit": maxErr.Limit})
+		return nil, false
+	}
+
+	log.Ctx(r.Context()).Error().Err(err).Msg("error read body")
+	problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "can't read body")
+	return nil, false
+}
+
+// decodeJSON decodes exactly one JSON value into v, unknown fields and
+// anything after the value are errors.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after json value")
+	}
+	return nil
+}
+
+// checkOrderNumber validates length and charset first, so that only short
+// digit strings reach the Luhn check and the database.
+func checkOrderNumber(number string) error {
+	if len(number) == 0 || len(number) > maxOrderNumberLen {
+		return errOrderNumberFormat
+	}
+	for _, c := range number {
+		if c < '0' || c > '9' {
+			return errOrderNumberFormat
+		}
+	}
+	if !luhn.Valid(number) {
+		return errOrderNumberLuhn
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	for body, ok := range map[string]bool{
+		`{"order":"2377225624","sum":1}`:           true,
+		` {"order":"2377225624","sum":1} ` + "\n":  true,
+		`{"order":"2377225624","sum":1,"extra":1}`: false,
+		`{"order":"2377225624","sum":1}{}`:         false,
+		`{"order":"2377225624","sum":1} trailing`:  false,
+		`{"order":"2377225624","sum":"1"}`:         false,
+		``:                                         false,
+	} {
+		var wd models.Withdraw
+		if err := decodeJSON([]byte(body), &wd); (err == nil) != ok {
+			t.Errorf("decodeJSON(%q) error %v, want ok %v", body, err, ok)
+		}
+	}
+}
+
+func TestCheckOrderNumber(t *testing.T) {
+	for number, want := range map[string]error{
+		"2377225624":                  nil,
+		"12345678904":                 errOrderNumberLuhn,
+		"":                            errOrderNumberFormat,
+		"2377 225624":                 errOrderNumberFormat,
+		"-2377225624":                 errOrderNumberFormat,
+		"٢٣٧٧٢٢٥٦٢٤":                  errOrderNumberFormat,
+		strings.Repeat("0", 33):       errOrderNumberFormat,
+		strings.Repeat("0", 31) + "0": nil,
+	} {
+		if got := checkOrderNumber(number); got != want {
+			t.Errorf("checkOrderNumber(%q) = %v, want %v", number, got, want)
+		}
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	tStore := &testBalanceStore{}
+	r := chi.NewRouter()
+	r.With(middlewares.BodyLimit(64), middlewares.Auth).Post("/api/user/balance/withdraw", (&Balances{Store: tStore}).Withdraw)
+
+	body := `{"order":"2377225624","sum":1,"pad":"` + strings.Repeat("x", 64) + `"}`
+	for name, rd := range map[string]io.Reader{
+		"ContentLength": strings.NewReader(body),
+		"Chunked":       io.MultiReader(strings.NewReader(body)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api/user/balance/withdraw", rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			wr := serveHTTP(r, req.WithContext(contextWithJwt(context.Background(), "test user")))
+			if wr.Code != http.StatusRequestEntityTooLarge {
+				t.Fatal("error, code not 413, code:", wr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+// requireContentType answers 415 unless the request body has one of types.
+func requireContentType(w http.ResponseWriter, r *http.Request, types ...string) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil {
+		for _, t := range types {
+			if mt == t {
+				return true
+			}
+		}
+	}
+
+	problem.WriteDetails(w, r, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType,
+		"Content-Type must be "+strings.Join(types, " or "), map[string]interface{}{"accepted": types})
+	return false
+}
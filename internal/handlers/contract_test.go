@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/openapi"
+	"github.com/e-faizov/gophermart/internal/storage"
+)
+
+// TestOpenAPIContract sends requests through the handlers wrapped in the
+// OpenAPI validator and fails on any response not matching the spec.
+func TestOpenAPIContract(t *testing.T) {
+	validator, err := openapi.NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator.ValidateResponses = true
+	validator.ResponseError = func(r *http.Request, err error) {
+		t.Errorf("%s %s: %v", r.Method, r.URL, err)
+	}
+
+	acc := float64(500)
+	tm := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	orders := &testOrdersStore{
+		getOrders: func(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error) {
+			return []models.Order{
+				{Number: "12345678903", Status: storage.OtProcessed, Accrual: &acc, Uploaded: tm},
+				{Number: "2377225624", Status: storage.OtNew, Uploaded: tm},
+			}, nil
+		},
+		saveOrder: func(ctx context.Context, user, order string) (models.SaveResult, error) {
+			if order == "12345678903" {
+				return models.OrderAccepted, nil
+			}
+			return models.OrderAlreadyUploaded, nil
+		},
+		saveOrders: func(ctx context.Context, user string, orders []string) (map[string]string, error) {
+			return map[string]string{"12345678903": models.BatchAccepted}, nil
+		},
+	}
+	balances := &testBalanceStore{
+		withdrawalsByUserFunc: func(ctx context.Context, uuid string, query models.WithdrawalsQuery) ([]models.Withdraw, error) {
+			return []models.Withdraw{{Order: "2377225624", Sum: 751, Processed: tm}}, nil
+		},
+		balanceByUserFunc: func(ctx context.Context, uuid string) (models.Balance, error) {
+			return models.Balance{Current: 449.5, Withdrawn: 250.5}, nil
+		},
+	}
+	statements := &testStatementStore{
+		res: models.Statement{
+			Closing: 700,
+			Entries: []models.StatementEntry{
+				{Time: tm, Kind: models.EntryAccrual, Reference: "12345678903", Amount: 700, Balance: 700},
+			},
+		},
+	}
+	hooks := &testWebhookStore{hooks: map[int64]models.Webhook{}}
+
+	users := &User{Store: &testUserStore{}, TokenAuth: jwtauth.New("HS256", []byte("secret"), nil)}
+	ordersHandler := &Orders{Store: orders}
+	balancesHandler := &Balances{Store: balances}
+	webhooksHandler := &Webhooks{Store: hooks}
+
+	r := chi.NewRouter()
+	r.Use(validator.Middleware)
+	r.Route("/api/user", func(r chi.Router) {
+		r.Post("/register", users.Register)
+		r.Post("/login", users.Login)
+		r.Post("/logout", users.Logout)
+
+		ar := r.With(middlewares.Auth)
+		ar.Post("/orders", ordersHandler.Post)
+		ar.Get("/orders", ordersHandler.Get)
+		ar.Post("/orders/batch", ordersHandler.Batch)
+		ar.Post("/balance/withdraw", balancesHandler.Withdraw)
+		ar.Get("/withdrawals", balancesHandler.Withdrawals)
+		ar.Get("/balance", balancesHandler.Balance)
+		ar.Get("/statement", (&Statements{Store: statements}).Get)
+		ar.Post("/webhooks", webhooksHandler.Create)
+		ar.Get("/webhooks", webhooksHandler.List)
+		ar.Get("/webhooks/{id}", webhooksHandler.Get)
+		ar.Put("/webhooks/{id}", webhooksHandler.Update)
+		ar.Delete("/webhooks/{id}", webhooksHandler.Delete)
+		ar.Post("/webhooks/{id}/ping", webhooksHandler.Ping)
+		ar.Get("/webhooks/{id}/deliveries", webhooksHandler.Deliveries)
+	})
+
+	for _, tt := range []struct {
+		method, path, contentType, accept, body string
+		auth                                    bool
+		code                                    int
+	}{
+		{"POST", "/api/user/register", "application/json", "", `{"login":"user","password":"pass"}`, false, http.StatusOK},
+		{"POST", "/api/user/register", "application/json", "", `{"login":"taken","password":"pass"}`, false, http.StatusConflict},
+		{"POST", "/api/user/register", "application/json", "", `{"login":"user"}`, false, http.StatusBadRequest},
+		{"POST", "/api/user/login", "application/json", "", `{"login":"user","password":"wrong"}`, false, http.StatusUnauthorized},
+		{"POST", "/api/user/login", "text/plain", "", `{"login":"user","password":"pass"}`, false, http.StatusUnsupportedMediaType},
+		{"POST", "/api/user/logout", "", "", "", false, http.StatusOK},
+		{"POST", "/api/user/orders", "text/plain", "", "12345678903", true, http.StatusAccepted},
+		{"POST", "/api/user/orders", "text/plain", "", "2377225624", true, http.StatusOK},
+		{"POST", "/api/user/orders", "text/plain", "", "12345678904", true, http.StatusUnprocessableEntity},
+		{"POST", "/api/user/orders", "text/plain", "", "12345678903", false, http.StatusUnauthorized},
+		{"GET", "/api/user/orders?limit=1", "", "", "", true, http.StatusOK},
+		{"GET", "/api/user/orders", "", "text/csv", "", true, http.StatusOK},
+		{"GET", "/api/user/orders?format=ndjson", "", "", "", true, http.StatusOK},
+		{"GET", "/api/user/orders?limit=0", "", "", "", true, http.StatusBadRequest},
+		{"GET", "/api/user/orders", "", "application/xml", "", true, http.StatusNotAcceptable},
+		{"POST", "/api/user/orders/batch", "application/json", "", `["12345678903","1"]`, true, http.StatusOK},
+		{"POST", "/api/user/balance/withdraw", "application/json", "", `{"order":"2377225624","sum":1}`, true, http.StatusOK},
+		{"POST", "/api/user/balance/withdraw", "application/json", "", `{"order":"2377225624","sum":1,"x":1}`, true, http.StatusBadRequest},
+		{"GET", "/api/user/withdrawals", "", "", "", true, http.StatusOK},
+		{"GET", "/api/user/withdrawals?format=csv", "", "", "", true, http.StatusOK},
+		{"GET", "/api/user/balance", "", "", "", true, http.StatusOK},
+		{"GET", "/api/user/statement?from=2022-10-01T00:00:00Z", "", "", "", true, http.StatusOK},
+		{"GET", "/api/user/statement?format=text", "", "", "", true, http.StatusOK},
+		{"GET", "/api/user/webhooks", "", "", "", true, http.StatusNoContent},
+		{"POST", "/api/user/webhooks", "application/json", "", `{"url":"https://example.com/hook","events":["accrual.credited"]}`, true, http.StatusCreated},
+		{"POST", "/api/user/webhooks", "application/json", "", `{"url":"https://example.com/hook","events":["user.deleted"]}`, true, http.StatusBadRequest},
+		{"GET", "/api/user/webhooks", "", "", "", true, http.StatusOK},
+		{"GET", "/api/user/webhooks/1", "", "", "", true, http.StatusOK},
+		{"PUT", "/api/user/webhooks/1", "application/json", "", `{"url":"https://example.com/other","events":["withdrawal.created"]}`, true, http.StatusOK},
+		{"POST", "/api/user/webhooks/1/ping", "", "", "", true, http.StatusAccepted},
+		{"GET", "/api/user/webhooks/1/deliveries", "", "", "", true, http.StatusNoContent},
+		{"GET", "/api/user/webhooks/abc", "", "", "", true, http.StatusNotFound},
+		{"DELETE", "/api/user/webhooks/1", "", "", "", true, http.StatusNoContent},
+		{"DELETE", "/api/user/webhooks/1", "", "", "", true, http.StatusNotFound},
+	} {
+		req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if tt.auth {
+			req = req.WithContext(contextWithJwt(context.Background(), "test user"))
+		}
+
+		wr := serveHTTP(r, req)
+		if wr.Code != tt.code {
+			t.Errorf("%s %s: code %d, want %d: %s", tt.method, tt.path, wr.Code, tt.code, wr.Body.String())
+		}
+	}
+}
+
+type testUserStore struct{}
+
+func (t *testUserStore) Register(ctx context.Context, login, password string) (bool, string, error) {
+	return login != "taken", "test user", nil
+}
+
+func (t *testUserStore) Login(ctx context.Context, login, password string) (string, bool, error) {
+	return "test user", password == "pass", nil
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/events"
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+const eventsBatch = 100
+
+// heldRetry is the first pause before events held back by a running
+// transaction are read again, it doubles up to the keep-alive.
+const heldRetry = 100 * time.Millisecond
+
+var streamEvents = []string{
+	models.EventOrderStatusChanged,
+	models.EventAccrualCredited,
+	models.EventWithdrawalCreated,
+	models.EventBalanceChanged,
+}
+
+type Events struct {
+	Store     interfaces.EventStorage
+	Hub       *events.Hub
+	KeepAlive time.Duration
+}
+
+// Stream sends the user's events as Server-Sent Events. The event id is
+// its cursor, "<xact>-<id>", so a client reconnecting with Last-Event-ID
+// gets what it missed even when events commit out of id order.
+func (e *Events) Stream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		problem.Internal(w, r)
+		return
+	}
+
+	var cursor models.EventCursor
+	var err error
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		cursor, err = parseEventCursor(last)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, "Last-Event-ID must be an event id")
+			return
+		}
+	} else {
+		cursor, err = e.Store.EventCursor(ctx)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Events.Stream error get event cursor")
+			problem.Internal(w, r)
+			return
+		}
+	}
+
+	sub := e.Hub.Subscribe(userID)
+	defer e.Hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := e.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = 15 * time.Second
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	retry := heldRetry
+	for {
+		evs, held, err := e.Store.EventsAfter(ctx, userID, cursor, streamEvents, eventsBatch)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Events.Stream error get events")
+			return
+		}
+
+		for _, ev := range evs {
+			cursor = models.EventCursor{Xact: ev.Xact, ID: ev.ID}
+			_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", formatEventCursor(cursor), ev.Type, ev.Payload)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		if len(evs) == eventsBatch {
+			continue
+		}
+
+		// committed events wait for an older transaction, no notification
+		// comes when it ends
+		var again <-chan time.Time
+		if held {
+			again = time.After(retry)
+			if retry *= 2; retry > keepAlive {
+				retry = keepAlive
+			}
+		} else {
+			retry = heldRetry
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-sub.C:
+			if !ok {
+				return
+			}
+		case <-again:
+		case <-ticker.C:
+			if _, err = fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func formatEventCursor(c models.EventCursor) string {
+	return strconv.FormatInt(c.Xact, 10) + "-" + strconv.FormatInt(c.ID, 10)
+}
+
+func parseEventCursor(s string) (models.EventCursor, error) {
+	xact, id, ok := strings.Cut(s, "-")
+	if !ok {
+		return models.EventCursor{}, errors.New("no separator")
+	}
+	var res models.EventCursor
+	var err error
+	if res.Xact, err = strconv.ParseInt(xact, 10, 64); err != nil || res.Xact < 0 {
+		return models.EventCursor{}, errors.New("wrong transaction")
+	}
+	if res.ID, err = strconv.ParseInt(id, 10, 64); err != nil || res.ID < 0 {
+		return models.EventCursor{}, errors.New("wrong id")
+	}
+	return res, nil
+}
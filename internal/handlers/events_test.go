@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/e-faizov/gophermart/internal/events"
+	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+func TestEventsStreamHandler(t *testing.T) {
+	tStore := &testEventStore{}
+	tStore.add("test user", models.EventOrderStatusChanged, `{"number":"1","status":"PROCESSING"}`)
+	tStore.add("other user", models.EventOrderStatusChanged, `{"number":"2","status":"PROCESSING"}`)
+	tStore.add("test user", models.EventBalanceChanged, `{"current":1,"withdrawn":0}`)
+
+	hub := &events.Hub{Store: tStore}
+	hub.Start()
+	defer hub.Stop()
+
+	h := &Events{Store: tStore, Hub: hub}
+	r := chi.NewRouter()
+	r.With(middlewares.Auth).Get("/api/user/events", h.Stream)
+	srv := httptest.NewServer(withJwt(r, "test user"))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/api/user/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "0-0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("error, code not 200, code:", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("wrong content type", resp.Header.Get("Content-Type"))
+	}
+
+	rd := bufio.NewReader(resp.Body)
+	expectEvent(t, rd, "1-1", models.EventOrderStatusChanged)
+	expectEvent(t, rd, "3-3", models.EventBalanceChanged)
+
+	id := tStore.add("test user", models.EventWithdrawalCreated, `{"order":"2377225624","sum":1}`)
+	hub.Notify(id, "test user")
+	expectEvent(t, rd, "4-4", models.EventWithdrawalCreated)
+}
+
+func TestEventsStreamOutOfOrderCommit(t *testing.T) {
+	tStore := &testEventStore{}
+	hub := &events.Hub{Store: tStore}
+	hub.Start()
+	defer hub.Stop()
+
+	h := &Events{Store: tStore, Hub: hub}
+	r := chi.NewRouter()
+	r.With(middlewares.Auth).Get("/api/user/events", h.Stream)
+	srv := httptest.NewServer(withJwt(r, "test user"))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/api/user/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	rd := bufio.NewReader(resp.Body)
+
+	// the first transaction takes id 1 and commits after the second one
+	first := tStore.begin("test user", models.EventOrderStatusChanged, `{"number":"1","status":"PROCESSED"}`)
+	id := tStore.add("test user", models.EventBalanceChanged, `{"current":1,"withdrawn":0}`)
+	hub.Notify(id, "test user")
+	time.Sleep(50 * time.Millisecond)
+	// no notification, the stream reads the held event again by itself
+	tStore.commit(first)
+
+	expectEvent(t, rd, "1-1", models.EventOrderStatusChanged)
+	expectEvent(t, rd, "2-2", models.EventBalanceChanged)
+}
+
+func TestParseEventCursor(t *testing.T) {
+	for s, ok := range map[string]bool{
+		"748-1204": true,
+		"0-0":      true,
+		"1204":     false,
+		"-1":       false,
+		"748-":     false,
+		"a-1":      false,
+		"1--1":     false,
+	} {
+		if _, err := parseEventCursor(s); (err == nil) != ok {
+			t.Errorf("parseEventCursor(%q) error %v, want ok %v", s, err, ok)
+		}
+	}
+}
+
+func TestEventsStreamWrongLastEventID(t *testing.T) {
+	h := &Events{Store: &testEventStore{}, Hub: &events.Hub{}}
+	r := chi.NewRouter()
+	r.With(middlewares.Auth).Get("/api/user/events", h.Stream)
+
+	req, err := http.NewRequest("GET", "/api/user/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "abc")
+	req = req.WithContext(contextWithJwt(context.Background(), "test user"))
+
+	wr := serveHTTP(r, req)
+	if wr.Code != http.StatusBadRequest {
+		t.Fatal("error, code not 400, code:", wr.Code)
+	}
+}
+
+func expectEvent(t *testing.T, rd *bufio.Reader, id, tp string) {
+	t.Helper()
+
+	fields := map[string]string{}
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal("error read stream", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && len(fields) > 0 {
+			break
+		}
+		if line == "" || strings.HasPrefix(line, ":") {
+			continue
+		}
+		kv := strings.SplitN(line, ": ", 2)
+		fields[kv[0]] = kv[1]
+	}
+
+	if fields["id"] != id || fields["event"] != tp || !json.Valid([]byte(fields["data"])) {
+		t.Fatal("wrong event", fields, "want", id, tp)
+	}
+}
+
+func withJwt(next http.Handler, user string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(contextWithJwt(r.Context(), user)))
+	})
+}
+
+// testEventStore gives every event a transaction of its own, numbered
+// like the ids. Events of running transactions are not visible yet and
+// hold back the ones of later transactions.
+type testEventStore struct {
+	mu      sync.Mutex
+	events  []models.Event
+	running map[int64]bool
+}
+
+func (t *testEventStore) add(user, tp, payload string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ev := models.Event{
+		ID:      int64(len(t.events) + 1),
+		Xact:    int64(len(t.events) + 1),
+		Type:    tp,
+		User:    user,
+		Payload: json.RawMessage(payload),
+	}
+	t.events = append(t.events, ev)
+	return ev.ID
+}
+
+func (t *testEventStore) begin(user, tp, payload string) int64 {
+	id := t.add(user, tp, payload)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running == nil {
+		t.running = map[int64]bool{}
+	}
+	t.running[id] = true
+	return id
+}
+
+func (t *testEventStore) commit(xact int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.running, xact)
+}
+
+func (t *testEventStore) xmin() int64 {
+	res := int64(len(t.events) + 1)
+	for x := range t.running {
+		if x < res {
+			res = x
+		}
+	}
+	return res
+}
+
+func (t *testEventStore) EventsAfter(ctx context.Context, uuid string, after models.EventCursor, types []string, limit int) ([]models.Event, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	xmin := t.xmin()
+	var res []models.Event
+	for _, ev := range t.events {
+		if ev.User != uuid || t.running[ev.Xact] || ev.Xact < after.Xact || (ev.Xact == after.Xact && ev.ID <= after.ID) {
+			continue
+		}
+		if ev.Xact >= xmin {
+			return res, true, nil
+		}
+		if len(res) < limit {
+			res = append(res, ev)
+		}
+	}
+	return res, false, nil
+}
+
+func (t *testEventStore) EventCursor(ctx context.Context) (models.EventCursor, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return models.EventCursor{Xact: t.xmin()}, nil
+}
+
+func (t *testEventStore) ListenEvents(ctx context.Context, notify func(id int64, uuid string)) error {
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+// exportFlushRows is how many rows are buffered before they are sent.
+const exportFlushRows = 500
+
+// Export streams orders and withdrawals of all users for the admin API.
+type Export struct {
+	Store interfaces.ExportStorage
+}
+
+// Orders streams all orders uploaded in [from, to) as CSV or NDJSON.
+func (e *Export) Orders(w http.ResponseWriter, r *http.Request) {
+	rw, from, to, ok := startExport(w, r, append([]string{"login"}, orderHeader...))
+	if !ok {
+		return
+	}
+
+	var n int
+	err := e.Store.ExportOrders(r.Context(), from, to, func(o models.OrderExport) error {
+		if err := rw.write(o, append([]string{o.Login}, orderRecord(o.Order)...)); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlushRows == 0 {
+			return rw.flush()
+		}
+		return nil
+	})
+	finishExport(w, r, rw, n, err, "Export.Orders")
+}
+
+// Withdrawals streams all withdrawals made in [from, to) as CSV or NDJSON.
+func (e *Export) Withdrawals(w http.ResponseWriter, r *http.Request) {
+	rw, from, to, ok := startExport(w, r, append([]string{"login"}, withdrawHeader...))
+	if !ok {
+		return
+	}
+
+	var n int
+	err := e.Store.ExportWithdrawals(r.Context(), from, to, func(wd models.WithdrawExport) error {
+		if err := rw.write(wd, append([]string{wd.Login}, withdrawRecord(wd.Withdraw)...)); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlushRows == 0 {
+			return rw.flush()
+		}
+		return nil
+	})
+	finishExport(w, r, rw, n, err, "Export.Withdrawals")
+}
+
+// startExport parses the period and the format, CSV by default, and starts
+// the response.
+func startExport(w http.ResponseWriter, r *http.Request, header []string) (*rowWriter, *time.Time, *time.Time, bool) {
+	format, ok := negotiateFormat(r, formatCSV, formatNDJSON)
+	if !ok {
+		problem.Write(w, r, http.StatusNotAcceptable, problem.CodeNotAcceptable, "format must be csv or ndjson")
+		return nil, nil, nil, false
+	}
+
+	q := r.URL.Query()
+	from, err := parseTime(q, "from")
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, err.Error())
+		return nil, nil, nil, false
+	}
+	to, err := parseTime(q, "to")
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, err.Error())
+		return nil, nil, nil, false
+	}
+
+	rw, err := newRowWriter(w, format, header)
+	if err != nil {
+		log.Ctx(r.Context()).Error().Err(err).Msg("Export error write header")
+		return nil, nil, nil, false
+	}
+	return rw, from, to, true
+}
+
+// finishExport flushes the rest of the rows. If the export failed after n
+// rows were streamed the status is already sent, so the error is only logged
+// and the body ends truncated.
+func finishExport(w http.ResponseWriter, r *http.Request, rw *rowWriter, n int, err error, name string) {
+	if err == nil {
+		err = rw.flush()
+	}
+	if err != nil {
+		log.Ctx(r.Context()).Error().Err(err).Msg(name + " error export")
+		if n == 0 {
+			problem.Internal(w, r)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+func TestExportHandler(t *testing.T) {
+	tm := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	tStore := &testExportStore{
+		withdrawals: []models.WithdrawExport{
+			{Login: "user1", Withdraw: models.Withdraw{Order: "2377225624", Sum: 751, Processed: tm}},
+			{Login: "user2", Withdraw: models.Withdraw{Order: "12345678903", Sum: 0.5, Processed: tm}},
+		},
+	}
+
+	h := &Export{Store: tStore}
+	r := chi.NewRouter()
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(middlewares.AdminAuth("admin token"))
+		r.Get("/export/orders", h.Orders)
+		r.Get("/export/withdrawals", h.Withdrawals)
+	})
+
+	request := func(path, token string) *http.Request {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	t.Run("Withdrawals", func(t *testing.T) {
+		wr := serveHTTP(r, request("/api/admin/export/withdrawals?from=2022-10-01T00:00:00Z", "admin token"))
+		if wr.Code != http.StatusOK {
+			t.Fatal("error, code not 200, code:", wr.Code)
+		}
+
+		rows, err := csv.NewReader(wr.Body).ReadAll()
+		if err != nil {
+			t.Fatal("response body not csv", err)
+		}
+		if len(rows) != 3 || rows[0][0] != "login" || rows[2][0] != "user2" || rows[2][2] != "0.5" {
+			t.Error("wrong csv", rows)
+		}
+		if tStore.from == nil || tStore.to != nil {
+			t.Error("wrong period", tStore.from, tStore.to)
+		}
+	})
+
+	t.Run("DBError", func(t *testing.T) {
+		wr := serveHTTP(r, request("/api/admin/export/orders?format=ndjson", "admin token"))
+		if wr.Code != http.StatusInternalServerError {
+			t.Fatal("error, code not 500, code:", wr.Code)
+		}
+	})
+
+	t.Run("WrongToken", func(t *testing.T) {
+		for _, token := range []string{"", "user token"} {
+			wr := serveHTTP(r, request("/api/admin/export/orders", token))
+			if wr.Code != http.StatusUnauthorized {
+				t.Error("error, code not 401, code:", wr.Code)
+			}
+		}
+	})
+}
+
+type testExportStore struct {
+	withdrawals []models.WithdrawExport
+	from, to    *time.Time
+}
+
+func (t *testExportStore) ExportOrders(ctx context.Context, from, to *time.Time, fn func(models.OrderExport) error) error {
+	return errors.New("db error")
+}
+
+func (t *testExportStore) ExportWithdrawals(ctx context.Context, from, to *time.Time, fn func(models.WithdrawExport) error) error {
+	t.from, t.to = from, to
+	for _, wd := range t.withdrawals {
+		if err := fn(wd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
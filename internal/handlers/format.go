@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatText   = "text"
+	formatNDJSON = "ndjson"
+)
+
+var formatTypes = map[string]string{
+	formatJSON:   "application/json",
+	formatCSV:    "text/csv",
+	formatText:   "text/plain",
+	formatNDJSON: "application/x-ndjson",
+}
+
+var (
+	orderHeader    = []string{"number", "status", "accrual", "uploaded_at"}
+	withdrawHeader = []string{"order", "sum", "processed_at"}
+)
+
+// negotiateFormat picks the response format from the format query parameter
+// or, without it, from the Accept header. The first of offers is the
+// default. ok is false if the client asked for a format not in offers.
+func negotiateFormat(r *http.Request, offers ...string) (string, bool) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		for _, o := range offers {
+			if o == f {
+				return f, true
+			}
+		}
+		return "", false
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0], true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if mt == "*/*" {
+			return offers[0], true
+		}
+		for _, o := range offers {
+			if formatTypes[o] == mt {
+				return o, true
+			}
+		}
+	}
+	return "", false
+}
+
+// rowWriter writes a listing row by row as CSV with a header line or as
+// newline delimited JSON.
+type rowWriter struct {
+	w   http.ResponseWriter
+	csv *csv.Writer
+	enc *json.Encoder
+}
+
+// newRowWriter sets the content type of format and, for CSV, writes header.
+func newRowWriter(w http.ResponseWriter, format string, header []string) (*rowWriter, error) {
+	rw := &rowWriter{w: w}
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		rw.csv = csv.NewWriter(w)
+		return rw, rw.csv.Write(header)
+	}
+	w.Header().Set("Content-Type", formatTypes[formatNDJSON])
+	rw.enc = json.NewEncoder(w)
+	return rw, nil
+}
+
+// write writes v as JSON or its record as CSV.
+func (rw *rowWriter) write(v interface{}, record []string) error {
+	if rw.csv != nil {
+		return rw.csv.Write(record)
+	}
+	return rw.enc.Encode(v)
+}
+
+// flush sends the buffered rows to the client.
+func (rw *rowWriter) flush() error {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func orderRecord(o models.Order) []string {
+	var accrual string
+	if o.Accrual != nil {
+		accrual = strconv.FormatFloat(*o.Accrual, 'f', -1, 64)
+	}
+	return []string{o.Number, o.Status, accrual, o.Uploaded.Format(time.RFC3339)}
+}
+
+func withdrawRecord(wd models.Withdraw) []string {
+	return []string{wd.Order, strconv.FormatFloat(wd.Sum, 'f', -1, 64), wd.Processed.Format(time.RFC3339)}
+}
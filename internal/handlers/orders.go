@@ -1,19 +1,27 @@
 package handlers
 
 import (
-	"io"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/render"
-	"github.com/joeljunstrom/go-luhn"
 	"github.com/rs/zerolog/log"
 
 	"github.com/e-faizov/gophermart/internal/interfaces"
 	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
+	"github.com/e-faizov/gophermart/internal/storage"
 )
 
+const defaultBatchLimit = 1000
+
 type Orders struct {
 	Store interfaces.OrdersStorage
+	// BatchLimit is the most numbers in one batch upload, 1000 by default.
+	BatchLimit int
 }
 
 func (o *Orders) Post(w http.ResponseWriter, r *http.Request) {
@@ -21,49 +29,180 @@ func (o *Orders) Post(w http.ResponseWriter, r *http.Request) {
 
 	userID := ctx.Value(models.UUIDKey).(string)
 
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Error().Err(err).Msg("Orders.Post error read body")
-		http.Error(w, "wrong body", http.StatusInternalServerError)
+	if !requireContentType(w, r, "text/plain") {
+		return
+	}
+
+	b, ok := readBody(w, r)
+	if !ok {
 		return
 	}
 
 	id := string(b)
 
-	if !luhn.Valid(id) {
-		log.Error().Err(err).Msg("Orders.Post error not luhn")
-		http.Error(w, "", http.StatusUnprocessableEntity)
+	if err := checkOrderNumber(id); err != nil {
+		problem.Write(w, r, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, err.Error())
 		return
 	}
 
-	inserted, thisUser, err := o.Store.SaveOrder(ctx, userID, id)
+	res, err := o.Store.SaveOrder(ctx, userID, id)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "user not found")
+		return
+	}
 	if err != nil {
-		log.Error().Err(err).Msg("Orders.Post error save order number")
-		http.Error(w, "", http.StatusInternalServerError)
+		log.Ctx(ctx).Error().Err(err).Msg("Orders.Post error save order number")
+		problem.Internal(w, r)
+		return
+	}
+
+	switch res {
+	case models.OrderAccepted:
+		w.WriteHeader(http.StatusAccepted)
+	case models.OrderAlreadyUploaded:
+		w.WriteHeader(http.StatusOK)
+	case models.OrderConflict:
+		problem.Write(w, r, http.StatusConflict, problem.CodeOrderConflict, "order uploaded by another user")
+	default:
+		log.Ctx(ctx).Error().Int("result", int(res)).Msg("Orders.Post unknown save result")
+		problem.Internal(w, r)
+	}
+}
+
+// Batch uploads many order numbers at once, as a JSON array of strings or a
+// newline separated list. Every number gets its own result in the order of
+// the request.
+func (o *Orders) Batch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	if !requireContentType(w, r, "application/json", "text/plain") {
 		return
 	}
 
-	if !inserted && !thisUser {
-		log.Error().Err(err).Msg("Orders.Post error already inserted")
-		http.Error(w, "", http.StatusConflict)
+	b, ok := readBody(w, r)
+	if !ok {
 		return
 	}
 
-	if inserted {
-		http.Error(w, "", http.StatusAccepted)
+	var numbers []string
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+		if err := decodeJSON(b, &numbers); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "body must be a json array of strings")
+			return
+		}
+	} else {
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				numbers = append(numbers, line)
+			}
+		}
+	}
+
+	limit := o.BatchLimit
+	if limit == 0 {
+		limit = defaultBatchLimit
+	}
+	if len(numbers) == 0 {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "no order numbers")
+		return
+	}
+	if len(numbers) > limit {
+		problem.WriteDetails(w, r, http.StatusRequestEntityTooLarge, problem.CodeTooLarge,
+			fmt.Sprintf("at most %d order numbers", limit), map[string]int{"limit": limit})
+		return
+	}
+
+	var valid []string
+	for _, n := range numbers {
+		if checkOrderNumber(n) == nil {
+			valid = append(valid, n)
+		}
+	}
+
+	saved := map[string]string{}
+	if len(valid) > 0 {
+		var err error
+		saved, err = o.Store.SaveOrders(ctx, userID, valid)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "user not found")
+			return
+		}
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Orders.Batch error save order numbers")
+			problem.Internal(w, r)
+			return
+		}
+	}
+
+	res := make([]models.BatchResult, len(numbers))
+	for i, n := range numbers {
+		res[i] = models.BatchResult{Number: n, Result: models.BatchInvalid}
+		if checkOrderNumber(n) == nil {
+			res[i].Result = saved[n]
+		}
 	}
+
+	render.JSON(w, r, res)
 }
 
+// Get returns a page of the user's orders as JSON, CSV or NDJSON, see
+// ordersQuery for the parameters. The next page is linked from the Link header.
 func (o *Orders) Get(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	userID := ctx.Value(models.UUIDKey).(string)
 
-	orders, err := o.Store.GetOrders(ctx, userID)
+	format, ok := negotiateFormat(r, formatJSON, formatCSV, formatNDJSON)
+	if !ok {
+		problem.Write(w, r, http.StatusNotAcceptable, problem.CodeNotAcceptable, "format must be json, csv or ndjson")
+		return
+	}
+
+	query, err := ordersQuery(r)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, err.Error())
+		return
+	}
+
+	limit := query.Limit
+	if limit > 0 {
+		query.Limit++
+	}
+
+	orders, err := o.Store.GetOrders(ctx, userID, query)
 	if err != nil {
-		log.Error().Err(err).Msg("Orders.Get error get orders")
-		http.Error(w, "", http.StatusInternalServerError)
+		log.Ctx(ctx).Error().Err(err).Msg("Orders.Get error get orders")
+		problem.Internal(w, r)
 		return
 	}
-	render.JSON(w, r, orders)
+
+	var next string
+	if limit > 0 && len(orders) > limit {
+		orders = orders[:limit]
+		next = ordersCursor(query, orders[limit-1])
+	}
+	setPageLinks(w, r, next)
+
+	if len(orders) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if format == formatJSON {
+		render.JSON(w, r, orders)
+		return
+	}
+
+	rw, err := newRowWriter(w, format, orderHeader)
+	for i := 0; err == nil && i < len(orders); i++ {
+		err = rw.write(orders[i], orderRecord(orders[i]))
+	}
+	if err == nil {
+		err = rw.flush()
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Orders.Get error write orders")
+	}
 }
@@ -7,6 +7,8 @@ import (
 	"github.com/e-faizov/gophermart/internal/interfaces"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,6 +16,7 @@ import (
 
 	"github.com/e-faizov/gophermart/internal/middlewares"
 	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
 	"github.com/e-faizov/gophermart/internal/storage"
 )
 
@@ -67,7 +70,7 @@ func TestOrdersGetHandler(t *testing.T) {
 		}
 		tStore.Clear()
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
-		tStore.getOrders = func(ctx context.Context, user string) ([]models.Order, error) {
+		tStore.getOrders = func(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error) {
 			return data, nil
 		}
 		wr := serveHTTP(testRouter, req)
@@ -119,7 +122,7 @@ func TestOrdersGetHandler(t *testing.T) {
 	t.Run("DBError", func(t *testing.T) {
 		tStore.Clear()
 		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
-		tStore.getOrders = func(ctx context.Context, user string) ([]models.Order, error) {
+		tStore.getOrders = func(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error) {
 			return nil, errors.New("db error")
 		}
 		wr := serveHTTP(testRouter, req)
@@ -130,35 +133,340 @@ func TestOrdersGetHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("Empty", func(t *testing.T) {
+		tStore.Clear()
+		req = req.WithContext(contextWithJwt(context.Background(), "test user"))
+		wr := serveHTTP(testRouter, req)
+
+		if wr.Code != http.StatusNoContent {
+			t.Error("error, code not 204, code:", wr.Code)
+		}
+	})
+
 	t.Run("WithoutJwt", withoutJwtTestFunc(req, testRouter))
 }
 
+func TestOrdersGetPagination(t *testing.T) {
+	tStore := &testOrdersStore{}
+	testRouter := newOrderRouter(&Orders{Store: tStore})
+
+	tm := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	var got models.OrdersQuery
+	tStore.getOrders = func(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error) {
+		got = query
+		return []models.Order{
+			{Number: "1", Status: storage.OtNew, Uploaded: tm.Add(2 * time.Second)},
+			{Number: "2", Status: storage.OtNew, Uploaded: tm.Add(time.Second)},
+			{Number: "3", Status: storage.OtNew, Uploaded: tm},
+		}, nil
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serveHTTP(testRouter, req.WithContext(contextWithJwt(context.Background(), "test user")))
+	}
+
+	wr := get("/api/user/orders?limit=2&sort=-uploaded_at&status=NEW,PROCESSING&uploaded_from=2022-10-01T00:00:00Z")
+	if wr.Code != http.StatusOK {
+		t.Fatal("error, code not 200, code:", wr.Code)
+	}
+	if got.Limit != 3 || got.Sort != models.SortUploaded || !got.Desc || len(got.Statuses) != 2 ||
+		got.From == nil || got.To != nil || got.After != nil {
+		t.Fatal("wrong query", got)
+	}
+
+	var res []models.Order
+	if err := json.Unmarshal(wr.Body.Bytes(), &res); err != nil || len(res) != 2 {
+		t.Fatal("wrong page", wr.Body.String())
+	}
+
+	links := parseLinks(wr.Header().Get("Link"))
+	if links["first"] == "" || links["next"] == "" {
+		t.Fatal("wrong Link header", wr.Header().Get("Link"))
+	}
+
+	wr = get(links["next"])
+	if wr.Code != http.StatusOK {
+		t.Fatal("error, code not 200, code:", wr.Code)
+	}
+	if got.After == nil || got.After.Number != "2" || !got.After.Uploaded.Equal(tm.Add(time.Second)) {
+		t.Fatal("wrong cursor", got.After)
+	}
+
+	wr = get("/api/user/orders?uploaded_to=2022-10-01T15:00:00%2B03:00")
+	if wr.Code != http.StatusOK {
+		t.Fatal("error, code not 200, code:", wr.Code)
+	}
+	if got.Limit != 0 || got.To == nil || *got.To != tm {
+		t.Fatal("wrong query", got)
+	}
+	if err := json.Unmarshal(wr.Body.Bytes(), &res); err != nil || len(res) != 3 {
+		t.Fatal("wrong list", wr.Body.String())
+	}
+	if all := parseLinks(wr.Header().Get("Link")); all["next"] != "" {
+		t.Fatal("wrong Link header", wr.Header().Get("Link"))
+	}
+
+	for _, target := range []string{
+		"/api/user/orders?limit=0",
+		"/api/user/orders?limit=1001",
+		"/api/user/orders?sort=number",
+		"/api/user/orders?status=DONE",
+		"/api/user/orders?uploaded_to=yesterday",
+		"/api/user/orders?cursor=abc",
+		"/api/user/orders?sort=accrual&" + strings.TrimPrefix(links["next"], "/api/user/orders?"),
+	} {
+		if wr = get(target); wr.Code != http.StatusBadRequest {
+			t.Error(target, "error, code not 400, code:", wr.Code)
+		}
+	}
+}
+
+func TestOrdersGetFormats(t *testing.T) {
+	acc := float64(500)
+	tm := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	tStore := &testOrdersStore{
+		getOrders: func(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error) {
+			return []models.Order{
+				{Number: "1", Status: storage.OtProcessed, Accrual: &acc, Uploaded: tm},
+				{Number: "2", Status: storage.OtNew, Uploaded: tm},
+			}, nil
+		},
+	}
+	testRouter := newOrderRouter(&Orders{Store: tStore})
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/api/user/orders", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", accept)
+		return serveHTTP(testRouter, req.WithContext(contextWithJwt(context.Background(), "test user")))
+	}
+
+	wr := get("text/csv")
+	if wr.Code != http.StatusOK || !strings.HasPrefix(wr.Header().Get("Content-Type"), "text/csv") {
+		t.Fatal("wrong csv response", wr.Code, wr.Header().Get("Content-Type"))
+	}
+	want := "number,status,accrual,uploaded_at\n" +
+		"1,PROCESSED,500,2022-10-01T12:00:00Z\n" +
+		"2,NEW,,2022-10-01T12:00:00Z\n"
+	if wr.Body.String() != want {
+		t.Error("wrong csv", wr.Body.String())
+	}
+
+	wr = get("application/x-ndjson")
+	if wr.Code != http.StatusOK || wr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatal("wrong ndjson response", wr.Code, wr.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(wr.Body.String()), "\n")
+	var order models.Order
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[0]), &order) != nil || order.Number != "1" {
+		t.Error("wrong ndjson", wr.Body.String())
+	}
+
+	if wr = get("application/xml"); wr.Code != http.StatusNotAcceptable {
+		t.Error("error, code not 406, code:", wr.Code)
+	}
+}
+
+func TestOrdersPostHandler(t *testing.T) {
+	tStore := &testOrdersStore{}
+	testRouter := newOrderRouter(&Orders{Store: tStore})
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/api/user/orders", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		return serveHTTP(testRouter, req.WithContext(contextWithJwt(context.Background(), "test user")))
+	}
+
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        string
+		res         models.SaveResult
+		err         error
+		code        int
+		problem     string
+	}{
+		{"Accepted", "text/plain", "12345678903", models.OrderAccepted, nil, http.StatusAccepted, ""},
+		{"AlreadyUploaded", "text/plain; charset=utf-8", "12345678903", models.OrderAlreadyUploaded, nil, http.StatusOK, ""},
+		{"Conflict", "text/plain", "12345678903", models.OrderConflict, nil, http.StatusConflict, problem.CodeOrderConflict},
+		{"UnknownUser", "text/plain", "12345678903", 0, storage.ErrUserNotFound, http.StatusUnauthorized, problem.CodeUnauthorized},
+		{"NotLuhn", "text/plain", "12345678904", models.OrderAccepted, nil, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber},
+		{"WrongContentType", "application/json", `"12345678903"`, models.OrderAccepted, nil, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tStore.saveOrder = func(ctx context.Context, user, order string) (models.SaveResult, error) {
+				return tt.res, tt.err
+			}
+
+			wr := post(tt.contentType, tt.body)
+			if wr.Code != tt.code {
+				t.Fatal("error, wrong code:", wr.Code, "want", tt.code)
+			}
+			if tt.problem == "" {
+				return
+			}
+
+			var p problem.Problem
+			if err := json.Unmarshal(wr.Body.Bytes(), &p); err != nil {
+				t.Fatal("response body not json", err)
+			}
+			if wr.Header().Get("Content-Type") != problem.ContentType || p.Status != tt.code || p.Code != tt.problem ||
+				p.Instance != "/api/user/orders" {
+				t.Error("wrong problem", p)
+			}
+		})
+	}
+}
+
+func TestOrdersBatchHandler(t *testing.T) {
+	tStore := &testOrdersStore{}
+	testRouter := chi.NewRouter()
+	testRouter.With(middlewares.Auth).Post("/api/user/orders/batch", (&Orders{Store: tStore, BatchLimit: 4}).Batch)
+
+	request := func(contentType, body string) *http.Request {
+		req, err := http.NewRequest("POST", "/api/user/orders/batch", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		return req.WithContext(contextWithJwt(context.Background(), "test user"))
+	}
+
+	var saved []string
+	tStore.saveOrders = func(ctx context.Context, user string, orders []string) (map[string]string, error) {
+		saved = orders
+		return map[string]string{
+			"12345678903":      models.BatchAccepted,
+			"2377225624":       models.BatchAlreadyUploaded,
+			"4561261212345467": models.BatchConflict,
+		}, nil
+	}
+
+	want := []models.BatchResult{
+		{Number: "12345678903", Result: models.BatchAccepted},
+		{Number: "12345678904", Result: models.BatchInvalid},
+		{Number: "2377225624", Result: models.BatchAlreadyUploaded},
+		{Number: "4561261212345467", Result: models.BatchConflict},
+	}
+
+	for name, req := range map[string]*http.Request{
+		"JSON":  request("application/json", `["12345678903","12345678904","2377225624","4561261212345467"]`),
+		"Lines": request("text/plain", "12345678903\n12345678904\r\n\n2377225624\n4561261212345467\n"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			wr := serveHTTP(testRouter, req)
+			if wr.Code != http.StatusOK {
+				t.Fatal("error, code not 200, code:", wr.Code)
+			}
+
+			var res []models.BatchResult
+			if err := json.Unmarshal(wr.Body.Bytes(), &res); err != nil {
+				t.Fatal("response body not json", err)
+			}
+			if len(res) != len(want) {
+				t.Fatal("wrong results", res)
+			}
+			for i := range want {
+				if res[i] != want[i] {
+					t.Error("wrong result", res[i], "want", want[i])
+				}
+			}
+			if len(saved) != 3 {
+				t.Error("invalid numbers passed to storage", saved)
+			}
+		})
+	}
+
+	t.Run("TooMany", func(t *testing.T) {
+		wr := serveHTTP(testRouter, request("text/plain", "1\n2\n3\n4\n5"))
+		if wr.Code != http.StatusRequestEntityTooLarge {
+			t.Fatal("error, code not 413, code:", wr.Code)
+		}
+	})
+
+	t.Run("WrongBody", func(t *testing.T) {
+		for _, req := range []*http.Request{
+			request("application/json", `{"number":"12345678903"}`),
+			request("text/plain", "\n\n"),
+		} {
+			if wr := serveHTTP(testRouter, req); wr.Code != http.StatusBadRequest {
+				t.Error("error, code not 400, code:", wr.Code)
+			}
+		}
+	})
+
+	t.Run("DBError", func(t *testing.T) {
+		tStore.saveOrders = func(ctx context.Context, user string, orders []string) (map[string]string, error) {
+			return nil, errors.New("db error")
+		}
+		wr := serveHTTP(testRouter, request("text/plain", "12345678903"))
+		if wr.Code != http.StatusInternalServerError {
+			t.Fatal("error, code not 500, code:", wr.Code)
+		}
+	})
+}
+
+func parseLinks(header string) map[string]string {
+	res := map[string]string{}
+	for _, l := range strings.Split(header, ", ") {
+		parts := strings.SplitN(l, "; ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		rel := strings.TrimSuffix(strings.TrimPrefix(parts[1], `rel="`), `"`)
+		res[rel] = strings.TrimSuffix(strings.TrimPrefix(parts[0], "<"), ">")
+	}
+	return res
+}
+
 type testOrdersStore struct {
-	saveOrder    func(ctx context.Context, user, order string) (inserted bool, thisUser bool, err error)
-	getOrders    func(ctx context.Context, user string) ([]models.Order, error)
+	saveOrder    func(ctx context.Context, user, order string) (models.SaveResult, error)
+	saveOrders   func(ctx context.Context, user string, orders []string) (map[string]string, error)
+	getOrders    func(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error)
 	newUpdaterTx func(ctx context.Context) (interfaces.OrderUpdateTx, error)
 }
 
 func (t *testOrdersStore) Clear() {
 	t.saveOrder = nil
+	t.saveOrders = nil
 	t.getOrders = nil
 	t.newUpdaterTx = nil
 }
 
-func (t *testOrdersStore) SaveOrder(ctx context.Context, user, order string) (inserted bool, thisUser bool, err error) {
+func (t *testOrdersStore) SaveOrder(ctx context.Context, user, order string) (models.SaveResult, error) {
 	if t.saveOrder != nil {
 		return t.saveOrder(ctx, user, order)
 	}
-	return false, false, nil
+	return models.OrderAccepted, nil
 }
 
-func (t *testOrdersStore) GetOrders(ctx context.Context, user string) ([]models.Order, error) {
+func (t *testOrdersStore) SaveOrders(ctx context.Context, user string, orders []string) (map[string]string, error) {
+	if t.saveOrders != nil {
+		return t.saveOrders(ctx, user, orders)
+	}
+	return nil, nil
+}
+
+func (t *testOrdersStore) GetOrders(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error) {
 	if t.getOrders != nil {
-		return t.getOrders(ctx, user)
+		return t.getOrders(ctx, user, query)
 	}
 	return nil, nil
 }
 
+func (t *testOrdersStore) ClaimOrder(ctx context.Context, status string, checkedBefore time.Time) (string, bool, error) {
+	return "", true, nil
+}
+
 func (t *testOrdersStore) NewUpdaterTx(ctx context.Context) (interfaces.OrderUpdateTx, error) {
 	if t.newUpdaterTx != nil {
 		return t.newUpdaterTx(ctx)
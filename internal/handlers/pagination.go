@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/storage"
+)
+
+const maxPageLimit = 1000
+
+var orderStatuses = map[string]bool{
+	storage.OtNew:        true,
+	storage.OtProcessing: true,
+	storage.OtInvalid:    true,
+	storage.OtProcessed:  true,
+}
+
+// parseLimit returns the page size, 0 for the whole list without a limit.
+func parseLimit(q url.Values) (int, error) {
+	s := q.Get("limit")
+	if s == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, fmt.Errorf("limit must be 1..%d", maxPageLimit)
+	}
+	return limit, nil
+}
+
+// parseTime parses an RFC 3339 time in UTC: the columns it is compared with
+// have no time zone.
+func parseTime(q url.Values, name string) (*time.Time, error) {
+	s := q.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errors.New(name + " must be RFC3339")
+	}
+	tm = tm.UTC()
+	return &tm, nil
+}
+
+func encodeCursor(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return errors.New("wrong cursor")
+	}
+	return nil
+}
+
+// setPageLinks sets the Link header with the first page and, if there is
+// one, the next page of the current listing.
+func setPageLinks(w http.ResponseWriter, r *http.Request, next string) {
+	link := func(cursor, rel string) string {
+		q := r.URL.Query()
+		q.Del("cursor")
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+		return "<" + u.String() + `>; rel="` + rel + `"`
+	}
+
+	links := []string{link("", "first")}
+	if next != "" {
+		links = append(links, link(next, "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+func ordersQuery(r *http.Request) (models.OrdersQuery, error) {
+	q := r.URL.Query()
+
+	var res models.OrdersQuery
+	var err error
+	res.Limit, err = parseLimit(q)
+	if err != nil {
+		return res, err
+	}
+
+	res.Sort = models.SortUploaded
+	if sort := q.Get("sort"); sort != "" {
+		res.Desc = strings.HasPrefix(sort, "-")
+		res.Sort = strings.TrimPrefix(sort, "-")
+		if res.Sort != models.SortUploaded && res.Sort != models.SortAccrual {
+			return res, errors.New("sort must be uploaded_at or accrual, - for descending")
+		}
+	}
+
+	for _, st := range q["status"] {
+		for _, s := range strings.Split(st, ",") {
+			if !orderStatuses[s] {
+				return res, errors.New("unknown status " + s)
+			}
+			res.Statuses = append(res.Statuses, s)
+		}
+	}
+
+	res.From, err = parseTime(q, "uploaded_from")
+	if err != nil {
+		return res, err
+	}
+	res.To, err = parseTime(q, "uploaded_to")
+	if err != nil {
+		return res, err
+	}
+
+	if c := q.Get("cursor"); c != "" {
+		var after models.OrdersCursor
+		if err = decodeCursor(c, &after); err != nil {
+			return res, err
+		}
+		if after.Sort != res.Sort || after.Desc != res.Desc {
+			return res, errors.New("cursor of another sort order")
+		}
+		res.After = &after
+	}
+	return res, nil
+}
+
+func ordersCursor(query models.OrdersQuery, last models.Order) string {
+	c := models.OrdersCursor{
+		Sort:   query.Sort,
+		Desc:   query.Desc,
+		Number: last.Number,
+	}
+	if query.Sort == models.SortAccrual {
+		if last.Accrual != nil {
+			c.Accrual = *last.Accrual
+		}
+	} else {
+		c.Uploaded = last.Uploaded
+	}
+	return encodeCursor(c)
+}
+
+func withdrawalsQuery(r *http.Request) (models.WithdrawalsQuery, error) {
+	q := r.URL.Query()
+
+	var res models.WithdrawalsQuery
+	var err error
+	res.Limit, err = parseLimit(q)
+	if err != nil {
+		return res, err
+	}
+
+	res.From, err = parseTime(q, "from")
+	if err != nil {
+		return res, err
+	}
+	res.To, err = parseTime(q, "to")
+	if err != nil {
+		return res, err
+	}
+
+	if c := q.Get("cursor"); c != "" {
+		var after models.WithdrawalsCursor
+		if err = decodeCursor(c, &after); err != nil {
+			return res, err
+		}
+		res.After = &after
+	}
+	return res, nil
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
+	"github.com/e-faizov/gophermart/internal/storage"
+)
+
+// apiKeyPrefix marks merchant API keys, so that leaked ones are easy to
+// find.
+const apiKeyPrefix = "gm_"
+
+var merchantScopes = map[string]bool{
+	models.ScopeOrdersWrite:        true,
+	models.ScopeOrdersRead:         true,
+	models.ScopeWithdrawalsReverse: true,
+}
+
+// Partner serves the merchant API, the merchant is put in the context by
+// middlewares.PartnerAuth.
+type Partner struct {
+	Store interfaces.PartnerStorage
+}
+
+// AttachOrder registers an order for the user named by login or linking
+// token. The answers are those of Orders.Post; an unknown login and a bad
+// token get the same 422, so the reason isn't told apart.
+func (p *Partner) AttachOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	merchant := ctx.Value(models.MerchantKey).(models.Merchant)
+
+	if !requireContentType(w, r, "application/json") {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var order models.PartnerOrder
+	err := decodeJSON(body, &order)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "body must be a json object with number and login or link_token: "+err.Error())
+		return
+	}
+	if (order.Login == "") == (order.LinkToken == "") {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "exactly one of login and link_token must be set")
+		return
+	}
+
+	if err = checkOrderNumber(order.Number); err != nil {
+		problem.Write(w, r, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, err.Error())
+		return
+	}
+
+	res, err := p.Store.AttachOrder(ctx, merchant.ID, order)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		problem.Write(w, r, http.StatusUnprocessableEntity, problem.CodeUnknownUser, "login or link token not accepted")
+		return
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Partner.AttachOrder error attach order")
+		problem.Internal(w, r)
+		return
+	}
+
+	switch res {
+	case models.OrderAccepted:
+		w.WriteHeader(http.StatusAccepted)
+	case models.OrderAlreadyUploaded:
+		w.WriteHeader(http.StatusOK)
+	case models.OrderConflict:
+		problem.Write(w, r, http.StatusConflict, problem.CodeOrderConflict, "order uploaded by another user")
+	default:
+		log.Ctx(ctx).Error().Int("result", int(res)).Msg("Partner.AttachOrder unknown save result")
+		problem.Internal(w, r)
+	}
+}
+
+// Order returns an order the merchant attached.
+func (p *Partner) Order(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	merchant := ctx.Value(models.MerchantKey).(models.Merchant)
+
+	order, found, err := p.Store.PartnerOrder(ctx, merchant.ID, chi.URLParam(r, "number"))
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Partner.Order error get order")
+		problem.Internal(w, r)
+		return
+	}
+	if !found {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "order not found")
+		return
+	}
+
+	render.JSON(w, r, order)
+}
+
+// ReverseWithdrawal returns the points of a withdrawal, for a purchase
+// cancelled at the merchant.
+func (p *Partner) ReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	merchant := ctx.Value(models.MerchantKey).(models.Merchant)
+
+	if !requireContentType(w, r, "application/json") {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var reversal models.Reversal
+	err := decodeJSON(body, &reversal)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "body must be a json object with order: "+err.Error())
+		return
+	}
+
+	if err = checkOrderNumber(reversal.Order); err != nil {
+		problem.Write(w, r, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, err.Error())
+		return
+	}
+
+	res, err := p.Store.ReverseWithdrawal(ctx, merchant.ID, reversal.Order)
+	switch {
+	case errors.Is(err, storage.ErrWithdrawalNotFound):
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "withdrawal not found")
+	case errors.Is(err, storage.ErrWithdrawalReversed):
+		problem.Write(w, r, http.StatusConflict, problem.CodeAlreadyReversed, "withdrawal already reversed")
+	case err != nil:
+		log.Ctx(ctx).Error().Err(err).Msg("Partner.ReverseWithdrawal error reverse withdrawal")
+		problem.Internal(w, r)
+	default:
+		render.JSON(w, r, res)
+	}
+}
+
+// Merchants serves the admin operations on merchants.
+type Merchants struct {
+	Store interfaces.MerchantStorage
+}
+
+// Create registers a merchant and answers with its API key, the only time
+// the key is shown.
+func (m *Merchants) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !requireContentType(w, r, "application/json") {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var merchant models.Merchant
+	err := decodeJSON(body, &merchant)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "body must be a json object with name and scopes: "+err.Error())
+		return
+	}
+
+	if merchant.Name == "" {
+		problem.WriteDetails(w, r, http.StatusUnprocessableEntity, problem.CodeValidation, "name must not be empty",
+			map[string]string{"field": "name"})
+		return
+	}
+	if len(merchant.Scopes) == 0 {
+		problem.WriteDetails(w, r, http.StatusUnprocessableEntity, problem.CodeValidation, "scopes must not be empty",
+			map[string]string{"field": "scopes"})
+		return
+	}
+	for _, s := range merchant.Scopes {
+		if !merchantScopes[s] {
+			problem.WriteDetails(w, r, http.StatusUnprocessableEntity, problem.CodeValidation, "unknown scope "+s,
+				map[string]string{"field": "scopes"})
+			return
+		}
+	}
+
+	key := make([]byte, 32)
+	if _, err = rand.Read(key); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Merchants.Create error generate api key")
+		problem.Internal(w, r)
+		return
+	}
+	apiKey := apiKeyPrefix + hex.EncodeToString(key)
+
+	merchant, ok, err = m.Store.CreateMerchant(ctx, models.Merchant{Name: merchant.Name, Scopes: merchant.Scopes}, apiKey)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Merchants.Create error create merchant")
+		problem.Internal(w, r)
+		return
+	}
+	if !ok {
+		problem.Write(w, r, http.StatusConflict, problem.CodeMerchantExists, "merchant name taken")
+		return
+	}
+	merchant.APIKey = apiKey
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, merchant)
+}
+
+// defaultAuditLimit is how many audit entries are listed without a limit,
+// the log has no end to list whole.
+const defaultAuditLimit = 100
+
+// Audit lists the latest partner API calls of a merchant.
+func (m *Merchants) Audit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "merchant not found")
+		return
+	}
+	limit, err := parseLimit(r.URL.Query())
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, err.Error())
+		return
+	}
+	if limit == 0 {
+		limit = defaultAuditLimit
+	}
+
+	entries, err := m.Store.PartnerAudit(ctx, id, limit)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Merchants.Audit error get audit")
+		problem.Internal(w, r)
+		return
+	}
+
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	render.JSON(w, r, entries)
+}
+
+// LinkTokens issues the tokens users hand to merchants instead of their
+// login.
+type LinkTokens struct {
+	Store interfaces.LinkTokenStorage
+	// TTL is how long a token is good for, 15 minutes by default.
+	TTL time.Duration
+}
+
+func (l *LinkTokens) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("LinkTokens.Create error generate token")
+		problem.Internal(w, r)
+		return
+	}
+
+	ttl := l.TTL
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	token := models.LinkToken{
+		Token:   hex.EncodeToString(b),
+		Expires: time.Now().Add(ttl),
+	}
+
+	err := l.Store.CreateLinkToken(ctx, userID, token)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "user not found")
+		return
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("LinkTokens.Create error save token")
+		problem.Internal(w, r)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, token)
+}
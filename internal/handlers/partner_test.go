@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
+	"github.com/e-faizov/gophermart/internal/storage"
+)
+
+func TestPartnerHandler(t *testing.T) {
+	tStore := &testPartnerStore{}
+	h := &Partner{Store: tStore}
+	testRouter := chi.NewRouter()
+	testRouter.Post("/api/partner/orders", h.AttachOrder)
+	testRouter.Get("/api/partner/orders/{number}", h.Order)
+	testRouter.Post("/api/partner/withdrawals/reversals", h.ReverseWithdrawal)
+
+	merchant := models.Merchant{ID: 7, Name: "shop"}
+	request := func(method, path, body string) *http.Request {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req.WithContext(context.WithValue(context.Background(), models.MerchantKey, merchant))
+	}
+
+	for _, tt := range []struct {
+		name    string
+		body    string
+		res     models.SaveResult
+		err     error
+		code    int
+		problem string
+	}{
+		{"ByLogin", `{"number":"12345678903","login":"user"}`, models.OrderAccepted, nil, http.StatusAccepted, ""},
+		{"ByLinkToken", `{"number":"12345678903","link_token":"abc"}`, models.OrderAccepted, nil, http.StatusAccepted, ""},
+		{"AlreadyUploaded", `{"number":"12345678903","login":"user"}`, models.OrderAlreadyUploaded, nil, http.StatusOK, ""},
+		{"Conflict", `{"number":"12345678903","login":"user"}`, models.OrderConflict, nil, http.StatusConflict, problem.CodeOrderConflict},
+		{"UnknownUser", `{"number":"12345678903","login":"user"}`, 0, storage.ErrUserNotFound, http.StatusUnprocessableEntity, problem.CodeUnknownUser},
+		{"BadLinkToken", `{"number":"12345678903","link_token":"abc"}`, 0, storage.ErrUserNotFound, http.StatusUnprocessableEntity, problem.CodeUnknownUser},
+		{"NoUser", `{"number":"12345678903"}`, models.OrderAccepted, nil, http.StatusBadRequest, problem.CodeInvalidBody},
+		{"BothUsers", `{"number":"12345678903","login":"user","link_token":"abc"}`, models.OrderAccepted, nil, http.StatusBadRequest, problem.CodeInvalidBody},
+		{"NotLuhn", `{"number":"12345678904","login":"user"}`, models.OrderAccepted, nil, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber},
+	} {
+		t.Run("Attach"+tt.name, func(t *testing.T) {
+			var gotMerchant int64
+			tStore.attachOrder = func(ctx context.Context, merchantID int64, order models.PartnerOrder) (models.SaveResult, error) {
+				gotMerchant = merchantID
+				return tt.res, tt.err
+			}
+
+			wr := serveHTTP(testRouter, request("POST", "/api/partner/orders", tt.body))
+			if wr.Code != tt.code {
+				t.Fatal("error, wrong code:", wr.Code, "want", tt.code)
+			}
+			if tt.problem == "" {
+				if gotMerchant != merchant.ID {
+					t.Error("wrong merchant", gotMerchant)
+				}
+				return
+			}
+
+			var p problem.Problem
+			if err := json.Unmarshal(wr.Body.Bytes(), &p); err != nil {
+				t.Fatal("response body not json", err)
+			}
+			if p.Code != tt.problem {
+				t.Error("wrong problem", p)
+			}
+		})
+	}
+
+	t.Run("Order", func(t *testing.T) {
+		tStore.partnerOrder = func(ctx context.Context, merchantID int64, number string) (models.Order, bool, error) {
+			if merchantID != merchant.ID || number != "12345678903" {
+				return models.Order{}, false, nil
+			}
+			return models.Order{Number: number, Status: "PROCESSED"}, true, nil
+		}
+
+		wr := serveHTTP(testRouter, request("GET", "/api/partner/orders/12345678903", ""))
+		if wr.Code != http.StatusOK {
+			t.Fatal("error, code not 200, code:", wr.Code)
+		}
+		var res models.Order
+		if err := json.Unmarshal(wr.Body.Bytes(), &res); err != nil {
+			t.Fatal("response body not json", err)
+		}
+		if res.Number != "12345678903" || res.Status != "PROCESSED" {
+			t.Error("wrong response", res)
+		}
+
+		wr = serveHTTP(testRouter, request("GET", "/api/partner/orders/2377225624", ""))
+		if wr.Code != http.StatusNotFound {
+			t.Fatal("error, code not 404, code:", wr.Code)
+		}
+	})
+
+	for _, tt := range []struct {
+		name string
+		body string
+		err  error
+		code int
+	}{
+		{"Reversed", `{"order":"2377225624"}`, nil, http.StatusOK},
+		{"NotFound", `{"order":"2377225624"}`, storage.ErrWithdrawalNotFound, http.StatusNotFound},
+		{"AlreadyReversed", `{"order":"2377225624"}`, storage.ErrWithdrawalReversed, http.StatusConflict},
+		{"NotLuhn", `{"order":"2377225625"}`, nil, http.StatusUnprocessableEntity},
+	} {
+		t.Run("Reverse"+tt.name, func(t *testing.T) {
+			tStore.reverseWithdrawal = func(ctx context.Context, merchantID int64, order string) (models.ReversalResult, error) {
+				return models.ReversalResult{Order: order, Sum: 100, Reversed: time.Now()}, tt.err
+			}
+
+			wr := serveHTTP(testRouter, request("POST", "/api/partner/withdrawals/reversals", tt.body))
+			if wr.Code != tt.code {
+				t.Fatal("error, wrong code:", wr.Code, "want", tt.code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var res models.ReversalResult
+			if err := json.Unmarshal(wr.Body.Bytes(), &res); err != nil {
+				t.Fatal("response body not json", err)
+			}
+			if res.Order != "2377225624" || res.Sum != 100 {
+				t.Error("wrong response", res)
+			}
+		})
+	}
+}
+
+func TestMerchantsCreate(t *testing.T) {
+	tStore := &testMerchantStore{}
+	testRouter := chi.NewRouter()
+	testRouter.Post("/admin/merchants", (&Merchants{Store: tStore}).Create)
+
+	create := func(body string) *http.Request {
+		req, err := http.NewRequest("POST", "/admin/merchants", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	t.Run("Created", func(t *testing.T) {
+		wr := serveHTTP(testRouter, create(`{"name":"shop","scopes":["orders:write","orders:read"]}`))
+		if wr.Code != http.StatusCreated {
+			t.Fatal("error, code not 201, code:", wr.Code)
+		}
+		var res models.Merchant
+		if err := json.Unmarshal(wr.Body.Bytes(), &res); err != nil {
+			t.Fatal("response body not json", err)
+		}
+		if res.ID == 0 || !strings.HasPrefix(res.APIKey, apiKeyPrefix) || res.APIKey != tStore.key {
+			t.Error("wrong response", res)
+		}
+	})
+
+	t.Run("NameTaken", func(t *testing.T) {
+		wr := serveHTTP(testRouter, create(`{"name":"shop","scopes":["orders:read"]}`))
+		if wr.Code != http.StatusConflict {
+			t.Fatal("error, code not 409, code:", wr.Code)
+		}
+	})
+
+	t.Run("UnknownScope", func(t *testing.T) {
+		wr := serveHTTP(testRouter, create(`{"name":"other","scopes":["users:delete"]}`))
+		if wr.Code != http.StatusUnprocessableEntity {
+			t.Fatal("error, code not 422, code:", wr.Code)
+		}
+	})
+
+	t.Run("NoScopes", func(t *testing.T) {
+		wr := serveHTTP(testRouter, create(`{"name":"other"}`))
+		if wr.Code != http.StatusUnprocessableEntity {
+			t.Fatal("error, code not 422, code:", wr.Code)
+		}
+	})
+}
+
+func TestMerchantsAudit(t *testing.T) {
+	tStore := &testMerchantStore{}
+	testRouter := chi.NewRouter()
+	testRouter.Get("/admin/merchants/{id}/audit", (&Merchants{Store: tStore}).Audit)
+
+	for _, tt := range []struct {
+		target string
+		code   int
+		limit  int
+	}{
+		{"/admin/merchants/1/audit", http.StatusOK, defaultAuditLimit},
+		{"/admin/merchants/1/audit?limit=5", http.StatusOK, 5},
+		{"/admin/merchants/1/audit?limit=0", http.StatusBadRequest, 0},
+		{"/admin/merchants/shop/audit", http.StatusNotFound, 0},
+	} {
+		tStore.auditLimit = 0
+		req, err := http.NewRequest("GET", tt.target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wr := serveHTTP(testRouter, req)
+		if wr.Code != tt.code {
+			t.Error(tt.target, "wrong code", wr.Code, "want", tt.code)
+		}
+		if tStore.auditLimit != tt.limit {
+			t.Error(tt.target, "wrong limit", tStore.auditLimit, "want", tt.limit)
+		}
+	}
+}
+
+type testPartnerStore struct {
+	attachOrder       func(ctx context.Context, merchantID int64, order models.PartnerOrder) (models.SaveResult, error)
+	partnerOrder      func(ctx context.Context, merchantID int64, number string) (models.Order, bool, error)
+	reverseWithdrawal func(ctx context.Context, merchantID int64, order string) (models.ReversalResult, error)
+}
+
+func (t *testPartnerStore) AttachOrder(ctx context.Context, merchantID int64, order models.PartnerOrder) (models.SaveResult, error) {
+	return t.attachOrder(ctx, merchantID, order)
+}
+
+func (t *testPartnerStore) PartnerOrder(ctx context.Context, merchantID int64, number string) (models.Order, bool, error) {
+	return t.partnerOrder(ctx, merchantID, number)
+}
+
+func (t *testPartnerStore) ReverseWithdrawal(ctx context.Context, merchantID int64, order string) (models.ReversalResult, error) {
+	return t.reverseWithdrawal(ctx, merchantID, order)
+}
+
+type testMerchantStore struct {
+	names      map[string]bool
+	key        string
+	auditLimit int
+}
+
+func (t *testMerchantStore) CreateMerchant(ctx context.Context, merchant models.Merchant, key string) (models.Merchant, bool, error) {
+	if t.names == nil {
+		t.names = map[string]bool{}
+	}
+	if t.names[merchant.Name] {
+		return models.Merchant{}, false, nil
+	}
+	t.names[merchant.Name] = true
+	t.key = key
+	merchant.ID = int64(len(t.names))
+	return merchant, true, nil
+}
+
+func (t *testMerchantStore) MerchantByKey(ctx context.Context, key string) (models.Merchant, bool, error) {
+	return models.Merchant{}, false, nil
+}
+
+func (t *testMerchantStore) SavePartnerAudit(ctx context.Context, entry models.PartnerAudit) error {
+	return nil
+}
+
+func (t *testMerchantStore) PartnerAudit(ctx context.Context, merchantID int64, limit int) ([]models.PartnerAudit, error) {
+	t.auditLimit = limit
+	return []models.PartnerAudit{{MerchantID: merchantID, Method: http.MethodPost, Path: "/api/partner/orders", Status: http.StatusAccepted}}, nil
+}
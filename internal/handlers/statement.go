@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+type Statements struct {
+	Store interfaces.StatementStorage
+}
+
+// Get returns the user's account statement for [from, to) with opening and
+// closing balances as JSON, CSV or plain text.
+func (s *Statements) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	format, ok := negotiateFormat(r, formatJSON, formatCSV, formatText)
+	if !ok {
+		problem.Write(w, r, http.StatusNotAcceptable, problem.CodeNotAcceptable, "format must be json, csv or text")
+		return
+	}
+
+	q := r.URL.Query()
+	from, err := parseTime(q, "from")
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, err.Error())
+		return
+	}
+	to, err := parseTime(q, "to")
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, err.Error())
+		return
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, "from must be before to")
+		return
+	}
+
+	st, err := s.Store.Statement(ctx, userID, from, to)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Statements.Get error get statement")
+		problem.Internal(w, r)
+		return
+	}
+
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writeStatementCSV(w, st)
+	case formatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeStatementText(w, st)
+	default:
+		render.JSON(w, r, st)
+	}
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// writeStatementCSV writes entries as rows, the opening and closing balances
+// are the first and the last rows.
+func writeStatementCSV(w http.ResponseWriter, st models.Statement) {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"time", "kind", "reference", "amount", "balance"})
+
+	var from, to string
+	if st.From != nil {
+		from = st.From.Format(time.RFC3339)
+	}
+	if st.To != nil {
+		to = st.To.Format(time.RFC3339)
+	}
+
+	_ = cw.Write([]string{from, "opening", "", "", formatAmount(st.Opening)})
+	for _, e := range st.Entries {
+		_ = cw.Write([]string{e.Time.Format(time.RFC3339), e.Kind, e.Reference, formatAmount(e.Amount), formatAmount(e.Balance)})
+	}
+	_ = cw.Write([]string{to, "closing", "", "", formatAmount(st.Closing)})
+	cw.Flush()
+}
+
+func writeStatementText(w http.ResponseWriter, st models.Statement) {
+	period := func(t *time.Time, open string) string {
+		if t == nil {
+			return open
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	fmt.Fprintf(w, "Statement %s - %s\n\n", period(st.From, "beginning"), period(st.To, "now"))
+	fmt.Fprintf(w, "%-20s  %-10s  %-20s  %12s  %12s\n", "Time", "Kind", "Reference", "Amount", "Balance")
+	fmt.Fprintf(w, "%-56s  %12s  %12s\n", "Opening balance", "", formatAmount(st.Opening))
+	for _, e := range st.Entries {
+		fmt.Fprintf(w, "%-20s  %-10s  %-20s  %12s  %12s\n",
+			e.Time.Format("2006-01-02 15:04:05"), e.Kind, e.Reference,
+			formatAmount(e.Amount), formatAmount(e.Balance))
+	}
+	fmt.Fprintf(w, "%-56s  %12s  %12s\n", "Closing balance", "", formatAmount(st.Closing))
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+func TestStatementHandler(t *testing.T) {
+	tm := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	tStore := &testStatementStore{
+		res: models.Statement{
+			Opening: 100,
+			Closing: 549.5,
+			Entries: []models.StatementEntry{
+				{Time: tm, Kind: models.EntryAccrual, Reference: "12345678903", Amount: 700, Balance: 800},
+				{Time: tm.Add(time.Hour), Kind: models.EntryWithdrawal, Reference: "2377225624", Amount: -250.5, Balance: 549.5},
+			},
+		},
+	}
+
+	r := chi.NewRouter()
+	r.With(middlewares.Auth).Get("/api/user/statement", (&Statements{Store: tStore}).Get)
+
+	request := func(path, accept string) *http.Request {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return req.WithContext(contextWithJwt(context.Background(), "test user"))
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		wr := serveHTTP(r, request("/api/user/statement?from=2022-10-01T00:00:00Z&to=2022-11-01T00:00:00Z", ""))
+		if wr.Code != http.StatusOK {
+			t.Fatal("error, code not 200, code:", wr.Code)
+		}
+
+		var res models.Statement
+		if err := json.Unmarshal(wr.Body.Bytes(), &res); err != nil {
+			t.Fatal("response body not json", err)
+		}
+		if res.Opening != 100 || res.Closing != 549.5 || len(res.Entries) != 2 {
+			t.Error("wrong statement", res)
+		}
+		if tStore.from == nil || tStore.to == nil || !tStore.from.Equal(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Error("wrong period", tStore.from, tStore.to)
+		}
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		wr := serveHTTP(r, request("/api/user/statement", "text/csv"))
+		if wr.Code != http.StatusOK {
+			t.Fatal("error, code not 200, code:", wr.Code)
+		}
+		if !strings.HasPrefix(wr.Header().Get("Content-Type"), "text/csv") {
+			t.Error("wrong content type", wr.Header().Get("Content-Type"))
+		}
+
+		rows, err := csv.NewReader(wr.Body).ReadAll()
+		if err != nil {
+			t.Fatal("response body not csv", err)
+		}
+		if len(rows) != 5 || rows[1][1] != "opening" || rows[3][3] != "-250.50" || rows[4][4] != "549.50" {
+			t.Error("wrong csv", rows)
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		wr := serveHTTP(r, request("/api/user/statement?format=text", ""))
+		if wr.Code != http.StatusOK {
+			t.Fatal("error, code not 200, code:", wr.Code)
+		}
+		body := wr.Body.String()
+		if !strings.Contains(body, "Opening balance") || !strings.Contains(body, "12345678903") {
+			t.Error("wrong text", body)
+		}
+	})
+
+	t.Run("NotAcceptable", func(t *testing.T) {
+		wr := serveHTTP(r, request("/api/user/statement", "application/xml"))
+		if wr.Code != http.StatusNotAcceptable {
+			t.Fatal("error, code not 406, code:", wr.Code)
+		}
+	})
+
+	t.Run("WrongPeriod", func(t *testing.T) {
+		wr := serveHTTP(r, request("/api/user/statement?from=2022-11-01T00:00:00Z&to=2022-10-01T00:00:00Z", ""))
+		if wr.Code != http.StatusBadRequest {
+			t.Fatal("error, code not 400, code:", wr.Code)
+		}
+	})
+}
+
+type testStatementStore struct {
+	res      models.Statement
+	from, to *time.Time
+}
+
+func (t *testStatementStore) Statement(ctx context.Context, uuid string, from, to *time.Time) (models.Statement, error) {
+	t.from, t.to = from, to
+	res := t.res
+	res.From, res.To = from, to
+	return res, nil
+}
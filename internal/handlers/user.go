@@ -1,8 +1,6 @@
 package handlers
 
 import (
-	"encoding/json"
-	"io"
 	"net/http"
 	"time"
 
@@ -11,83 +9,90 @@ import (
 
 	"github.com/e-faizov/gophermart/internal/interfaces"
 	"github.com/e-faizov/gophermart/internal/models"
-	"github.com/e-faizov/gophermart/internal/utils"
+	"github.com/e-faizov/gophermart/internal/problem"
 )
 
 type User struct {
 	Store     interfaces.UserStorage
 	TokenAuth *jwtauth.JWTAuth
+	// CookieTTL is the session lifetime, a day by default.
+	CookieTTL time.Duration
 }
 
 func (u *User) Register(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	user, err := unmarshalUser(r)
-	if err != nil {
-		log.Error().Err(err).Msg("User.Register error unmarshal data")
-		http.Error(w, "wrong body", http.StatusBadRequest)
+	if !requireContentType(w, r, "application/json") {
+		return
+	}
+	user, ok := unmarshalUser(w, r)
+	if !ok {
 		return
 	}
 	ok, uid, err := u.Store.Register(ctx, user.Login, user.Password)
 	if err != nil {
-		log.Error().Err(err).Msg("User.Register sql error")
-		http.Error(w, "wrong body", http.StatusInternalServerError)
+		log.Ctx(ctx).Error().Err(err).Msg("User.Register sql error")
+		problem.Internal(w, r)
 		return
 	}
 
 	if !ok {
-		http.Error(w, "already created", http.StatusConflict)
+		problem.Write(w, r, http.StatusConflict, problem.CodeLoginTaken, "login already taken")
 		return
 	}
 
 	token, err := u.token(uid)
 	if err != nil {
-		log.Error().Err(err).Msg("User.Register error create token")
+		log.Ctx(ctx).Error().Err(err).Msg("User.Register error create token")
 		u.Logout(w, r)
-		http.Error(w, "wrong body", http.StatusInternalServerError)
+		problem.Internal(w, r)
 		return
 	}
 
-	setCookie(w, token)
+	u.setCookie(w, token)
 }
 
 func (u *User) Login(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	user, err := unmarshalUser(r)
-	if err != nil {
-		log.Error().Err(err).Msg("User.Login error unmarshal data")
-		u.Logout(w, r)
-		http.Error(w, "wrong body", http.StatusBadRequest)
+	if !requireContentType(w, r, "application/json") {
+		return
+	}
+	user, ok := unmarshalUser(w, r)
+	if !ok {
 		return
 	}
 	uid, ok, err := u.Store.Login(ctx, user.Login, user.Password)
 	if err != nil {
-		log.Error().Err(err).Msg("User.Login error verify user")
+		log.Ctx(ctx).Error().Err(err).Msg("User.Login error verify user")
 		u.Logout(w, r)
-		http.Error(w, "wrong body", http.StatusInternalServerError)
+		problem.Internal(w, r)
 		return
 	}
 
 	if !ok {
 		u.Logout(w, r)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "wrong login or password")
 		return
 	}
 
 	token, err := u.token(uid)
 	if err != nil {
-		log.Error().Err(err).Msg("User.Login error create token")
+		log.Ctx(ctx).Error().Err(err).Msg("User.Login error create token")
 		u.Logout(w, r)
-		http.Error(w, "wrong body", http.StatusInternalServerError)
+		problem.Internal(w, r)
 		return
 	}
 
-	setCookie(w, token)
+	u.setCookie(w, token)
 }
 
-func setCookie(w http.ResponseWriter, token string) {
+func (u *User) setCookie(w http.ResponseWriter, token string) {
+	ttl := u.CookieTTL
+	if ttl == 0 {
+		ttl = 24 * time.Hour
+	}
 	http.SetCookie(w, &http.Cookie{
 		HttpOnly: true,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(ttl),
 		SameSite: http.SameSiteLaxMode,
 		Name:     "jwt",
 		Value:    token,
@@ -109,16 +114,24 @@ func (u *User) token(user string) (string, error) {
 	return tokenString, err
 }
 
-func unmarshalUser(r *http.Request) (models.User, error) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return models.User{}, utils.ErrorHelper(err)
+// maxCredentialLen bounds login and password, the password is hashed as is.
+const maxCredentialLen = 256
+
+func unmarshalUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return models.User{}, false
 	}
 
 	var data models.User
-	err = json.Unmarshal(body, &data)
+	err := decodeJSON(body, &data)
 	if err != nil {
-		return models.User{}, utils.ErrorHelper(err)
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "body must be a json object with login and password: "+err.Error())
+		return models.User{}, false
+	}
+	if data.Login == "" || data.Password == "" || len(data.Login) > maxCredentialLen || len(data.Password) > maxCredentialLen {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "login and password must be 1..256 bytes")
+		return models.User{}, false
 	}
-	return data, nil
+	return data, true
 }
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
+	"github.com/e-faizov/gophermart/internal/webhooks"
+)
+
+const webhookDeliveriesLimit = 100
+
+var webhookEvents = map[string]bool{
+	models.EventOrderStatusChanged: true,
+	models.EventAccrualCredited:    true,
+	models.EventWithdrawalCreated:  true,
+}
+
+type Webhooks struct {
+	Store interfaces.WebhookStorage
+}
+
+func (h *Webhooks) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	hook, ok := unmarshalWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Webhooks.Create error generate secret")
+		problem.Internal(w, r)
+		return
+	}
+	hook.Secret = hex.EncodeToString(secret)
+
+	hook, err := h.Store.CreateWebhook(ctx, userID, hook)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Webhooks.Create error create webhook")
+		problem.Internal(w, r)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, hook)
+}
+
+func (h *Webhooks) List(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	hooks, err := h.Store.Webhooks(ctx, userID)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Webhooks.List error get webhooks")
+		problem.Internal(w, r)
+		return
+	}
+
+	if len(hooks) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	render.JSON(w, r, hooks)
+}
+
+func (h *Webhooks) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	hook, found, err := h.Store.Webhook(ctx, userID, id)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Webhooks.Get error get webhook")
+		problem.Internal(w, r)
+		return
+	}
+	if !found {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "webhook not found")
+		return
+	}
+
+	render.JSON(w, r, hook)
+}
+
+func (h *Webhooks) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	hook, ok := unmarshalWebhook(w, r)
+	if !ok {
+		return
+	}
+	hook.ID = id
+
+	found, err := h.Store.UpdateWebhook(ctx, userID, hook)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Webhooks.Update error update webhook")
+		problem.Internal(w, r)
+		return
+	}
+	if !found {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "webhook not found")
+		return
+	}
+
+	h.Get(w, r)
+}
+
+func (h *Webhooks) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := h.Store.DeleteWebhook(ctx, userID, id)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Webhooks.Delete error delete webhook")
+		problem.Internal(w, r)
+		return
+	}
+	if !found {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "webhook not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Webhooks) Ping(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := h.Store.PingWebhook(ctx, userID, id)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Webhooks.Ping error ping webhook")
+		problem.Internal(w, r)
+		return
+	}
+	if !found {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "webhook not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Webhooks) Deliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := ctx.Value(models.UUIDKey).(string)
+
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, found, err := h.Store.WebhookDeliveries(ctx, userID, id, webhookDeliveriesLimit)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Webhooks.Deliveries error get deliveries")
+		problem.Internal(w, r)
+		return
+	}
+	if !found {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "webhook not found")
+		return
+	}
+
+	if len(deliveries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	render.JSON(w, r, deliveries)
+}
+
+func webhookID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "webhook not found")
+		return 0, false
+	}
+	return id, true
+}
+
+func unmarshalWebhook(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	if !requireContentType(w, r, "application/json") {
+		return models.Webhook{}, false
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return models.Webhook{}, false
+	}
+
+	var hook models.Webhook
+	err := decodeJSON(body, &hook)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "body must be a json object with url and events: "+err.Error())
+		return models.Webhook{}, false
+	}
+
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problem.WriteDetails(w, r, http.StatusUnprocessableEntity, problem.CodeValidation, "url must be an absolute http or https url",
+			map[string]string{"field": "url"})
+		return models.Webhook{}, false
+	}
+	if err = webhooks.CheckURL(r.Context(), u); err != nil {
+		problem.WriteDetails(w, r, http.StatusUnprocessableEntity, problem.CodeValidation, "url must not point at a loopback, link-local or private address",
+			map[string]string{"field": "url"})
+		return models.Webhook{}, false
+	}
+
+	if len(hook.Events) == 0 {
+		problem.WriteDetails(w, r, http.StatusUnprocessableEntity, problem.CodeValidation, "events must not be empty",
+			map[string]string{"field": "events"})
+		return models.Webhook{}, false
+	}
+	for _, ev := range hook.Events {
+		if !webhookEvents[ev] {
+			problem.WriteDetails(w, r, http.StatusUnprocessableEntity, problem.CodeValidation, "unknown event "+ev,
+				map[string]string{"field": "events"})
+			return models.Webhook{}, false
+		}
+	}
+
+	return models.Webhook{URL: hook.URL, Events: hook.Events}, true
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+func newWebhooksRouter(h *Webhooks) *chi.Mux {
+	r := chi.NewRouter()
+	ra := r.With(middlewares.Auth)
+	ra.Post("/api/user/webhooks", h.Create)
+	ra.Get("/api/user/webhooks", h.List)
+	ra.Get("/api/user/webhooks/{id}", h.Get)
+	ra.Put("/api/user/webhooks/{id}", h.Update)
+	ra.Delete("/api/user/webhooks/{id}", h.Delete)
+	ra.Post("/api/user/webhooks/{id}/ping", h.Ping)
+
+	return r
+}
+
+func TestWebhooksHandler(t *testing.T) {
+	tStore := &testWebhookStore{hooks: map[int64]models.Webhook{}}
+	testRouter := newWebhooksRouter(&Webhooks{Store: tStore})
+
+	request := func(method, path, body string) *http.Request {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req.WithContext(contextWithJwt(context.Background(), "test user"))
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		wr := serveHTTP(testRouter, request("POST", "/api/user/webhooks",
+			`{"url":"https://example.com/hook","events":["order.status_changed"]}`))
+
+		if wr.Code != http.StatusCreated {
+			t.Fatal("error, code not 201, code:", wr.Code)
+		}
+
+		var res models.Webhook
+		if err := json.Unmarshal(wr.Body.Bytes(), &res); err != nil {
+			t.Fatal("response body not json", err)
+		}
+		if res.ID == 0 || len(res.Secret) != 64 || res.URL != "https://example.com/hook" {
+			t.Error("wrong response", res)
+		}
+	})
+
+	t.Run("WrongURL", func(t *testing.T) {
+		wr := serveHTTP(testRouter, request("POST", "/api/user/webhooks",
+			`{"url":"ftp://example.com","events":["order.status_changed"]}`))
+
+		if wr.Code != http.StatusUnprocessableEntity {
+			t.Fatal("error, code not 422, code:", wr.Code)
+		}
+	})
+
+	t.Run("InternalURL", func(t *testing.T) {
+		for _, u := range []string{
+			"http://127.0.0.1:8080/hook",
+			"http://169.254.169.254/latest/meta-data",
+			"https://10.0.0.1/hook",
+			"https://192.168.1.10/hook",
+			"http://[::1]/hook",
+			"http://0.0.0.0/hook",
+		} {
+			wr := serveHTTP(testRouter, request("POST", "/api/user/webhooks",
+				`{"url":"`+u+`","events":["order.status_changed"]}`))
+
+			if wr.Code != http.StatusUnprocessableEntity {
+				t.Error(u, "code not 422, code:", wr.Code)
+			}
+		}
+	})
+
+	t.Run("UnknownEvent", func(t *testing.T) {
+		wr := serveHTTP(testRouter, request("POST", "/api/user/webhooks",
+			`{"url":"https://example.com/hook","events":["user.deleted"]}`))
+
+		if wr.Code != http.StatusUnprocessableEntity {
+			t.Fatal("error, code not 422, code:", wr.Code)
+		}
+	})
+
+	t.Run("GetHidesSecret", func(t *testing.T) {
+		wr := serveHTTP(testRouter, request("GET", "/api/user/webhooks/1", ""))
+
+		if wr.Code != http.StatusOK {
+			t.Fatal("error, code not 200, code:", wr.Code)
+		}
+		if strings.Contains(wr.Body.String(), "secret") {
+			t.Error("secret in response", wr.Body.String())
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		wr := serveHTTP(testRouter, request("PUT", "/api/user/webhooks/1",
+			`{"url":"https://example.com/other","events":["withdrawal.created"]}`))
+
+		if wr.Code != http.StatusOK {
+			t.Fatal("error, code not 200, code:", wr.Code)
+		}
+		if tStore.hooks[1].URL != "https://example.com/other" {
+			t.Error("webhook not updated", tStore.hooks[1])
+		}
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		wr := serveHTTP(testRouter, request("POST", "/api/user/webhooks/1/ping", ""))
+
+		if wr.Code != http.StatusAccepted {
+			t.Fatal("error, code not 202, code:", wr.Code)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		for _, req := range []*http.Request{
+			request("GET", "/api/user/webhooks/2", ""),
+			request("DELETE", "/api/user/webhooks/2", ""),
+			request("POST", "/api/user/webhooks/2/ping", ""),
+			request("GET", "/api/user/webhooks/abc", ""),
+		} {
+			wr := serveHTTP(testRouter, req)
+			if wr.Code != http.StatusNotFound {
+				t.Error(req.Method, req.URL, "error, code not 404, code:", wr.Code)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		wr := serveHTTP(testRouter, request("DELETE", "/api/user/webhooks/1", ""))
+
+		if wr.Code != http.StatusNoContent {
+			t.Fatal("error, code not 204, code:", wr.Code)
+		}
+
+		wr = serveHTTP(testRouter, request("GET", "/api/user/webhooks", ""))
+		if wr.Code != http.StatusNoContent {
+			t.Fatal("error, code not 204, code:", wr.Code)
+		}
+	})
+
+	req, err := http.NewRequest("GET", "/api/user/webhooks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("WithoutJwt", withoutJwtTestFunc(req, testRouter))
+}
+
+type testWebhookStore struct {
+	hooks map[int64]models.Webhook
+	next  int64
+}
+
+func (t *testWebhookStore) CreateWebhook(ctx context.Context, uuid string, hook models.Webhook) (models.Webhook, error) {
+	t.next++
+	hook.ID = t.next
+	t.hooks[hook.ID] = hook
+	return hook, nil
+}
+
+func (t *testWebhookStore) Webhooks(ctx context.Context, uuid string) ([]models.Webhook, error) {
+	var res []models.Webhook
+	for _, h := range t.hooks {
+		h.Secret = ""
+		res = append(res, h)
+	}
+	return res, nil
+}
+
+func (t *testWebhookStore) Webhook(ctx context.Context, uuid string, id int64) (models.Webhook, bool, error) {
+	h, ok := t.hooks[id]
+	h.Secret = ""
+	return h, ok, nil
+}
+
+func (t *testWebhookStore) UpdateWebhook(ctx context.Context, uuid string, hook models.Webhook) (bool, error) {
+	if _, ok := t.hooks[hook.ID]; !ok {
+		return false, nil
+	}
+	t.hooks[hook.ID] = hook
+	return true, nil
+}
+
+func (t *testWebhookStore) DeleteWebhook(ctx context.Context, uuid string, id int64) (bool, error) {
+	_, ok := t.hooks[id]
+	delete(t.hooks, id)
+	return ok, nil
+}
+
+func (t *testWebhookStore) PingWebhook(ctx context.Context, uuid string, id int64) (bool, error) {
+	_, ok := t.hooks[id]
+	return ok, nil
+}
+
+func (t *testWebhookStore) WebhookDeliveries(ctx context.Context, uuid string, id int64, limit int) ([]models.WebhookDelivery, bool, error) {
+	_, ok := t.hooks[id]
+	return nil, ok, nil
+}
@@ -0,0 +1,72 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func ok(ctx context.Context) error { return nil }
+
+func fail(ctx context.Context) error { return errors.New("down") }
+
+func TestReady(t *testing.T) {
+	tt := []struct {
+		name   string
+		checks []Check
+		down   bool
+		status int
+		want   string
+	}{
+		{"OK", []Check{{Name: "db", Critical: true, Run: ok}, {Name: "accrual", Run: ok}}, false, http.StatusOK, StatusOK},
+		{"Degraded", []Check{{Name: "db", Critical: true, Run: ok}, {Name: "accrual", Run: fail}}, false, http.StatusOK, StatusDegraded},
+		{"Unavailable", []Check{{Name: "db", Critical: true, Run: fail}, {Name: "accrual", Run: fail}}, false, http.StatusServiceUnavailable, StatusUnavailable},
+		{"ShuttingDown", []Check{{Name: "db", Critical: true, Run: ok}}, true, http.StatusServiceUnavailable, StatusUnavailable},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Checker{Checks: tc.checks}
+			if tc.down {
+				c.ShutDown()
+			}
+
+			w := httptest.NewRecorder()
+			c.Ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if w.Code != tc.status {
+				t.Errorf("got status %d, want %d", w.Code, tc.status)
+			}
+			var report Report
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tc.want {
+				t.Errorf("got %s, want %s", report.Status, tc.want)
+			}
+			for _, check := range tc.checks {
+				res, found := report.Checks[check.Name]
+				if !found {
+					t.Errorf("no result for %s", check.Name)
+				}
+				if (check.Run(context.Background()) != nil) != (res.Error != "") {
+					t.Errorf("wrong result for %s: %+v", check.Name, res)
+				}
+			}
+		})
+	}
+}
+
+func TestLive(t *testing.T) {
+	c := Checker{Checks: []Check{{Name: "db", Critical: true, Run: fail}}}
+	c.ShutDown()
+
+	w := httptest.NewRecorder()
+	c.Live(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want 200", w.Code)
+	}
+}
@@ -9,3 +9,9 @@ import (
 type Scores interface {
 	GetScore(ctx context.Context, order string) (new models.Order, toManyReq bool, err error)
 }
+
+// Reloader rereads the configuration and applies what can change at
+// runtime.
+type Reloader interface {
+	Reload(ctx context.Context) (models.ReloadResult, error)
+}
@@ -2,6 +2,7 @@ package interfaces
 
 import (
 	"context"
+	"time"
 
 	"github.com/e-faizov/gophermart/internal/models"
 )
@@ -12,20 +13,90 @@ type UserStorage interface {
 }
 
 type OrdersStorage interface {
-	SaveOrder(ctx context.Context, user, order string) (inserted bool, thisUser bool, err error)
-	GetOrders(ctx context.Context, user string) ([]models.Order, error)
+	SaveOrder(ctx context.Context, user, order string) (models.SaveResult, error)
+	SaveOrders(ctx context.Context, user string, orders []string) (map[string]string, error)
+	GetOrders(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error)
+	// ClaimOrder returns an order in status not checked since
+	// checkedBefore and marks it checked.
+	ClaimOrder(ctx context.Context, status string, checkedBefore time.Time) (order string, notFound bool, err error)
 	NewUpdaterTx(ctx context.Context) (OrderUpdateTx, error)
 }
 
 type OrderUpdateTx interface {
-	GetOrderIdsByStatus(ctx context.Context, status string) (string, bool, error)
 	UpdateOrder(ctx context.Context, order models.Order) error
+	SignatureStorage
 	Rollback() error
 	Commit() error
 }
 
 type BalanceStorage interface {
 	Withdraw(ctx context.Context, withdraw models.Withdraw, uuid string) (notEnough bool, err error)
-	WithdrawalsByUser(ctx context.Context, uuid string) ([]models.Withdraw, error)
+	WithdrawalsByUser(ctx context.Context, uuid string, query models.WithdrawalsQuery) ([]models.Withdraw, error)
 	BalanceByUser(ctx context.Context, uuid string) (models.Balance, error)
 }
+
+type ExportStorage interface {
+	ExportOrders(ctx context.Context, from, to *time.Time, fn func(models.OrderExport) error) error
+	ExportWithdrawals(ctx context.Context, from, to *time.Time, fn func(models.WithdrawExport) error) error
+}
+
+type StatementStorage interface {
+	Statement(ctx context.Context, uuid string, from, to *time.Time) (models.Statement, error)
+}
+
+type OutboxStorage interface {
+	ClaimEvents(ctx context.Context, sink string, limit int, lease time.Duration) ([]models.Event, error)
+	MarkDelivered(ctx context.Context, sink string, ids []int64, sinks []string) error
+	PurgeEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+type WebhookStorage interface {
+	CreateWebhook(ctx context.Context, uuid string, hook models.Webhook) (models.Webhook, error)
+	Webhooks(ctx context.Context, uuid string) ([]models.Webhook, error)
+	Webhook(ctx context.Context, uuid string, id int64) (hook models.Webhook, found bool, err error)
+	UpdateWebhook(ctx context.Context, uuid string, hook models.Webhook) (found bool, err error)
+	DeleteWebhook(ctx context.Context, uuid string, id int64) (found bool, err error)
+	PingWebhook(ctx context.Context, uuid string, id int64) (found bool, err error)
+	WebhookDeliveries(ctx context.Context, uuid string, id int64, limit int) ([]models.WebhookDelivery, bool, error)
+}
+
+type WebhookDispatchStorage interface {
+	EnqueueWebhookEvents(ctx context.Context, events []models.Event) error
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	SaveAttempt(ctx context.Context, delivery models.WebhookDelivery) error
+}
+
+type EventStorage interface {
+	// EventsAfter returns the events of uuid after the cursor that no
+	// transaction still running can precede. held is set when there are
+	// more, committed but held back until such transactions end.
+	EventsAfter(ctx context.Context, uuid string, after models.EventCursor, types []string, limit int) (evs []models.Event, held bool, err error)
+	// EventCursor is a cursor before every event not committed yet.
+	EventCursor(ctx context.Context) (models.EventCursor, error)
+	ListenEvents(ctx context.Context, notify func(id int64, uuid string)) error
+}
+
+type SignatureStorage interface {
+	SaveSignature(ctx context.Context, sig string, expires time.Time) (fresh bool, err error)
+}
+
+type MerchantStorage interface {
+	CreateMerchant(ctx context.Context, merchant models.Merchant, key string) (res models.Merchant, ok bool, err error)
+	MerchantByKey(ctx context.Context, key string) (merchant models.Merchant, found bool, err error)
+	SavePartnerAudit(ctx context.Context, entry models.PartnerAudit) error
+	PartnerAudit(ctx context.Context, merchantID int64, limit int) ([]models.PartnerAudit, error)
+}
+
+type PartnerStorage interface {
+	AttachOrder(ctx context.Context, merchantID int64, order models.PartnerOrder) (models.SaveResult, error)
+	PartnerOrder(ctx context.Context, merchantID int64, number string) (order models.Order, found bool, err error)
+	ReverseWithdrawal(ctx context.Context, merchantID int64, order string) (models.ReversalResult, error)
+}
+
+type LinkTokenStorage interface {
+	CreateLinkToken(ctx context.Context, uuid string, token models.LinkToken) error
+}
+
+type QueueStorage interface {
+	OrdersByStatus(ctx context.Context) (map[string]int64, error)
+}
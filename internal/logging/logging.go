@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+func init() {
+	// log.Ctx on a context without a logger writes to the global logger
+	// instead of dropping the line.
+	zerolog.DefaultContextLogger = &log.Logger
+}
+
+// Setup configures the global logger, the one log.Ctx falls back to for a
+// context without a logger. sample > 1 keeps every sample-th debug and
+// info message, warnings and errors are always written. The level and the
+// sampling can be changed later with SetLevel.
+func Setup(level, format string, sample uint) error {
+	return setup(os.Stderr, level, format, sample)
+}
+
+func setup(out io.Writer, level, format string, sample uint) error {
+	switch format {
+	case FormatJSON, "":
+	case FormatConsole:
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339}
+	default:
+		return errors.New("unknown log format: " + format)
+	}
+
+	if err := SetLevel(level, sample); err != nil {
+		return err
+	}
+	log.Logger = zerolog.New(out).Sample(sampler).With().Timestamp().Logger()
+	return nil
+}
+
+// SetLevel changes the level and the sampling of every logger at once,
+// request loggers derived before the call included. It is safe to call
+// while logging.
+func SetLevel(level string, sample uint) error {
+	lvl, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if lvl == zerolog.NoLevel {
+		lvl = zerolog.InfoLevel
+	}
+
+	zerolog.SetGlobalLevel(lvl)
+	sampler.n.Store(uint32(sample))
+	return nil
+}
+
+// sampler is shared by all loggers, so SetLevel can change the sampling
+// without replacing them.
+var sampler = &levelSampler{}
+
+// levelSampler keeps every n-th trace, debug and info message like
+// zerolog.BasicSampler, n <= 1 keeps all.
+type levelSampler struct {
+	n       atomic.Uint32
+	counter atomic.Uint32
+}
+
+func (s *levelSampler) Sample(lvl zerolog.Level) bool {
+	if lvl > zerolog.InfoLevel {
+		return true
+	}
+	n := s.n.Load()
+	if n <= 1 {
+		return true
+	}
+	return s.counter.Add(1)%n == 1
+}
+
+// SetUser tags the request logger in ctx with the user UUID, the lines
+// logged after it, the access line included, carry it. The global logger
+// is left as is.
+func SetUser(ctx context.Context, uuid string) {
+	l := log.Ctx(ctx)
+	if l == zerolog.DefaultContextLogger {
+		return
+	}
+	l.UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str("user", uuid)
+	})
+}
+
+// SetMerchant tags the request logger in ctx with the merchant ID like
+// SetUser does with the user.
+func SetMerchant(ctx context.Context, id int64) {
+	l := log.Ctx(ctx)
+	if l == zerolog.DefaultContextLogger {
+		return
+	}
+	l.UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Int64("merchant", id)
+	})
+}
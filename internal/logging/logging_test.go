@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestSetup(t *testing.T) {
+	saved, savedLevel := log.Logger, zerolog.GlobalLevel()
+	defer func() {
+		log.Logger = saved
+		zerolog.SetGlobalLevel(savedLevel)
+		sampler.n.Store(0)
+	}()
+
+	var buf bytes.Buffer
+	if err := setup(&buf, "warn", FormatJSON, 0); err != nil {
+		t.Fatal(err)
+	}
+	log.Info().Msg("hidden")
+	log.Warn().Msg("shown")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, `"message":"shown"`) {
+		t.Errorf("wrong output %q", out)
+	}
+
+	buf.Reset()
+	if err := setup(&buf, "debug", FormatJSON, 3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		log.Info().Msg("sampled")
+		log.Error().Msg("kept")
+	}
+	if n := strings.Count(buf.String(), "sampled"); n != 2 {
+		t.Errorf("got %d of 6 sampled info lines, want 2", n)
+	}
+	if n := strings.Count(buf.String(), "kept"); n != 6 {
+		t.Errorf("got %d of 6 error lines, want 6", n)
+	}
+
+	buf.Reset()
+	if err := SetLevel("warn", 0); err != nil {
+		t.Fatal(err)
+	}
+	log.Info().Msg("hidden")
+	log.Error().Msg("shown")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "shown") {
+		t.Errorf("wrong output after SetLevel %q", out)
+	}
+
+	if err := setup(&buf, "loud", FormatJSON, 0); err == nil {
+		t.Error("no error for a wrong level")
+	}
+	if err := setup(&buf, "info", "xml", 0); err == nil {
+		t.Error("no error for a wrong format")
+	}
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolCollector reads the statistics of a pgx connection pool on every
+// scrape, labelled with db_name.
+type PoolCollector struct {
+	pool  *pgxpool.Pool
+	descs map[string]*prometheus.Desc
+}
+
+// poolMetrics are the pool statistics by name, the gauges first.
+var poolMetrics = []struct {
+	name, help string
+	counter    bool
+	value      func(s *pgxpool.Stat) float64
+}{
+	{"max_conns", "Maximum size of the pool.", false,
+		func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }},
+	{"total_conns", "Connections in the pool, idle, acquired and being constructed.", false,
+		func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }},
+	{"acquired_conns", "Connections in use.", false,
+		func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }},
+	{"idle_conns", "Idle connections.", false,
+		func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }},
+	{"constructing_conns", "Connections being opened.", false,
+		func(s *pgxpool.Stat) float64 { return float64(s.ConstructingConns()) }},
+	{"acquires_total", "Successful connection acquires.", true,
+		func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }},
+	{"acquire_duration_seconds_total", "Time spent acquiring connections.", true,
+		func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }},
+	{"empty_acquires_total", "Acquires that waited for a connection.", true,
+		func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }},
+	{"canceled_acquires_total", "Acquires canceled by their context.", true,
+		func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) }},
+	{"new_conns_total", "Connections opened.", true,
+		func(s *pgxpool.Stat) float64 { return float64(s.NewConnsCount()) }},
+	{"max_lifetime_destroys_total", "Connections closed for reaching the maximum lifetime.", true,
+		func(s *pgxpool.Stat) float64 { return float64(s.MaxLifetimeDestroyCount()) }},
+	{"max_idle_destroys_total", "Connections closed for staying idle too long.", true,
+		func(s *pgxpool.Stat) float64 { return float64(s.MaxIdleDestroyCount()) }},
+}
+
+func NewPoolCollector(pool *pgxpool.Pool, dbName string) *PoolCollector {
+	c := &PoolCollector{
+		pool:  pool,
+		descs: make(map[string]*prometheus.Desc, len(poolMetrics)),
+	}
+	for _, m := range poolMetrics {
+		c.descs[m.name] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "db_pool", m.name),
+			m.help, nil, prometheus.Labels{"db_name": dbName},
+		)
+	}
+	return c
+}
+
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.descs {
+		ch <- d
+	}
+}
+
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	for _, m := range poolMetrics {
+		tp := prometheus.GaugeValue
+		if m.counter {
+			tp = prometheus.CounterValue
+		}
+		ch <- prometheus.MustNewConstMetric(c.descs[m.name], tp, m.value(stat))
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPoolCollector(t *testing.T) {
+	cfg, err := pgxpool.ParseConfig("postgres://localhost:1/gophermart?pool_max_conns=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pool connects lazily, no server is needed for the statistics
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	c := NewPoolCollector(pool, "gophermart")
+	if n := testutil.CollectAndCount(c); n != len(poolMetrics) {
+		t.Errorf("got %d metrics, want %d", n, len(poolMetrics))
+	}
+
+	want := `
+# HELP gophermart_db_pool_max_conns Maximum size of the pool.
+# TYPE gophermart_db_pool_max_conns gauge
+gophermart_db_pool_max_conns{db_name="gophermart"} 7
+# HELP gophermart_db_pool_acquires_total Successful connection acquires.
+# TYPE gophermart_db_pool_acquires_total counter
+gophermart_db_pool_acquires_total{db_name="gophermart"} 0
+`
+	err = testutil.CollectAndCompare(c, strings.NewReader(want),
+		"gophermart_db_pool_max_conns", "gophermart_db_pool_acquires_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+)
+
+var queueDepthDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "orders", "queue_depth"),
+	"Orders by status, NEW and PROCESSING are still waiting for the accrual system.",
+	[]string{"status"}, nil,
+)
+
+// QueueCollector reads order counts per status from Store on every scrape.
+type QueueCollector struct {
+	Store interfaces.QueueStorage
+	// Timeout bounds the query, a second by default.
+	Timeout time.Duration
+}
+
+func (c *QueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+}
+
+func (c *QueueCollector) Collect(ch chan<- prometheus.Metric) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	counts, err := c.Store.OrdersByStatus(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("QueueCollector.Collect error count orders")
+		ch <- prometheus.NewInvalidMetric(queueDepthDesc, err)
+		return
+	}
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(count), status)
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type testQueueStore struct {
+	counts map[string]int64
+	err    error
+}
+
+func (s *testQueueStore) OrdersByStatus(ctx context.Context) (map[string]int64, error) {
+	return s.counts, s.err
+}
+
+func TestQueueCollector(t *testing.T) {
+	c := &QueueCollector{Store: &testQueueStore{counts: map[string]int64{"NEW": 3, "PROCESSING": 1}}}
+
+	want := `
+# HELP gophermart_orders_queue_depth Orders by status, NEW and PROCESSING are still waiting for the accrual system.
+# TYPE gophermart_orders_queue_depth gauge
+gophermart_orders_queue_depth{status="NEW"} 3
+gophermart_orders_queue_depth{status="PROCESSING"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueCollectorError(t *testing.T) {
+	c := &QueueCollector{Store: &testQueueStore{err: errors.New("db is down")}}
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader("")); err == nil {
+		t.Error("no error for a failed count")
+	}
+}
@@ -0,0 +1,25 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+// AdminAuth lets through only requests with the "Authorization: Bearer
+// <token>" header.
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "admin token required")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
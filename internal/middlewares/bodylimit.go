@@ -0,0 +1,25 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+// BodyLimit caps the request body at limit bytes. Requests declaring a
+// longer body are answered with 413 at once, reading past the limit fails
+// with *http.MaxBytesError.
+func BodyLimit(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				problem.WriteDetails(w, r, http.StatusRequestEntityTooLarge, problem.CodeTooLarge,
+					"body is too large", map[string]int64{"limit": limit})
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,12 +2,15 @@ package middlewares
 
 import (
 	"context"
-	"github.com/e-faizov/gophermart/internal/models"
-	"github.com/rs/zerolog/log"
 	"net/http"
 
 	"github.com/go-chi/jwtauth"
 	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/logging"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
 )
 
 func Auth(next http.Handler) http.Handler {
@@ -15,23 +18,24 @@ func Auth(next http.Handler) http.Handler {
 		ctx := r.Context()
 		token, claims, err := jwtauth.FromContext(ctx)
 		if err != nil {
-			log.Error().Err(err).Msg("error get jwt from context")
-			http.Error(w, "", http.StatusUnauthorized)
+			log.Ctx(ctx).Error().Err(err).Msg("error get jwt from context")
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "authentication required")
 			return
 		}
 
 		if token == nil || jwt.Validate(token) != nil {
-			http.Error(w, "", http.StatusUnauthorized)
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "authentication required")
 			return
 		}
 
-		ret, ok := claims[models.UserUUID]
+		ret, ok := claims[models.UserUUID].(string)
 		if !ok {
-			log.Error().Err(err).Msg("error can't find user uuid in jwt")
-			http.Error(w, "", http.StatusUnauthorized)
+			log.Ctx(ctx).Error().Msg("error can't find user uuid in jwt")
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "authentication required")
 			return
 		}
 
+		logging.SetUser(ctx, ret)
 		r = r.WithContext(context.WithValue(ctx, models.UUIDKey, ret))
 
 		next.ServeHTTP(w, r)
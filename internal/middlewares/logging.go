@@ -0,0 +1,51 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Logger puts a logger tagged with the request ID and the trace ID into
+// the request context, Auth adds the user UUID to it. Once the request is
+// served an access line is written. It goes after middleware.RequestID
+// and Tracing.
+func Logger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := r.Context()
+
+		lc := log.Ctx(ctx).With().Str("request_id", middleware.GetReqID(ctx))
+		if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+			lc = lc.Str("trace_id", sc.TraceID().String())
+		}
+		// SetUser updates the logger stored in the context, so the access
+		// line is written with that one.
+		reqCtx := lc.Logger().WithContext(ctx)
+		logger := log.Ctx(reqCtx)
+
+		ww := wrapWriter(w, r)
+		next.ServeHTTP(ww, r.WithContext(reqCtx))
+
+		route := routePattern(r)
+		status := ww.Status()
+
+		var e *zerolog.Event
+		if status >= http.StatusInternalServerError {
+			e = logger.Error()
+		} else {
+			e = logger.Info()
+		}
+		e.Str("method", r.Method).
+			Str("route", route).
+			Str("path", r.URL.Path).
+			Int("status", status).
+			Int("bytes", ww.BytesWritten()).
+			Dur("duration", time.Since(start)).
+			Msg("request")
+	})
+}
@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/logging"
+)
+
+func TestLoggerCorrelation(t *testing.T) {
+	var buf bytes.Buffer
+	base := zerolog.New(&buf)
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(base.WithContext(r.Context())))
+		})
+	})
+	r.Use(middleware.RequestID)
+	r.Use(Logger)
+	r.Get("/api/user/orders", func(w http.ResponseWriter, r *http.Request) {
+		logging.SetUser(r.Context(), "user-uuid")
+		log.Ctx(r.Context()).Warn().Msg("handler line")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/user/orders", nil))
+
+	dec := json.NewDecoder(&buf)
+	var lines []map[string]interface{}
+	for dec.More() {
+		var line map[string]interface{}
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+
+	for _, line := range lines {
+		if line["request_id"] == "" || line["request_id"] == nil {
+			t.Errorf("no request_id in %v", line)
+		}
+		if line["user"] != "user-uuid" {
+			t.Errorf("no user in %v", line)
+		}
+	}
+	access := lines[1]
+	if access["route"] != "/api/user/orders" || access["status"] != float64(http.StatusNoContent) {
+		t.Errorf("wrong access line %v", access)
+	}
+	if lines[0]["request_id"] != access["request_id"] {
+		t.Error("handler and access lines have different request ids")
+	}
+}
@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/e-faizov/gophermart/internal/metrics"
+)
+
+func TestMetricsRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Metrics)
+	r.Route("/api/user/webhooks", func(r chi.Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {})
+		r.Delete("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+	})
+
+	found := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/api/user/webhooks/{id}", "200")
+	notFound := metrics.HTTPRequests.WithLabelValues(http.MethodDelete, "/api/user/webhooks/{id}", "404")
+	unmatched := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "unmatched", "404")
+	before := []float64{testutil.ToFloat64(found), testutil.ToFloat64(notFound), testutil.ToFloat64(unmatched)}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/api/user/webhooks/1", nil),
+		httptest.NewRequest(http.MethodGet, "/api/user/webhooks/2", nil),
+		httptest.NewRequest(http.MethodDelete, "/api/user/webhooks/3", nil),
+		httptest.NewRequest(http.MethodGet, "/nowhere", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if got := testutil.ToFloat64(found) - before[0]; got != 2 {
+		t.Errorf("GET /api/user/webhooks/{id} 200: got %v requests, want 2", got)
+	}
+	if got := testutil.ToFloat64(notFound) - before[1]; got != 1 {
+		t.Errorf("DELETE /api/user/webhooks/{id} 404: got %v requests, want 1", got)
+	}
+	if got := testutil.ToFloat64(unmatched) - before[2]; got != 1 {
+		t.Errorf("unmatched 404: got %v requests, want 1", got)
+	}
+}
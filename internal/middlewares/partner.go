@@ -0,0 +1,158 @@
+package middlewares
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/logging"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+// PartnerAuth finds the merchant by the "Authorization: Bearer <api key>"
+// header and puts it in the context under models.MerchantKey.
+func PartnerAuth(store interfaces.MerchantStorage) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if key == "" {
+				problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "api key required")
+				return
+			}
+			merchant, found, err := store.MerchantByKey(ctx, key)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("error find merchant")
+				problem.Internal(w, r)
+				return
+			}
+			if !found {
+				problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "api key required")
+				return
+			}
+
+			logging.SetMerchant(ctx, merchant.ID)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, models.MerchantKey, merchant)))
+		})
+	}
+}
+
+// PartnerAudit records every call of an authenticated merchant, the
+// rejected ones included. A failed record is logged, the response is sent
+// by then.
+func PartnerAudit(store interfaces.MerchantStorage) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			merchant := r.Context().Value(models.MerchantKey).(models.Merchant)
+			entry := models.PartnerAudit{
+				MerchantID: merchant.ID,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Status:     status,
+				RequestID:  middleware.GetReqID(r.Context()),
+				Created:    time.Now(),
+			}
+
+			// the call is recorded even when the client is gone
+			logger := log.Ctx(r.Context())
+			if err := store.SavePartnerAudit(logger.WithContext(context.Background()), entry); err != nil {
+				logger.Error().Err(err).Msg("error save partner audit")
+			}
+		})
+	}
+}
+
+// RequireScope lets through merchants whose key has scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			merchant := r.Context().Value(models.MerchantKey).(models.Merchant)
+			for _, s := range merchant.Scopes {
+				if s == scope {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			problem.Write(w, r, http.StatusForbidden, problem.CodeForbidden, "api key lacks scope "+scope)
+		})
+	}
+}
+
+// MerchantLimiter is a token bucket per merchant: Rate calls a second on
+// average and Burst at once.
+type MerchantLimiter struct {
+	Rate  float64
+	Burst int
+
+	mu       sync.Mutex
+	limiters map[int64]*rate.Limiter
+}
+
+func (l *MerchantLimiter) limiter(id int64) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limiters == nil {
+		l.limiters = make(map[int64]*rate.Limiter)
+	}
+	lim, ok := l.limiters[id]
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(l.Rate), l.Burst)
+		l.limiters[id] = lim
+	}
+	return lim
+}
+
+// SetRate changes the limit of every merchant, the tokens already in the
+// buckets are kept.
+func (l *MerchantLimiter) SetRate(r float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Rate, l.Burst = r, burst
+	now := time.Now()
+	for _, lim := range l.limiters {
+		lim.SetLimitAt(now, rate.Limit(r))
+		lim.SetBurstAt(now, burst)
+	}
+}
+
+// Middleware answers calls over the limit with 429 and Retry-After.
+func (l *MerchantLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		merchant := r.Context().Value(models.MerchantKey).(models.Merchant)
+
+		now := time.Now()
+		res := l.limiter(merchant.ID).ReserveN(now, 1)
+		if delay := res.DelayFrom(now); !res.OK() || delay > 0 {
+			res.CancelAt(now)
+			retry := int64(1)
+			if res.OK() && delay > time.Second {
+				retry = int64(math.Ceil(delay.Seconds()))
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+			problem.Write(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
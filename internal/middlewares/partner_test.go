@@ -0,0 +1,131 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+type testMerchantStore struct {
+	mu    sync.Mutex
+	audit []models.PartnerAudit
+	byKey map[string]models.Merchant
+}
+
+func (t *testMerchantStore) CreateMerchant(ctx context.Context, merchant models.Merchant, key string) (models.Merchant, bool, error) {
+	return models.Merchant{}, false, nil
+}
+
+func (t *testMerchantStore) MerchantByKey(ctx context.Context, key string) (models.Merchant, bool, error) {
+	m, ok := t.byKey[key]
+	return m, ok, nil
+}
+
+func (t *testMerchantStore) SavePartnerAudit(ctx context.Context, entry models.PartnerAudit) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.audit = append(t.audit, entry)
+	return nil
+}
+
+func (t *testMerchantStore) PartnerAudit(ctx context.Context, merchantID int64, limit int) ([]models.PartnerAudit, error) {
+	return nil, nil
+}
+
+func TestPartnerRouter(t *testing.T) {
+	store := &testMerchantStore{byKey: map[string]models.Merchant{
+		"gm_reader": {ID: 1, Name: "reader", Scopes: []string{models.ScopeOrdersRead}},
+		"gm_writer": {ID: 2, Name: "writer", Scopes: []string{models.ScopeOrdersWrite}},
+	}}
+	limiter := &MerchantLimiter{Rate: 0.001, Burst: 2}
+
+	r := chi.NewRouter()
+	r.Route("/api/partner", func(r chi.Router) {
+		r.Use(PartnerAuth(store), PartnerAudit(store), limiter.Middleware)
+		r.With(RequireScope(models.ScopeOrdersRead)).Get("/orders/{number}", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/partner/orders/12345678903", nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		wr := httptest.NewRecorder()
+		r.ServeHTTP(wr, req)
+		return wr
+	}
+
+	if wr := get(""); wr.Code != http.StatusUnauthorized {
+		t.Error("no key: code", wr.Code, "want 401")
+	}
+	if wr := get("gm_unknown"); wr.Code != http.StatusUnauthorized {
+		t.Error("unknown key: code", wr.Code, "want 401")
+	}
+	if wr := get("gm_writer"); wr.Code != http.StatusForbidden {
+		t.Error("missing scope: code", wr.Code, "want 403")
+	}
+	for i := 0; i < 2; i++ {
+		if wr := get("gm_reader"); wr.Code != http.StatusOK {
+			t.Error("within burst: code", wr.Code, "want 200")
+		}
+	}
+	wr := get("gm_reader")
+	if wr.Code != http.StatusTooManyRequests {
+		t.Error("over the limit: code", wr.Code, "want 429")
+	}
+	if wr.Header().Get("Retry-After") == "" {
+		t.Error("over the limit: no Retry-After")
+	}
+
+	want := []struct {
+		merchant int64
+		status   int
+	}{
+		{2, http.StatusForbidden},
+		{1, http.StatusOK},
+		{1, http.StatusOK},
+		{1, http.StatusTooManyRequests},
+	}
+	if len(store.audit) != len(want) {
+		t.Fatal("audited", len(store.audit), "calls, want", len(want))
+	}
+	for i, w := range want {
+		a := store.audit[i]
+		if a.MerchantID != w.merchant || a.Status != w.status || a.Path != "/api/partner/orders/12345678903" {
+			t.Error("wrong audit entry", i, a)
+		}
+	}
+}
+
+func TestMerchantLimiterSetRate(t *testing.T) {
+	limiter := &MerchantLimiter{Rate: 0.001, Burst: 1}
+	h := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), models.MerchantKey, models.Merchant{ID: 1}))
+		wr := httptest.NewRecorder()
+		h.ServeHTTP(wr, req)
+		return wr.Code
+	}
+
+	if code := call(); code != http.StatusOK {
+		t.Fatal("within burst: code", code, "want 200")
+	}
+	// the spent bucket isn't refilled by a new burst
+	limiter.SetRate(0.001, 2)
+	if code := call(); code != http.StatusTooManyRequests {
+		t.Fatal("after SetRate: code", code, "want 429")
+	}
+	limiter.SetRate(1000, 2)
+	time.Sleep(10 * time.Millisecond)
+	if code := call(); code != http.StatusOK {
+		t.Fatal("after a faster rate: code", code, "want 200")
+	}
+}
@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// statusWriter records the status and the size of a response for the
+// middlewares that report on it once the request is served.
+type statusWriter struct {
+	middleware.WrapResponseWriter
+}
+
+func wrapWriter(w http.ResponseWriter, r *http.Request) statusWriter {
+	return statusWriter{middleware.NewWrapResponseWriter(w, r.ProtoMajor)}
+}
+
+// Status is the status sent, 200 when the handler wrote none.
+func (w statusWriter) Status() int {
+	if status := w.WrapResponseWriter.Status(); status != 0 {
+		return status
+	}
+	return http.StatusOK
+}
+
+// routePattern is the chi route pattern r matched, "unmatched" when no
+// route did.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+	return "unmatched"
+}
@@ -0,0 +1,15 @@
+package models
+
+// Outcomes of an order number in a batch upload, the same as the single
+// upload answers 202, 200, 409 and 422.
+const (
+	BatchAccepted        = "accepted"
+	BatchAlreadyUploaded = "already_uploaded"
+	BatchConflict        = "conflict"
+	BatchInvalid         = "invalid"
+)
+
+type BatchResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventUserRegistered     = "user.registered"
+	EventOrderStatusChanged = "order.status_changed"
+	EventAccrualCredited    = "accrual.credited"
+	EventWithdrawalCreated  = "withdrawal.created"
+	EventBalanceChanged     = "balance.changed"
+)
+
+type Event struct {
+	ID      int64           `json:"id"`
+	Type    string          `json:"type"`
+	User    string          `json:"user"`
+	Payload json.RawMessage `json:"payload"`
+	Created time.Time       `json:"created_at"`
+	// Xact is the transaction that wrote the event, only the user's
+	// event stream reads it.
+	Xact int64 `json:"-"`
+}
+
+// EventCursor is a position in the outbox in commit order: events are
+// ordered by the transaction that wrote them, then by id.
+type EventCursor struct {
+	Xact int64
+	ID   int64
+}
+
+type UserRegisteredEvent struct {
+	Login string `json:"login"`
+}
+
+type AccrualCreditedEvent struct {
+	Order   string  `json:"order"`
+	Accrual float64 `json:"accrual"`
+}
+
+type OrderStatusEvent struct {
+	Number  string   `json:"number"`
+	Status  string   `json:"status"`
+	Accrual *float64 `json:"accrual,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+// OrderExport is an order in the admin bulk export.
+type OrderExport struct {
+	Login string `json:"login"`
+	Order
+}
+
+// WithdrawExport is a withdrawal in the admin bulk export.
+type WithdrawExport struct {
+	Login string `json:"login"`
+	Withdraw
+}
@@ -8,3 +8,13 @@ type Order struct {
 	Accrual  *float64  `json:"accrual,omitempty"`
 	Uploaded time.Time `json:"uploaded_at"`
 }
+
+// SaveResult is the outcome of uploading an order number, answered with
+// 202, 200 and 409.
+type SaveResult int
+
+const (
+	OrderAccepted SaveResult = iota + 1
+	OrderAlreadyUploaded
+	OrderConflict
+)
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+const (
+	SortUploaded = "uploaded_at"
+	SortAccrual  = "accrual"
+)
+
+type OrdersQuery struct {
+	// Limit is the page size, 0 for no limit.
+	Limit    int
+	Sort     string
+	Desc     bool
+	Statuses []string
+	From     *time.Time
+	To       *time.Time
+	After    *OrdersCursor
+}
+
+// OrdersCursor is the position after the last order of a page: its sort key
+// and number as a tie-breaker.
+type OrdersCursor struct {
+	Sort     string    `json:"s"`
+	Desc     bool      `json:"d,omitempty"`
+	Uploaded time.Time `json:"u,omitempty"`
+	Accrual  float64   `json:"a,omitempty"`
+	Number   string    `json:"n"`
+}
+
+type WithdrawalsQuery struct {
+	// Limit is the page size, 0 for no limit.
+	Limit int
+	From  *time.Time
+	To    *time.Time
+	After *WithdrawalsCursor
+}
+
+type WithdrawalsCursor struct {
+	Processed time.Time `json:"p"`
+	Order     string    `json:"o"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Scopes of a merchant API key.
+const (
+	ScopeOrdersWrite        = "orders:write"
+	ScopeOrdersRead         = "orders:read"
+	ScopeWithdrawalsReverse = "withdrawals:reverse"
+)
+
+const MerchantKey ContextKey = "merchant"
+
+type Merchant struct {
+	ID      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created_at"`
+	// APIKey is returned once, when the merchant is created. Only its hash
+	// is stored.
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// PartnerOrder attaches an order to the user with Login or to the one who
+// issued LinkToken, exactly one of them is set.
+type PartnerOrder struct {
+	Number    string `json:"number"`
+	Login     string `json:"login,omitempty"`
+	LinkToken string `json:"link_token,omitempty"`
+}
+
+// LinkToken lets a merchant attach orders to the user without knowing the
+// login. It is good for one order.
+type LinkToken struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires_at"`
+}
+
+type Reversal struct {
+	Order string `json:"order"`
+}
+
+type ReversalResult struct {
+	Order    string    `json:"order"`
+	Sum      float64   `json:"sum"`
+	Reversed time.Time `json:"reversed_at"`
+}
+
+// PartnerAudit is one call of the partner API.
+type PartnerAudit struct {
+	ID         int64     `json:"id"`
+	MerchantID int64     `json:"merchant_id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Created    time.Time `json:"created_at"`
+}
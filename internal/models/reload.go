@@ -0,0 +1,15 @@
+package models
+
+// ConfigChange is a setting that differs in the reloaded configuration.
+type ConfigChange struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// ReloadResult lists the settings a reload applied and the changed ones
+// that wait for a restart, only their keys as they may be secrets.
+type ReloadResult struct {
+	Applied []ConfigChange `json:"applied"`
+	Ignored []string       `json:"ignored"`
+}
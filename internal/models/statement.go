@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+const (
+	EntryAccrual    = "accrual"
+	EntryWithdrawal = "withdrawal"
+	EntryAdjustment = "adjustment"
+)
+
+// StatementEntry is one movement on the account. Amount is negative for
+// withdrawals, Balance is the running balance after the entry.
+type StatementEntry struct {
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"`
+	Reference string    `json:"reference"`
+	Amount    float64   `json:"amount"`
+	Balance   float64   `json:"balance"`
+}
+
+type Statement struct {
+	From    *time.Time       `json:"from,omitempty"`
+	To      *time.Time       `json:"to,omitempty"`
+	Opening float64          `json:"opening_balance"`
+	Closing float64          `json:"closing_balance"`
+	Entries []StatementEntry `json:"entries"`
+}
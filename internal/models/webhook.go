@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+const EventPing = "ping"
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID      int64     `json:"id"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events"`
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID           int64      `json:"id"`
+	WebhookID    int64      `json:"webhook_id"`
+	Event        Event      `json:"event"`
+	Status       string     `json:"status"`
+	Attempts     int        `json:"attempts"`
+	ResponseCode *int       `json:"response_code,omitempty"`
+	LastError    *string    `json:"last_error,omitempty"`
+	NextAttempt  *time.Time `json:"next_attempt_at,omitempty"`
+	Created      time.Time  `json:"created_at"`
+	Delivered    *time.Time `json:"delivered_at,omitempty"`
+
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
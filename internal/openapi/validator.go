@@ -0,0 +1,194 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/problem"
+)
+
+func init() {
+	openapi3filter.RegisterBodyDecoder("application/x-ndjson", openapi3filter.FileBodyDecoder)
+}
+
+// Validator checks requests to the routes of Spec before they reach the
+// handlers. Routes missing from Spec pass through unchecked.
+type Validator struct {
+	// MaxBody caps bodies read for validation, the route limits apply after.
+	MaxBody int64
+	// ValidateResponses buffers and checks responses too. It is meant for
+	// tests, event streams are never checked.
+	ValidateResponses bool
+	// ResponseError is called for a response not matching Spec, the response
+	// is sent anyway. By default the error is logged.
+	ResponseError func(r *http.Request, err error)
+
+	router routers.Router
+}
+
+func NewValidator() (*Validator, error) {
+	doc, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Validator{router: router}, nil
+}
+
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, params, err := v.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !v.contentTypeAllowed(w, r, route.Operation) {
+			return
+		}
+
+		if v.MaxBody > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, v.MaxBody)
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: params,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+				IncludeResponseStatus: true,
+			},
+		}
+		if err = openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			writeRequestError(w, r, err)
+			return
+		}
+
+		if !v.ValidateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.stream {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		out := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rec.status,
+			Header:                 w.Header(),
+			Options:                input.Options,
+		}
+		out.SetBodyBytes(rec.body.Bytes())
+		if err = openapi3filter.ValidateResponse(r.Context(), out); err != nil {
+			if v.ResponseError != nil {
+				v.ResponseError(r, err)
+			} else {
+				log.Error().Err(err).Str("path", r.URL.Path).Msg("response doesn't match the OpenAPI spec")
+			}
+		}
+
+		w.WriteHeader(rec.status)
+		_, _ = w.Write(rec.body.Bytes())
+	})
+}
+
+// contentTypeAllowed answers 415 if the operation takes a body of other
+// types, the validation itself would answer 400.
+func (v *Validator) contentTypeAllowed(w http.ResponseWriter, r *http.Request, op *openapi3.Operation) bool {
+	if op == nil || op.RequestBody == nil || op.RequestBody.Value == nil {
+		return true
+	}
+	content := op.RequestBody.Value.Content
+
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && content.Get(mt) != nil {
+		return true
+	}
+
+	types := make([]string, 0, len(content))
+	for t := range content {
+		types = append(types, t)
+	}
+	problem.WriteDetails(w, r, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType,
+		"Content-Type is not supported", map[string]interface{}{"accepted": types})
+	return false
+}
+
+func writeRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		problem.WriteDetails(w, r, http.StatusRequestEntityTooLarge, problem.CodeTooLarge,
+			"body is too large", map[string]int64{"limit": maxErr.Limit})
+		return
+	}
+
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		log.Error().Err(err).Msg("error validate request")
+		problem.Internal(w, r)
+		return
+	}
+
+	switch {
+	case reqErr.Parameter != nil && reqErr.Parameter.In == openapi3.ParameterInPath:
+		// A malformed id can't name an existing resource.
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "")
+	case reqErr.Parameter != nil:
+		problem.WriteDetails(w, r, http.StatusBadRequest, problem.CodeInvalidQuery, reqErr.Error(),
+			map[string]string{"parameter": reqErr.Parameter.Name})
+	default:
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidBody, reqErr.Error())
+	}
+}
+
+// recorder buffers a response for validation. Event streams are passed
+// through as they can't be buffered.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	stream bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if mt, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type")); mt == "text/event-stream" {
+		rec.stream = true
+		rec.ResponseWriter.WriteHeader(status)
+		return
+	}
+	rec.status = status
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 && !rec.stream {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.stream {
+		return rec.ResponseWriter.Write(b)
+	}
+	return rec.body.Write(b)
+}
+
+func (rec *recorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok && rec.stream {
+		f.Flush()
+	}
+}
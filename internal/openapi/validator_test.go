@@ -0,0 +1,105 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path := range doc.Paths {
+		if !strings.HasPrefix(path, "/api/user/") {
+			t.Error("path outside /api/user:", path)
+		}
+	}
+}
+
+func TestValidatorRequests(t *testing.T) {
+	v, err := NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.MaxBody = 64
+
+	var reached bool
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	for _, tt := range []struct {
+		method, path, contentType, body string
+		code                            int
+	}{
+		{"POST", "/api/user/balance/withdraw", "application/json", `{"order":"2377225624","sum":1}`, http.StatusOK},
+		{"POST", "/api/user/balance/withdraw", "application/json", `{"order":"2377225624"}`, http.StatusBadRequest},
+		{"POST", "/api/user/balance/withdraw", "text/plain", `{"order":"2377225624","sum":1}`, http.StatusUnsupportedMediaType},
+		{"POST", "/api/user/balance/withdraw", "application/json", `{"order":"` + strings.Repeat("1", 64) + `","sum":1}`, http.StatusRequestEntityTooLarge},
+		{"GET", "/api/user/orders?limit=abc", "", "", http.StatusBadRequest},
+		{"GET", "/api/user/orders?sort=number", "", "", http.StatusBadRequest},
+		{"GET", "/api/user/webhooks/abc", "", "", http.StatusNotFound},
+		{"GET", "/api/admin/export/orders", "", "", http.StatusOK},
+	} {
+		reached = false
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		wr := httptest.NewRecorder()
+		h.ServeHTTP(wr, req)
+
+		if wr.Code != tt.code {
+			t.Errorf("%s %s: code %d, want %d: %s", tt.method, tt.path, wr.Code, tt.code, wr.Body.String())
+		}
+		if reached != (tt.code == http.StatusOK) {
+			t.Errorf("%s %s: handler reached %v", tt.method, tt.path, reached)
+		}
+	}
+}
+
+func TestValidatorResponses(t *testing.T) {
+	v, err := NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.ValidateResponses = true
+
+	var failed bool
+	v.ResponseError = func(r *http.Request, err error) {
+		failed = true
+	}
+
+	for body, ok := range map[string]bool{
+		`{"current":1,"withdrawn":0}`:   true,
+		`{"current":"1","withdrawn":0}`: false,
+		`{"current":1}`:                 false,
+	} {
+		failed = false
+		h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(body))
+		}))
+
+		wr := httptest.NewRecorder()
+		h.ServeHTTP(wr, httptest.NewRequest("GET", "/api/user/balance", nil))
+		if failed == ok {
+			t.Errorf("response %s: validation failed %v", body, failed)
+		}
+		if wr.Body.String() != body {
+			t.Errorf("response %s not passed through: %s", body, wr.Body.String())
+		}
+	}
+
+	failed = false
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/user/balance", nil))
+	if !failed {
+		t.Error("undocumented status passed validation")
+	}
+}
@@ -0,0 +1,146 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, events []models.Event) error
+}
+
+// Relay publishes outbox events to every sink. Each sink has a worker of
+// its own that leases a batch, delivers it outside any transaction and
+// marks it delivered for that sink only. A failed batch is retried once its
+// lease runs out, so a slow or failing sink holds up no other: consumers
+// get events at least once and should dedupe by event id.
+type Relay struct {
+	Store     interfaces.OutboxStorage
+	Sinks     []Sink
+	Interval  time.Duration
+	BatchSize int
+	// Lease is how long a batch claimed for a sink is hidden from the other
+	// replicas, it must outlast a delivery.
+	Lease time.Duration
+	// Retention is how long delivered events and finished user webhook
+	// deliveries are kept before purge.
+	Retention time.Duration
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func (r *Relay) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	if r.Interval == 0 {
+		r.Interval = time.Second
+	}
+	if r.BatchSize == 0 {
+		r.BatchSize = 100
+	}
+	if r.Lease == 0 {
+		r.Lease = time.Minute
+	}
+	if r.Retention == 0 {
+		r.Retention = 7 * 24 * time.Hour
+	}
+
+	names := make([]string, 0, len(r.Sinks))
+	for _, s := range r.Sinks {
+		names = append(names, s.Name())
+	}
+	r.wg.Add(len(r.Sinks) + 1)
+	for _, s := range r.Sinks {
+		go r.worker(ctx, s, names)
+	}
+	go r.purger(ctx)
+}
+
+func (r *Relay) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *Relay) purger(ctx context.Context) {
+	defer r.wg.Done()
+	var sleep time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sleep):
+			n, err := r.Store.PurgeEvents(ctx, time.Now().Add(-r.Retention))
+			if err != nil {
+				log.Error().Err(err).Msg("Relay.purger error purge events")
+				sleep = r.Interval
+				continue
+			}
+			log.Info().Msgf("outbox purged %d events and webhook deliveries", n)
+			sleep = time.Hour
+		}
+	}
+}
+
+func (r *Relay) worker(ctx context.Context, s Sink, sinks []string) {
+	defer r.wg.Done()
+	var sleep time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sleep):
+			n, failed, err := r.relay(ctx, s, sinks)
+			if err != nil {
+				log.Error().Err(err).Str("sink", s.Name()).Msg("Relay.worker error relay events")
+				sleep = r.Interval
+				// the failed batch comes back when its lease runs out, in
+				// order with the events after it
+				if failed {
+					sleep = r.Lease
+				}
+				continue
+			}
+
+			// a full batch means there is probably more waiting
+			if n == r.BatchSize {
+				sleep = 0
+				continue
+			}
+			sleep = r.Interval
+		}
+	}
+}
+
+// relay delivers a batch to s, failed is set when s didn't take it.
+func (r *Relay) relay(ctx context.Context, s Sink, sinks []string) (n int, failed bool, err error) {
+	events, err := r.Store.ClaimEvents(ctx, s.Name(), r.BatchSize, r.Lease)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(events) == 0 {
+		return 0, false, nil
+	}
+
+	err = s.Deliver(ctx, events)
+	if err != nil {
+		return 0, true, fmt.Errorf("sink %s: %w", s.Name(), err)
+	}
+
+	ids := make([]int64, 0, len(events))
+	for _, ev := range events {
+		ids = append(ids, ev.ID)
+	}
+	err = r.Store.MarkDelivered(ctx, s.Name(), ids, sinks)
+	if err != nil {
+		return 0, false, err
+	}
+	return len(events), false, nil
+}
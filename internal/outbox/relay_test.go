@@ -0,0 +1,227 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/signature"
+)
+
+func TestRelay(t *testing.T) {
+	secret := []byte("sink secret")
+	verifier := &signature.Verifier{Secret: secret}
+
+	var mu sync.Mutex
+	var hooked []models.Event
+	fail := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		err := verifier.Verify(r.Context(), r.Header.Get(signature.HeaderTimestamp), r.Header.Get(signature.HeaderSignature), body)
+		if err != nil {
+			t.Error("wrong webhook signature:", err)
+		}
+
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var data webhookBody
+		if err = json.Unmarshal(body, &data); err != nil {
+			t.Error("wrong webhook body:", err)
+		}
+		hooked = append(hooked, data.Events...)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	store := &memOutbox{}
+	for i := 1; i <= 3; i++ {
+		store.events = append(store.events, models.Event{
+			ID:      int64(i),
+			Type:    models.EventWithdrawalCreated,
+			User:    "user",
+			Payload: json.RawMessage(`{"order":"2377225624","sum":1}`),
+			Created: time.Now(),
+		})
+	}
+
+	relay := Relay{
+		Store:     store,
+		Sinks:     []Sink{&FileSink{Path: path}, &WebhookSink{URL: srv.URL, Secret: secret}},
+		Interval:  10 * time.Millisecond,
+		BatchSize: 2,
+		Lease:     50 * time.Millisecond,
+	}
+	relay.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && store.pending() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	relay.Stop()
+
+	if store.pending() != 0 {
+		t.Fatal("events not delivered")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hooked) != 3 || hooked[0].ID != 1 || hooked[2].ID != 3 {
+		t.Error("wrong webhook events", hooked)
+	}
+
+	// the failed webhook batch is not sent to the file sink again
+	lines := readLines(t, path)
+	if len(lines) != 3 {
+		t.Error("wrong file events count", len(lines))
+	}
+}
+
+func TestRelayStuckSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	store := &memOutbox{}
+	for i := 1; i <= 3; i++ {
+		store.events = append(store.events, models.Event{ID: int64(i), Type: models.EventUserRegistered, Created: time.Now()})
+	}
+
+	relay := Relay{
+		Store:     store,
+		Sinks:     []Sink{stuckSink{}, &FileSink{Path: path}},
+		Interval:  10 * time.Millisecond,
+		BatchSize: 2,
+	}
+	relay.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && store.deliveredTo("file") < 3 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	relay.Stop()
+
+	if got := len(readLines(t, path)); got != 3 {
+		t.Error("file sink held up by the stuck one, events", got)
+	}
+	if store.pending() != 3 {
+		t.Error("events the stuck sink didn't take are marked delivered")
+	}
+}
+
+func readLines(t *testing.T, path string) []models.Event {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var res []models.Event
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var ev models.Event
+		if err = json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatal("wrong ndjson line:", err)
+		}
+		res = append(res, ev)
+	}
+	return res
+}
+
+type memOutbox struct {
+	mu        sync.Mutex
+	events    []models.Event
+	leased    map[string]map[int64]time.Time
+	delivered map[string]map[int64]bool
+	done      map[int64]bool
+}
+
+func (m *memOutbox) pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.events) - len(m.done)
+}
+
+func (m *memOutbox) deliveredTo(sink string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.delivered[sink])
+}
+
+func (m *memOutbox) ClaimEvents(ctx context.Context, sink string, limit int, lease time.Duration) ([]models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leased == nil {
+		m.leased = map[string]map[int64]time.Time{}
+	}
+	if m.leased[sink] == nil {
+		m.leased[sink] = map[int64]time.Time{}
+	}
+
+	now := time.Now()
+	var res []models.Event
+	for _, ev := range m.events {
+		if len(res) == limit {
+			break
+		}
+		if m.done[ev.ID] || m.delivered[sink][ev.ID] || m.leased[sink][ev.ID].After(now) {
+			continue
+		}
+		m.leased[sink][ev.ID] = now.Add(lease)
+		res = append(res, ev)
+	}
+	return res, nil
+}
+
+func (m *memOutbox) MarkDelivered(ctx context.Context, sink string, ids []int64, sinks []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.delivered == nil {
+		m.delivered = map[string]map[int64]bool{}
+	}
+	if m.delivered[sink] == nil {
+		m.delivered[sink] = map[int64]bool{}
+	}
+	if m.done == nil {
+		m.done = map[int64]bool{}
+	}
+	for _, id := range ids {
+		m.delivered[sink][id] = true
+		all := true
+		for _, s := range sinks {
+			all = all && m.delivered[s][id]
+		}
+		if all {
+			m.done[id] = true
+		}
+	}
+	return nil
+}
+
+func (m *memOutbox) PurgeEvents(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// stuckSink never returns a batch until the relay stops.
+type stuckSink struct{}
+
+func (s stuckSink) Name() string {
+	return "stuck"
+}
+
+func (s stuckSink) Deliver(ctx context.Context, events []models.Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
@@ -0,0 +1,130 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/signature"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+// WriterSink writes events as NDJSON, one event per line.
+type WriterSink struct {
+	W  io.Writer
+	mu sync.Mutex
+}
+
+func NewStdoutSink() *WriterSink {
+	return &WriterSink{W: os.Stdout}
+}
+
+func (s *WriterSink) Name() string {
+	return "stdout"
+}
+
+func (s *WriterSink) Deliver(ctx context.Context, events []models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return utils.ErrorHelper(writeNDJSON(s.W, events))
+}
+
+type FileSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+func (s *FileSink) Deliver(ctx context.Context, events []models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+
+	err = writeNDJSON(f, events)
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return utils.ErrorHelper(err)
+}
+
+func writeNDJSON(w io.Writer, events []models.Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WebhookSink posts each batch as {"delivery_id": ..., "events": [...]}
+// signed with Secret, see the signature package for the headers. Every
+// attempt gets a new delivery id, so a retry is not mistaken for a replay.
+type WebhookSink struct {
+	URL    string
+	Secret []byte
+	Client *http.Client
+}
+
+type webhookBody struct {
+	Delivery string         `json:"delivery_id"`
+	Events   []models.Event `json:"events"`
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Deliver(ctx context.Context, events []models.Event) error {
+	body, err := json.Marshal(webhookBody{Delivery: uuid.NewString(), Events: events})
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signature.HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(signature.HeaderSignature, signature.Sign(s.Secret, now, body))
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return utils.ErrorHelper(fmt.Errorf("webhook answered %d", resp.StatusCode))
+	}
+	return nil
+}
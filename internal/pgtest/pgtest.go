@@ -0,0 +1,159 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// EnvDatabaseURI points the tests to an existing server instead of a
+// throwaway one; every Start call still gets its own database.
+const EnvDatabaseURI = "GOPHERMART_TEST_DATABASE_URI"
+
+// EnvPgBin is a directory with initdb and pg_ctl.
+const EnvPgBin = "PG_BIN"
+
+// Start returns a DSN of an empty database that lives until the test ends.
+// The test is skipped when neither an existing server nor PostgreSQL
+// binaries are available.
+func Start(t testing.TB) string {
+	t.Helper()
+
+	if dsn := os.Getenv(EnvDatabaseURI); dsn != "" {
+		return createDatabase(t, dsn)
+	}
+
+	bin, ok := findBin()
+	if !ok {
+		t.Skip("postgres binaries not found, set " + EnvPgBin + " or " + EnvDatabaseURI)
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("initdb can't run as root, set " + EnvDatabaseURI)
+	}
+
+	return createDatabase(t, startCluster(t, bin))
+}
+
+func findBin() (string, bool) {
+	var dirs []string
+	if dir := os.Getenv(EnvPgBin); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if path, err := exec.LookPath("initdb"); err == nil {
+		dirs = append(dirs, filepath.Dir(path))
+	}
+	installed, _ := filepath.Glob("/usr/lib/postgresql/*/bin")
+	sort.Sort(sort.Reverse(sort.StringSlice(installed)))
+	dirs = append(dirs, installed...)
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "initdb")); err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, "pg_ctl")); err != nil {
+			continue
+		}
+		return dir, true
+	}
+	return "", false
+}
+
+func startCluster(t testing.TB, bin string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+
+	out, err := exec.Command(filepath.Join(bin, "initdb"),
+		"-D", data, "-U", "postgres", "--auth=trust", "--no-sync").CombinedOutput()
+	if err != nil {
+		t.Fatalf("initdb: %v\n%s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off", port, dir)
+	out, err = exec.Command(filepath.Join(bin, "pg_ctl"),
+		"-D", data, "-o", opts, "-l", filepath.Join(dir, "postgres.log"), "-w", "start").CombinedOutput()
+	if err != nil {
+		t.Fatalf("pg_ctl start: %v\n%s", err, out)
+	}
+
+	t.Cleanup(func() {
+		_ = exec.Command(filepath.Join(bin, "pg_ctl"), "-D", data, "-m", "immediate", "stop").Run()
+	})
+
+	return "postgres://postgres@127.0.0.1:" + strconv.Itoa(port) + "/postgres?sslmode=disable"
+}
+
+func createDatabase(t testing.TB, dsn string) string {
+	t.Helper()
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("wrong dsn %q: %v", dsn, err)
+	}
+
+	name := "gophermart_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if err = waitExec(dsn, "create database "+name); err != nil {
+		t.Fatalf("create database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = waitExec(dsn, "drop database if exists "+name+" with (force)")
+	})
+
+	u.Path = "/" + name
+	return u.String()
+}
+
+// waitExec runs query on a connection of its own, retrying for 5 seconds
+// while the server starts.
+func waitExec(dsn, query string) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = execOnce(dsn, query); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+func execOnce(dsn, query string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, query)
+	return err
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
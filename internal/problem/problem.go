@@ -0,0 +1,89 @@
+// Package problem writes error responses as RFC 7807 problem details.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const ContentType = "application/problem+json"
+
+// Machine readable error codes, stable across releases.
+const (
+	CodeInvalidBody          = "invalid_body"
+	CodeInvalidQuery         = "invalid_query"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeTooLarge             = "too_large"
+	CodeValidation           = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeInvalidSignature     = "invalid_signature"
+	CodeReplay               = "replayed_request"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeLoginTaken           = "login_taken"
+	CodeInvalidOrderNumber   = "invalid_order_number"
+	CodeOrderConflict        = "order_conflict"
+	CodeInsufficientFunds    = "insufficient_funds"
+	CodeForbidden            = "forbidden"
+	CodeRateLimited          = "rate_limited"
+	CodeMerchantExists       = "merchant_exists"
+	CodeAlreadyReversed      = "already_reversed"
+	CodeUnknownUser          = "unknown_user"
+	CodeInternal             = "internal_error"
+)
+
+// Problem is the error envelope. Type is always about:blank, so Title is the
+// status text and Code tells errors with the same status apart.
+type Problem struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+	Instance  string      `json:"instance,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// Write responds with a problem without details.
+func Write(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	WriteDetails(w, r, status, code, message, nil)
+}
+
+// WriteDetails responds with a problem, details are any JSON value that
+// helps the client to fix the request.
+func WriteDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details interface{}) {
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		Instance:  r.URL.Path,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// Internal responds with 500 without revealing the cause.
+func Internal(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, http.StatusInternalServerError, CodeInternal, "")
+}
+
+// NotFound and MethodNotAllowed replace the router's plain text answers.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, http.StatusNotFound, CodeNotFound, "")
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+}
@@ -4,27 +4,67 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/e-faizov/gophermart/internal/metrics"
 	"github.com/e-faizov/gophermart/internal/models"
 	"github.com/e-faizov/gophermart/internal/storage"
+	"github.com/e-faizov/gophermart/internal/tracing"
 	"github.com/e-faizov/gophermart/internal/utils"
 )
 
 type Scores struct {
 	URL string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+func (s *Scores) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+	return s.Client
 }
 
 func (s *Scores) GetScore(ctx context.Context, order string) (new models.Order, toManyReq bool, err error) {
-	resp, err := http.Get(s.URL + "/api/orders/" + order)
+	ctx, span := tracing.Tracer().Start(ctx, "scores.GetScore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.AttrOrder.String(order)),
+	)
+	defer func() {
+		if new.Status != "" {
+			span.SetAttributes(tracing.AttrStatus.String(new.Status))
+		}
+		tracing.End(span, err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/api/orders/"+order, nil)
 	if err != nil {
 		return models.Order{}, false, utils.ErrorHelper(err)
 	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	resp, err := s.client().Do(req)
+	if err != nil {
+		metrics.AccrualDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		return models.Order{}, false, utils.ErrorHelper(err)
+	}
 	defer resp.Body.Close()
+	metrics.AccrualDuration.WithLabelValues(strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
 
 	if resp.StatusCode == http.StatusTooManyRequests {
+		metrics.AccrualRateLimited.Inc()
 		return models.Order{}, true, nil
 	}
 
@@ -33,21 +73,40 @@ func (s *Scores) GetScore(ctx context.Context, order string) (new models.Order,
 		return models.Order{}, false, utils.ErrorHelper(err)
 	}
 
-	var scores models.Scores
-
-	fmt.Println(string(body))
+	log.Ctx(ctx).Debug().Str("order", order).Bytes("body", body).Msg("accrual response")
 
+	var scores models.Scores
 	err = json.Unmarshal(body, &scores)
 	if err != nil {
 		return models.Order{}, false, utils.ErrorHelper(err)
 	}
 
+	res, err := ToOrder(order, scores)
+	return res, false, err
+}
+
+// Ping checks that the accrual system answers HTTP at all, any status
+// will do.
+func (s *Scores) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/api/orders/0", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func ToOrder(order string, scores models.Scores) (models.Order, error) {
 	switch scores.Status {
 	case "REGISTERED", "PROCESSING":
 		return models.Order{
 			Number: order,
 			Status: storage.OtProcessing,
-		}, false, nil
+		}, nil
 	case "PROCESSED":
 		var acc float64
 		if scores.Accrual != nil {
@@ -57,13 +116,13 @@ func (s *Scores) GetScore(ctx context.Context, order string) (new models.Order,
 			Number:  order,
 			Status:  storage.OtProcessed,
 			Accrual: &acc,
-		}, false, nil
+		}, nil
 	case "INVALID":
 		return models.Order{
 			Number: order,
 			Status: storage.OtInvalid,
-		}, false, nil
+		}, nil
 	}
 
-	return models.Order{}, false, errors.New("error")
+	return models.Order{}, utils.ErrorHelper(errors.New("unknown accrual status: " + scores.Status))
 }
@@ -0,0 +1,66 @@
+package scores
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/e-faizov/gophermart/internal/storage"
+	"github.com/e-faizov/gophermart/internal/tracing"
+)
+
+func TestGetScorePropagatesTrace(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var got trace.SpanContext
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		got = trace.SpanContextFromContext(ctx)
+		_, _ = w.Write([]byte(`{"order": "12345678903", "status": "INVALID"}`))
+	}))
+	defer srv.Close()
+
+	ctx, parent := tracing.Start(context.Background(), "test")
+	s := Scores{URL: srv.URL}
+	order, _, err := s.GetScore(ctx, "12345678903")
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != storage.OtInvalid {
+		t.Errorf("wrong status %s", order.Status)
+	}
+
+	if got.TraceID() != parent.SpanContext().TraceID() {
+		t.Error("traceparent isn't sent to the accrual system")
+	}
+
+	var client sdktrace.ReadOnlySpan
+	for _, span := range rec.Ended() {
+		if span.Name() == "scores.GetScore" {
+			client = span
+		}
+	}
+	if client == nil {
+		t.Fatal("no scores.GetScore span")
+	}
+	if got.SpanID() != client.SpanContext().SpanID() {
+		t.Error("accrual request isn't a child of the client span")
+	}
+	attrs := map[string]string{}
+	for _, kv := range client.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs[string(tracing.AttrOrder)] != "12345678903" || attrs[string(tracing.AttrStatus)] != storage.OtInvalid {
+		t.Errorf("wrong attributes %v", attrs)
+	}
+}
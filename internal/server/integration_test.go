@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/accrualmock"
+	"github.com/e-faizov/gophermart/internal/config"
+	"github.com/e-faizov/gophermart/internal/health"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/pgtest"
+	"github.com/e-faizov/gophermart/internal/storage"
+)
+
+func TestGophermartFlow(t *testing.T) {
+	dsn := pgtest.Start(t)
+
+	accrual := accrualmock.New()
+	accrual.AddGoods(accrualmock.Goods{Match: "Bork", Reward: 10, RewardType: accrualmock.RewardPercent})
+	accrual.RegisterOrder(accrualmock.Order{
+		Order: "12345678903",
+		Goods: []accrualmock.Item{{Description: "Чайник Bork", Price: 7000}},
+	})
+	accrualSrv := httptest.NewServer(accrual)
+	defer accrualSrv.Close()
+
+	addr := freeAddr(t)
+	cfg := config.Default()
+	cfg.HTTP.RunAddress = addr
+	cfg.DB.URI = dsn
+	// the same database stands in for the replica, the reads go through
+	// the second pool
+	cfg.DB.ReplicaURI = dsn
+	cfg.Accrual.Address = accrualSrv.URL
+	cfg.Auth.JWTSecret = "jwt secret"
+	cfg.Auth.PasswordSecret = "password secret"
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, cfg, nil)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error("server stopped with error:", err)
+		}
+	}()
+
+	c := newClient(t, "http://"+addr, done)
+
+	var ready health.Report
+	c.expect(http.MethodGet, "/readyz", "", "", http.StatusOK, &ready)
+	if ready.Status != health.StatusOK {
+		t.Fatal("not ready:", ready)
+	}
+
+	c.expect(http.MethodPost, "/api/user/register", "application/json",
+		`{"login":"gopher","password":"secret"}`, http.StatusOK, nil)
+	c.expect(http.MethodPost, "/api/user/orders", "text/plain", "12345678903", http.StatusAccepted, nil)
+	c.expect(http.MethodPost, "/api/user/orders", "text/plain", "12345678903", http.StatusOK, nil)
+
+	var orders []models.Order
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		c.expect(http.MethodGet, "/api/user/orders", "", "", http.StatusOK, &orders)
+		if len(orders) == 1 && orders[0].Status == storage.OtProcessed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("order not processed in time:", orders)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if orders[0].Accrual == nil || *orders[0].Accrual != 700 {
+		t.Fatal("wrong accrual:", orders[0].Accrual)
+	}
+
+	c.expect(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":751}`, http.StatusPaymentRequired, nil)
+	c.expect(http.MethodPost, "/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":250.5}`, http.StatusOK, nil)
+
+	var balance models.Balance
+	c.expect(http.MethodGet, "/api/user/balance", "", "", http.StatusOK, &balance)
+	if balance.Current != 449.5 || balance.Withdrawn != 250.5 {
+		t.Fatal("wrong balance:", balance)
+	}
+
+	var withdrawals []models.Withdraw
+	c.expect(http.MethodGet, "/api/user/withdrawals", "", "", http.StatusOK, &withdrawals)
+	if len(withdrawals) != 1 || withdrawals[0].Order != "2377225624" || withdrawals[0].Sum != 250.5 {
+		t.Fatal("wrong withdrawals:", withdrawals)
+	}
+
+	c.expect(http.MethodPost, "/api/user/logout", "", "", http.StatusOK, nil)
+	c.expect(http.MethodGet, "/api/user/balance", "", "", http.StatusUnauthorized, nil)
+}
+
+type client struct {
+	t    *testing.T
+	base string
+	http *http.Client
+}
+
+func newClient(t *testing.T, base string, done chan error) *client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &client{t: t, base: base, http: &http.Client{Jar: jar, Timeout: 5 * time.Second}}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		select {
+		case err = <-done:
+			t.Fatal("server stopped:", err)
+		default:
+		}
+		resp, err := c.http.Get(base + "/api/user/balance")
+		if err == nil {
+			resp.Body.Close()
+			return c
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server not started:", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (c *client) expect(method, path, contentType, body string, code int, res interface{}) {
+	c.t.Helper()
+
+	req, err := http.NewRequest(method, c.base+path, bytes.NewBufferString(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != code {
+		c.t.Fatalf("%s %s: code %d, want %d", method, path, resp.StatusCode, code)
+	}
+	if res != nil {
+		if err = json.NewDecoder(resp.Body).Decode(res); err != nil {
+			c.t.Fatalf("%s %s: wrong body: %v", method, path, err)
+		}
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/config"
+	"github.com/e-faizov/gophermart/internal/logging"
+	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/updater"
+)
+
+// reloader rereads the configuration and applies its runtime-safe part to
+// the running server, the other changes wait for a restart.
+type reloader struct {
+	load    config.Loader
+	build   func(cfg config.GopherMartCfg) (http.Handler, error)
+	handler *handlerSwitch
+	updater *updater.OrderUpdater
+	limiter *middlewares.MerchantLimiter
+
+	mu  sync.Mutex
+	cfg config.GopherMartCfg
+}
+
+// Reload applies nothing when the configuration fails to load or validate.
+// Otherwise the new router is built first, so the log level, the updater
+// timing, the partner rate limit and the router change together or not at
+// all. The stateful parts the router uses are kept and only retuned.
+func (rl *reloader) Reload(ctx context.Context) (models.ReloadResult, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	next, err := rl.load()
+	if err != nil {
+		return models.ReloadResult{}, err
+	}
+	if err = next.Validate(); err != nil {
+		return models.ReloadResult{}, err
+	}
+
+	cfg, applied, ignored := config.Reload(rl.cfg, next)
+	res := models.ReloadResult{Applied: applied, Ignored: ignored}
+	if res.Applied == nil {
+		res.Applied = []models.ConfigChange{}
+	}
+	if res.Ignored == nil {
+		res.Ignored = []string{}
+	}
+
+	logger := log.Ctx(ctx)
+	if len(ignored) > 0 {
+		logger.Warn().Strs("keys", ignored).Msg("config changes need a restart")
+	}
+	if len(applied) == 0 {
+		logger.Info().Msg("config reloaded without changes")
+		return res, nil
+	}
+
+	h, err := rl.build(cfg)
+	if err != nil {
+		return models.ReloadResult{}, err
+	}
+	if err = logging.SetLevel(cfg.Log.Level, cfg.Log.Sample); err != nil {
+		return models.ReloadResult{}, err
+	}
+	rl.updater.SetTiming(updaterTiming(cfg))
+	rl.limiter.SetRate(cfg.Partner.RateLimit, cfg.Partner.RateBurst)
+	rl.handler.Store(h)
+	rl.cfg = cfg
+
+	for _, c := range applied {
+		logger.Info().Str("key", c.Key).Str("old", c.Old).Str("new", c.New).Msg("config change applied")
+	}
+	return res, nil
+}
+
+// watchHangup reloads the configuration on SIGHUP until stop is called.
+func (rl *reloader) watchHangup() (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+				log.Info().Msg("SIGHUP, reload config")
+				if _, err := rl.Reload(context.Background()); err != nil {
+					log.Error().Err(err).Msg("error reload config, keep the running one")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(hup)
+		close(done)
+	}
+}
+
+// handlerSwitch serves with the latest router, requests in flight finish
+// on the one they started with.
+type handlerSwitch struct {
+	h atomic.Pointer[http.Handler]
+}
+
+func (s *handlerSwitch) Store(h http.Handler) {
+	s.h.Store(&h)
+}
+
+func (s *handlerSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.h.Load()).ServeHTTP(w, r)
+}
+
+// updaterTiming picks the updater intervals, with push updates enabled
+// polling is only a reconciliation sweep.
+func updaterTiming(cfg config.GopherMartCfg) updater.Timing {
+	t := updater.Timing{
+		Interval:         cfg.Updater.PollInterval,
+		ErrorBackoff:     cfg.Updater.ErrorBackoff,
+		RateLimitBackoff: cfg.Updater.RateLimitBackoff,
+		StaleAfter:       cfg.Updater.StaleAfter,
+		Concurrency:      cfg.Updater.Concurrency,
+	}
+	if cfg.Accrual.CallbackSecret != "" {
+		t.Interval = cfg.Updater.ReconcileInterval
+	}
+	return t
+}
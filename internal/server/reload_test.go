@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/e-faizov/gophermart/internal/config"
+	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/updater"
+)
+
+func TestReloader(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+
+	cur := config.Default()
+	cur.Auth.JWTSecret = "jwt secret"
+	cur.Auth.PasswordSecret = "password secret"
+	cur.Accrual.Address = "http://localhost:8080"
+	cur.DB.URI = "postgres://localhost/gophermart"
+	next := cur
+	var loadErr error
+
+	var handler handlerSwitch
+	handler.Store(http.NotFoundHandler())
+	var upd updater.OrderUpdater
+	limiter := &middlewares.MerchantLimiter{Rate: cur.Partner.RateLimit, Burst: cur.Partner.RateBurst}
+
+	var built config.GopherMartCfg
+	rl := &reloader{
+		load: func() (config.GopherMartCfg, error) { return next, loadErr },
+		build: func(cfg config.GopherMartCfg) (http.Handler, error) {
+			built = cfg
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}), nil
+		},
+		handler: &handler,
+		updater: &upd,
+		limiter: limiter,
+		cfg:     cur,
+	}
+
+	serve := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	next.DB.URI = "postgres://localhost/other"
+	next.HTTP.OrdersBatchLimit = 10
+	next.Log.Level = "warn"
+	next.Updater.PollInterval = 3 * time.Second
+	next.HTTP.RunAddress = "localhost:9090"
+	next.Updater.Concurrency = 4
+	next.Partner.RateLimit = 50
+
+	res, err := rl.Reload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Applied) != 5 {
+		t.Errorf("wrong applied %+v", res.Applied)
+	}
+	if len(res.Ignored) != 2 || res.Ignored[0] != "http.address" || res.Ignored[1] != "db.uri" {
+		t.Errorf("wrong ignored %v", res.Ignored)
+	}
+	if serve() != http.StatusTeapot || built.HTTP.OrdersBatchLimit != 10 || built.HTTP.RunAddress != cur.HTTP.RunAddress {
+		t.Error("router is not rebuilt with the reloaded config")
+	}
+	if zerolog.GlobalLevel() != zerolog.WarnLevel {
+		t.Errorf("wrong log level %s", zerolog.GlobalLevel())
+	}
+	if limiter.Rate != 50 {
+		t.Errorf("partner limiter is not retuned, rate %g", limiter.Rate)
+	}
+
+	next.Log.Level = "loud"
+	if _, err = rl.Reload(context.Background()); err == nil {
+		t.Error("no error for an invalid config")
+	}
+	loadErr = errors.New("broken file")
+	if _, err = rl.Reload(context.Background()); !errors.Is(err, loadErr) {
+		t.Errorf("wrong error %v", err)
+	}
+	if rl.cfg.Log.Level != "warn" {
+		t.Errorf("failed reload changed the config %+v", rl.cfg.Log)
+	}
+}
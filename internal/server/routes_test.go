@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/e-faizov/gophermart/internal/config"
+	"github.com/e-faizov/gophermart/internal/events"
+	"github.com/e-faizov/gophermart/internal/health"
+	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/openapi"
+)
+
+// TestRoutesMatchSpec fails when a /api/user route is added or removed
+// without updating the OpenAPI document.
+func TestRoutesMatchSpec(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specified := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			specified[method+" "+path] = true
+		}
+	}
+
+	r := newRouter(config.Default(), nil, routerDeps{
+		hub:     &events.Hub{},
+		checker: &health.Checker{},
+		limiter: &middlewares.MerchantLimiter{},
+	})
+	routed := map[string]bool{}
+	err = chi.Walk(r, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		route = strings.TrimSuffix(strings.ReplaceAll(route, "/*/", "/"), "/")
+		if strings.HasPrefix(route, "/api/user/") {
+			routed[method+" "+route] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(routed) == 0 {
+		t.Fatal("no /api/user routes")
+	}
+	for _, route := range diff(routed, specified) {
+		t.Error("route missing from the spec:", route)
+	}
+	for _, route := range diff(specified, routed) {
+		t.Error("spec operation without a route:", route)
+	}
+}
+
+func diff(a, b map[string]bool) []string {
+	var res []string
+	for k := range a {
+		if !b[k] {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -1,73 +1,401 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/jwtauth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
 
 	"github.com/e-faizov/gophermart/internal/config"
+	"github.com/e-faizov/gophermart/internal/events"
 	"github.com/e-faizov/gophermart/internal/handlers"
+	"github.com/e-faizov/gophermart/internal/health"
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/metrics"
 	"github.com/e-faizov/gophermart/internal/middlewares"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/openapi"
+	"github.com/e-faizov/gophermart/internal/outbox"
+	"github.com/e-faizov/gophermart/internal/problem"
 	"github.com/e-faizov/gophermart/internal/scores"
+	"github.com/e-faizov/gophermart/internal/signature"
 	"github.com/e-faizov/gophermart/internal/storage"
+	"github.com/e-faizov/gophermart/internal/tracing"
 	"github.com/e-faizov/gophermart/internal/updater"
+	"github.com/e-faizov/gophermart/internal/webhooks"
 )
 
-var tokenAuth *jwtauth.JWTAuth
+// StartServer serves until ctx is done, main cancels it on SIGTERM and
+// SIGINT.
+func StartServer(ctx context.Context, cfg config.GopherMartCfg, load config.Loader) error {
+	return Run(ctx, cfg, load)
+}
+
+// Run serves until ctx is done. With load set SIGHUP and, when the admin
+// API is on, POST /api/admin/reload apply the runtime-safe settings load
+// returns.
+func Run(ctx context.Context, cfg config.GopherMartCfg, load config.Loader) error {
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Trace.Exporter, cfg.Trace.File)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Error().Err(err).Msg("error flush spans")
+		}
+	}()
+
+	db, err := storage.NewPgStore(cfg.DB.URI, cfg.Auth.PasswordSecret, storage.Options{
+		MaxConns:         cfg.DB.MaxOpenConns,
+		MinConns:         cfg.DB.MinConns,
+		MaxConnLifetime:  cfg.DB.ConnMaxLifetime,
+		MaxConnIdleTime:  cfg.DB.ConnMaxIdleTime,
+		StatementTimeout: cfg.DB.StatementTimeout,
+		ReplicaConn:      cfg.DB.ReplicaURI,
+		QueryExecMode:    cfg.DB.QueryExecMode,
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	dbCollectors := []prometheus.Collector{
+		metrics.NewPoolCollector(db.Pool(), "gophermart"),
+		&metrics.QueueCollector{Store: db},
+	}
+	if db.Replica() != nil {
+		dbCollectors = append(dbCollectors, metrics.NewPoolCollector(db.Replica(), "gophermart_replica"))
+	}
+	for _, c := range dbCollectors {
+		if err = metrics.Registry.Register(c); err != nil {
+			return err
+		}
+		defer metrics.Registry.Unregister(c)
+	}
+
+	hub := events.Hub{
+		Store: db,
+	}
+	hub.Start()
+	defer hub.Stop()
+
+	scoresServ := scores.Scores{
+		URL:    cfg.Accrual.Address,
+		Client: &http.Client{Timeout: cfg.Accrual.Timeout},
+	}
+
+	timing := updaterTiming(cfg)
+	orderUpdater := updater.OrderUpdater{
+		Store:    db,
+		Scores:   &scoresServ,
+		Interval: timing.Interval,
+
+		ErrorBackoff:     timing.ErrorBackoff,
+		RateLimitBackoff: timing.RateLimitBackoff,
+		StaleAfter:       timing.StaleAfter,
+		Concurrency:      timing.Concurrency,
+	}
+
+	orderUpdater.Start()
+	defer orderUpdater.Stop()
+
+	relay := outbox.Relay{
+		Store: db,
+		Sinks: append(outboxSinks(cfg), &webhooks.Sink{Store: db}),
+	}
+	relay.Start()
+	defer relay.Stop()
+
+	webhookDispatcher := webhooks.Dispatcher{
+		Store: db,
+	}
+	webhookDispatcher.Start()
+	defer webhookDispatcher.Stop()
+
+	checker := health.Checker{
+		Checks: []health.Check{
+			{Name: "postgres", Critical: true, Run: db.Ping},
+			{Name: "migrations", Critical: true, Run: db.CheckMigrations},
+			{Name: "updater", Critical: true, Run: orderUpdater.Healthy},
+			{Name: "accrual", Run: scoresServ.Ping},
+		},
+	}
+	if db.Replica() != nil {
+		checker.Checks = append(checker.Checks, health.Check{Name: "postgres_replica", Critical: true, Run: db.PingReplica})
+	}
+
+	var verifier *signature.Verifier
+	if cfg.Accrual.CallbackSecret != "" {
+		verifier = &signature.Verifier{
+			Secret: []byte(cfg.Accrual.CallbackSecret),
+		}
+	}
+
+	// the limiter buckets outlive the routers built on reload
+	partnerLimiter := &middlewares.MerchantLimiter{
+		Rate:  cfg.Partner.RateLimit,
+		Burst: cfg.Partner.RateBurst,
+	}
+
+	var (
+		handler handlerSwitch
+		rl      *reloader
+	)
+	build := func(cfg config.GopherMartCfg) (http.Handler, error) {
+		validator, err := newValidator(cfg)
+		if err != nil {
+			return nil, err
+		}
+		deps := routerDeps{
+			db:       db,
+			hub:      &hub,
+			checker:  &checker,
+			verifier: verifier,
+			limiter:  partnerLimiter,
+		}
+		if rl != nil {
+			deps.reloader = rl
+		}
+		return newRouter(cfg, validator, deps), nil
+	}
+	if load != nil {
+		rl = &reloader{
+			load:    load,
+			build:   build,
+			handler: &handler,
+			updater: &orderUpdater,
+			limiter: partnerLimiter,
+			cfg:     cfg,
+		}
+		defer rl.watchHangup()()
+	}
+
+	h, err := build(cfg)
+	if err != nil {
+		return err
+	}
+	handler.Store(h)
+
+	srv := &http.Server{
+		Addr:    cfg.HTTP.RunAddress,
+		Handler: &handler,
+	}
+	srv.RegisterOnShutdown(hub.Stop)
+
+	return serve(ctx, srv, &checker, cfg.HTTP.ShutdownDelay, cfg.HTTP.ShutdownTimeout)
+}
+
+// serve runs srv until ctx is done. Readiness fails first and the listener
+// stays open for delay, so balancers stop routing here before it closes;
+// the requests in flight then have timeout to finish.
+func serve(ctx context.Context, srv *http.Server, checker *health.Checker, delay, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
 
-const secret = "secret"
+	log.Info().Msg("shutting down")
+	checker.ShutDown()
+	time.Sleep(delay)
 
-func StartServer(cfg config.GopherMartCfg) error {
-	db, err := storage.NewPgStore(cfg.DatabaseURI, secret)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newValidator is nil when the OpenAPI checks are off.
+func newValidator(cfg config.GopherMartCfg) (*openapi.Validator, error) {
+	if !cfg.HTTP.OpenAPIValidate {
+		return nil, nil
+	}
+	validator, err := openapi.NewValidator()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	validator.MaxBody = cfg.HTTP.BodyLimit
+	if cfg.HTTP.OrdersBatchBodyLimit > validator.MaxBody {
+		validator.MaxBody = cfg.HTTP.OrdersBatchBodyLimit
 	}
+	return validator, nil
+}
+
+// routerDeps are the parts of the server that keep their state across
+// reloads, every router built is wired to the same ones.
+type routerDeps struct {
+	db       *storage.PgStore
+	hub      *events.Hub
+	checker  *health.Checker
+	verifier *signature.Verifier
+	limiter  *middlewares.MerchantLimiter
+	reloader interfaces.Reloader
+}
 
-	tokenAuth = jwtauth.New("HS256", []byte("secret"), nil)
+// newRouter wires the handlers, a nil validator turns the OpenAPI checks
+// off, a nil verifier the accrual callback and a nil reloader the reload
+// endpoint.
+func newRouter(cfg config.GopherMartCfg, validator *openapi.Validator, deps routerDeps) *chi.Mux {
+	db, hub, checker := deps.db, deps.hub, deps.checker
+	tokenAuth := jwtauth.New("HS256", []byte(cfg.Auth.JWTSecret), nil)
 
 	userHandlers := handlers.User{
 		Store:     db,
 		TokenAuth: tokenAuth,
+		CookieTTL: cfg.Auth.CookieTTL,
 	}
 
 	ordersHandler := handlers.Orders{
-		Store: db,
+		Store:      db,
+		BatchLimit: cfg.HTTP.OrdersBatchLimit,
 	}
 
 	balancesHandler := handlers.Balances{
 		Store: db,
 	}
 
-	scoresServ := scores.Scores{
-		URL: cfg.AccrualSystemAddress,
+	statementsHandler := handlers.Statements{
+		Store: db,
 	}
 
-	orderUpdater := updater.OrderUpdater{
-		Store:  db,
-		Scores: &scoresServ,
+	webhooksHandler := handlers.Webhooks{
+		Store: db,
 	}
 
-	orderUpdater.Start()
-	defer orderUpdater.Stop()
+	eventsHandler := handlers.Events{
+		Store: db,
+		Hub:   hub,
+	}
+
+	linkTokensHandler := handlers.LinkTokens{
+		Store: db,
+		TTL:   cfg.Partner.LinkTokenTTL,
+	}
+
+	partnerHandler := handlers.Partner{
+		Store: db,
+	}
 
 	r := chi.NewRouter()
-	r.Use(middleware.Compress(5))
+	r.Use(middleware.RequestID)
+	r.Use(middlewares.Tracing)
+	r.Use(middlewares.Logger)
+	r.Use(middlewares.Metrics)
+	r.Use(middleware.Compress(cfg.HTTP.CompressLevel))
+	if validator != nil {
+		r.Use(validator.Middleware)
+	}
+	r.NotFound(problem.NotFound)
+	r.MethodNotAllowed(problem.MethodNotAllowed)
+
+	bodyLimit := middlewares.BodyLimit(cfg.HTTP.BodyLimit)
+
+	r.Get("/api/openapi.json", openapi.ServeSpec)
+	r.Method(http.MethodGet, "/metrics", metrics.Handler())
+	r.Get("/healthz", checker.Live)
+	r.Get("/readyz", checker.Ready)
+
+	if deps.verifier != nil {
+		accrualHandler := handlers.Accrual{
+			Store:    db,
+			Verifier: deps.verifier,
+		}
+		r.With(bodyLimit).Post("/api/internal/accrual/callback", accrualHandler.Callback)
+	}
 
 	r.Route("/api/user", func(r chi.Router) {
-		r.Post("/register", userHandlers.Register)
-		r.Post("/login", userHandlers.Login)
+		r.With(bodyLimit).Post("/register", userHandlers.Register)
+		r.With(bodyLimit).Post("/login", userHandlers.Login)
 		r.Post("/logout", userHandlers.Logout)
 
 		ar := r.With(jwtauth.Verifier(tokenAuth), middlewares.Auth)
 
-		ar.Post("/orders", ordersHandler.Post)
+		ar.With(bodyLimit).Post("/orders", ordersHandler.Post)
 		ar.Get("/orders", ordersHandler.Get)
-		ar.Post("/balance/withdraw", balancesHandler.Withdraw)
+		ar.With(middlewares.BodyLimit(cfg.HTTP.OrdersBatchBodyLimit)).Post("/orders/batch", ordersHandler.Batch)
+		ar.With(bodyLimit).Post("/balance/withdraw", balancesHandler.Withdraw)
 		ar.Get("/withdrawals", balancesHandler.Withdrawals)
 		ar.Get("/balance", balancesHandler.Balance)
+		ar.Get("/statement", statementsHandler.Get)
+		ar.Get("/events", eventsHandler.Stream)
+		ar.Post("/link-token", linkTokensHandler.Create)
+
+		ar.Route("/webhooks", func(r chi.Router) {
+			r.With(bodyLimit).Post("/", webhooksHandler.Create)
+			r.Get("/", webhooksHandler.List)
+			r.Get("/{id}", webhooksHandler.Get)
+			r.With(bodyLimit).Put("/{id}", webhooksHandler.Update)
+			r.Delete("/{id}", webhooksHandler.Delete)
+			r.Post("/{id}/ping", webhooksHandler.Ping)
+			r.Get("/{id}/deliveries", webhooksHandler.Deliveries)
+		})
+	})
+
+	// every merchant call past authentication is audited, the rate limited
+	// and forbidden ones too
+	r.Route("/api/partner", func(r chi.Router) {
+		r.Use(middlewares.PartnerAuth(db), middlewares.PartnerAudit(db), deps.limiter.Middleware)
+		r.With(bodyLimit, middlewares.RequireScope(models.ScopeOrdersWrite)).Post("/orders", partnerHandler.AttachOrder)
+		r.With(middlewares.RequireScope(models.ScopeOrdersRead)).Get("/orders/{number}", partnerHandler.Order)
+		r.With(bodyLimit, middlewares.RequireScope(models.ScopeWithdrawalsReverse)).Post("/withdrawals/reversals", partnerHandler.ReverseWithdrawal)
 	})
 
-	return http.ListenAndServe(cfg.RunAddress, r)
+	if cfg.Auth.AdminToken != "" {
+		exportHandler := handlers.Export{
+			Store: db,
+		}
+		merchantsHandler := handlers.Merchants{
+			Store: db,
+		}
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(middlewares.AdminAuth(cfg.Auth.AdminToken))
+			r.Get("/export/orders", exportHandler.Orders)
+			r.Get("/export/withdrawals", exportHandler.Withdrawals)
+			r.With(bodyLimit).Post("/merchants", merchantsHandler.Create)
+			r.Get("/merchants/{id}/audit", merchantsHandler.Audit)
+			if deps.reloader != nil {
+				adminHandler := handlers.Admin{
+					Reloader: deps.reloader,
+				}
+				r.Post("/reload", adminHandler.Reload)
+			}
+		})
+	}
+
+	return r
+}
+
+func outboxSinks(cfg config.GopherMartCfg) []outbox.Sink {
+	var sinks []outbox.Sink
+	if cfg.Outbox.WebhookURL != "" {
+		sinks = append(sinks, &outbox.WebhookSink{
+			URL:    cfg.Outbox.WebhookURL,
+			Secret: []byte(cfg.Outbox.WebhookSecret),
+		})
+	}
+	if cfg.Outbox.File != "" {
+		sinks = append(sinks, &outbox.FileSink{Path: cfg.Outbox.File})
+	}
+	if cfg.Outbox.Stdout {
+		sinks = append(sinks, outbox.NewStdoutSink())
+	}
+	return sinks
 }
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/health"
+)
+
+func TestServeShutdown(t *testing.T) {
+	addr := freeAddr(t)
+	checker := &health.Checker{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", checker.Ready)
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, srv, checker, 500*time.Millisecond, time.Second)
+	}()
+
+	ready := func() int {
+		resp, err := http.Get("http://" + addr + "/readyz")
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && ready() != http.StatusOK {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := ready(); code != http.StatusOK {
+		t.Fatal("not ready before shutdown, code", code)
+	}
+
+	cancel()
+	// the listener stays open for the delay with readiness failing
+	time.Sleep(100 * time.Millisecond)
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Error("readiness during shutdown, code", code, "want 503")
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("serve stopped with error:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve didn't stop")
+	}
+	if code := ready(); code != 0 {
+		t.Error("listener still open after shutdown, code", code)
+	}
+}
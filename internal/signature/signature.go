@@ -0,0 +1,107 @@
+package signature
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+)
+
+const (
+	HeaderTimestamp = "X-Gophermart-Timestamp"
+	HeaderSignature = "X-Gophermart-Signature"
+
+	prefix = "sha256="
+)
+
+var (
+	ErrMalformed = errors.New("malformed signature")
+	ErrMismatch  = errors.New("signature mismatch")
+	ErrExpired   = errors.New("signature expired")
+	ErrReplay    = errors.New("signature already used")
+)
+
+// Sign returns the value of HeaderSignature for body sent at ts: an
+// HMAC-SHA256 of "<unix ts>.<body>".
+func Sign(secret []byte, ts time.Time, body []byte) string {
+	return prefix + hex.EncodeToString(mac(secret, strconv.FormatInt(ts.Unix(), 10), body))
+}
+
+func mac(secret []byte, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Verifier checks signed requests and remembers accepted signatures in
+// Store for the tolerance window so a captured request can't be replayed.
+// A nil Store turns the replay check off.
+type Verifier struct {
+	Secret    []byte
+	Tolerance time.Duration
+	Store     interfaces.SignatureStorage
+}
+
+func (v *Verifier) Verify(ctx context.Context, ts, sig string, body []byte) error {
+	expires, err := v.Check(ts, sig, body)
+	if err != nil {
+		return err
+	}
+	if v.Store == nil {
+		return nil
+	}
+	return Remember(ctx, v.Store, sig, expires)
+}
+
+// Check verifies sig without the replay check. expires is when the
+// signature stops being accepted and may be forgotten.
+func (v *Verifier) Check(ts, sig string, body []byte) (time.Time, error) {
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || !strings.HasPrefix(sig, prefix) {
+		return time.Time{}, ErrMalformed
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, prefix))
+	if err != nil {
+		return time.Time{}, ErrMalformed
+	}
+
+	if !hmac.Equal(got, mac(v.Secret, ts, body)) {
+		return time.Time{}, ErrMismatch
+	}
+
+	now := time.Now()
+	sent := time.Unix(unix, 0)
+	if sent.Before(now.Add(-v.tolerance())) || sent.After(now.Add(v.tolerance())) {
+		return time.Time{}, ErrExpired
+	}
+	return sent.Add(v.tolerance()), nil
+}
+
+// Remember saves a checked signature in store, ErrReplay if it is there
+// already.
+func Remember(ctx context.Context, store interfaces.SignatureStorage, sig string, expires time.Time) error {
+	fresh, err := store.SaveSignature(ctx, sig, expires)
+	if err != nil {
+		return fmt.Errorf("error save signature: %w", err)
+	}
+	if !fresh {
+		return ErrReplay
+	}
+	return nil
+}
+
+func (v *Verifier) tolerance() time.Duration {
+	if v.Tolerance == 0 {
+		return 5 * time.Minute
+	}
+	return v.Tolerance
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+// SaveOrders uploads orders of the user in one transaction. The result maps
+// every number to models.BatchAccepted, models.BatchAlreadyUploaded or
+// models.BatchConflict, ErrUserNotFound reports an unknown user.
+func (p *PgStore) SaveOrders(ctx context.Context, user string, orders []string) (res map[string]string, err error) {
+	ctx, span := tracing.Start(ctx, "PgStore.SaveOrders")
+	defer span.End()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		}
+	}()
+
+	var userID int
+	err = tx.QueryRow(ctx, `select id from users where uuid=$1`, user).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+
+	// The statement snapshot doesn't see rows inserted by the CTE, so the
+	// owner is looked up only for the numbers that were already there.
+	script := `with input as (select distinct unnest($1::text[]) as order_id),
+				ins as (insert into orders (order_id, user_id, uploaded, status)
+					select order_id, $2::int, $3, (select id from order_types where type=$4)
+					from input
+					on conflict (order_id) do nothing
+					returning order_id)
+				select i.order_id, ins.order_id is not null, coalesce(u.uuid, '') from input i
+				left join ins on ins.order_id=i.order_id
+				left join orders o on o.order_id=i.order_id
+				left join users u on u.id=o.user_id`
+	rows, err := tx.Query(ctx, script, orders, userID, time.Now(), OtNew)
+	if err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	res = make(map[string]string, len(orders))
+	var missed []string
+	for rows.Next() {
+		var (
+			order, owner string
+			inserted     bool
+		)
+		err = rows.Scan(&order, &inserted, &owner)
+		if err != nil {
+			return nil, utils.ErrorHelper(err)
+		}
+		switch {
+		case inserted:
+			res[order] = models.BatchAccepted
+		case owner == "":
+			missed = append(missed, order)
+		default:
+			res[order] = ownerResult(owner, user)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+
+	// Orders committed concurrently after the snapshot was taken conflict but
+	// are not visible to it, a new statement sees them.
+	if len(missed) > 0 {
+		script = `select o.order_id, u.uuid from orders o join users u on u.id=o.user_id where o.order_id=any($1)`
+		rows, err = tx.Query(ctx, script, missed)
+		if err != nil {
+			return nil, utils.ErrorHelper(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var order, owner string
+			err = rows.Scan(&order, &owner)
+			if err != nil {
+				return nil, utils.ErrorHelper(err)
+			}
+			res[order] = ownerResult(owner, user)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, utils.ErrorHelper(err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
+
+func ownerResult(owner, user string) string {
+	if owner == user {
+		return models.BatchAlreadyUploaded
+	}
+	return models.BatchConflict
+}
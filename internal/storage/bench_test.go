@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/pgtest"
+)
+
+// newBenchStore opens a store on a fresh database with one registered user
+// that has a withdrawal.
+func newBenchStore(b *testing.B) (*PgStore, string) {
+	b.Helper()
+
+	store, err := NewPgStore(pgtest.Start(b), "secret", Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(store.Close)
+
+	ctx := context.Background()
+	ok, uuid, err := store.Register(ctx, "gopher", "secret")
+	if err != nil || !ok {
+		b.Fatal("register:", ok, err)
+	}
+	_, err = store.db.Exec(ctx, `update balances set balance=100 where user_id=(select id from users where uuid=$1)`, uuid)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err = store.Withdraw(ctx, models.Withdraw{Order: "2377225624", Sum: 10}, uuid); err != nil {
+		b.Fatal(err)
+	}
+	return store, uuid
+}
+
+func BenchmarkBalanceByUser(b *testing.B) {
+	store, uuid := newBenchStore(b)
+	ctx := context.Background()
+
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.BalanceByUser(ctx, uuid); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// the previous implementation, a round trip per query
+	b.Run("two_queries", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var res models.Balance
+			err := store.db.QueryRow(ctx, `select balance from balances where user_id=(select id from users where uuid=$1)`, uuid).
+				Scan(&res.Current)
+			if err != nil {
+				b.Fatal(err)
+			}
+			err = store.db.QueryRow(ctx, `select coalesce(sum(sum), 0) from withdrawals where user_id=(select id from users where uuid=$1) and reversed is null`, uuid).
+				Scan(&res.Withdrawn)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkQueryExecMode(b *testing.B) {
+	store, uuid := newBenchStore(b)
+	ctx := context.Background()
+
+	for name := range queryExecModes {
+		b.Run(name, func(b *testing.B) {
+			cfg, err := poolConfig(store.conn, Options{QueryExecMode: name})
+			if err != nil {
+				b.Fatal(err)
+			}
+			pool, err := pgxpool.NewWithConfig(ctx, cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer pool.Close()
+			s := &PgStore{db: pool, secret: store.secret}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err = s.GetOrders(ctx, uuid, models.OrdersQuery{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEnqueueWebhookEvents(b *testing.B) {
+	store, uuid := newBenchStore(b)
+	ctx := context.Background()
+
+	_, err := store.CreateWebhook(ctx, uuid, models.Webhook{
+		URL:    "https://example.com/hook",
+		Secret: "secret",
+		Events: []string{models.EventOrderStatusChanged},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	events := make([]models.Event, 50)
+	for i := range events {
+		events[i] = models.Event{Type: models.EventOrderStatusChanged, User: uuid, Payload: []byte(`{}`), Created: time.Now()}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := range events {
+			events[j].ID = int64(i*len(events) + j + 1)
+		}
+		b.StartTimer()
+		if err = store.EnqueueWebhookEvents(ctx, events); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+// SaveSignature remembers an accepted callback signature until expires, for
+// every replica and across restarts. fresh is false when sig is remembered
+// already. The signature is remembered only if the transaction commits, a
+// callback failed to apply may be retried. Expired signatures are swept by
+// the same statement.
+func (o *orderUpdateTxImpl) SaveSignature(ctx context.Context, sig string, expires time.Time) (bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.UpdaterTx.SaveSignature")
+	defer span.End()
+
+	sqlString := `with expired as (delete from callback_signatures where expires < $3)
+				insert into callback_signatures (signature, expires) values ($1, $2)
+				on conflict (signature) do nothing`
+	res, err := o.tx.Exec(ctx, sqlString, sig, expires, time.Now())
+	if err != nil {
+		return false, utils.ErrorHelper(err)
+	}
+	return res.RowsAffected() == 1, nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+// EventsAfter reads in commit order. Ids are taken when an event is
+// inserted, not when it commits, so an event with a smaller id can commit
+// after a bigger one has been read. Only the events written by transactions
+// older than every one still running, below the snapshot xmin, are
+// returned: whatever commits later sorts after them.
+func (p *PgStore) EventsAfter(ctx context.Context, uuid string, after models.EventCursor, types []string, limit int) ([]models.Event, bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.EventsAfter")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `select id, xact, type, user_uuid, payload, created,
+					xact >= pg_snapshot_xmin(pg_current_snapshot())::text::bigint
+				from outbox
+				where user_uuid=$1 and (xact, id) > ($2, $3) and type=any($4)
+				order by xact, id
+				limit $5`
+	rows, err := p.db.Query(ctx, sqlString, uuid, after.Xact, after.ID, types, limit)
+	if err != nil {
+		return nil, false, utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	var res []models.Event
+	var held bool
+	for rows.Next() {
+		var ev models.Event
+		var running bool
+		err = rows.Scan(&ev.ID, &ev.Xact, &ev.Type, &ev.User, &ev.Payload, &ev.Created, &running)
+		if err != nil {
+			return nil, false, utils.ErrorHelper(err)
+		}
+		// the rest is ordered after it and held back as well
+		if running {
+			held = true
+			break
+		}
+		res = append(res, ev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, utils.ErrorHelper(err)
+	}
+	return res, held, nil
+}
+
+func (p *PgStore) EventCursor(ctx context.Context) (models.EventCursor, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.EventCursor")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var res models.EventCursor
+	err := p.db.QueryRow(ctx, `select pg_snapshot_xmin(pg_current_snapshot())::text::bigint`).Scan(&res.Xact)
+	return res, utils.ErrorHelper(err)
+}
+
+// ListenEvents calls notify for every event committed to the outbox by any
+// replica until ctx is done. After a reconnect notifications may have been
+// lost, then notify is called with an empty user.
+func (p *PgStore) ListenEvents(ctx context.Context, notify func(id int64, uuid string)) error {
+	// The listener holds a connection of its own, outside the pool.
+	conn, err := p.listen(ctx)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	defer func() { _ = conn.Close(context.Background()) }()
+
+	backoff := time.Second
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("PgStore.ListenEvents listener error")
+			_ = conn.Close(context.Background())
+			conn, backoff = p.relisten(ctx, backoff)
+			if conn == nil {
+				return nil
+			}
+			notify(0, "")
+			continue
+		}
+		backoff = time.Second
+
+		var msg struct {
+			ID   int64  `json:"id"`
+			User string `json:"user"`
+		}
+		if err = json.Unmarshal([]byte(n.Payload), &msg); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("PgStore.ListenEvents wrong notification " + n.Payload)
+			continue
+		}
+		notify(msg.ID, msg.User)
+	}
+}
+
+func (p *PgStore) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, p.conn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Exec(ctx, "listen "+eventsChannel); err != nil {
+		_ = conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
+
+// relisten reconnects with the backoff doubled up to a minute after every
+// failure. It returns a nil connection once ctx is done.
+func (p *PgStore) relisten(ctx context.Context, backoff time.Duration) (*pgx.Conn, time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, backoff
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+
+		conn, err := p.listen(ctx)
+		if err == nil {
+			return conn, backoff
+		}
+		log.Ctx(ctx).Error().Err(err).Msg("PgStore.ListenEvents error reconnect")
+	}
+}
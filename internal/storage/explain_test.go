@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/pgtest"
+)
+
+// hotTables grow with the users, a full scan of them is a bug.
+var hotTables = map[string]bool{
+	"users":       true,
+	"orders":      true,
+	"balances":    true,
+	"withdrawals": true,
+	"adjustments": true,
+}
+
+// queryRecorder keeps every statement the store sends, batched ones too.
+type queryRecorder struct {
+	mu      sync.Mutex
+	queries []recordedQuery
+}
+
+type recordedQuery struct {
+	sql  string
+	args []any
+}
+
+func (r *queryRecorder) add(sql string, args []any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries = append(r.queries, recordedQuery{sql: sql, args: args})
+}
+
+func (r *queryRecorder) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	r.add(data.SQL, data.Args)
+	return ctx
+}
+
+func (r *queryRecorder) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+func (r *queryRecorder) TraceBatchStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+	return ctx
+}
+
+func (r *queryRecorder) TraceBatchQuery(_ context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	r.add(data.SQL, data.Args)
+}
+
+func (r *queryRecorder) TraceBatchEnd(context.Context, *pgx.Conn, pgx.TraceBatchEndData) {}
+
+type planNode struct {
+	NodeType  string     `json:"Node Type"`
+	Relation  string     `json:"Relation Name"`
+	IndexName string     `json:"Index Name"`
+	IndexCond string     `json:"Index Cond"`
+	Plans     []planNode `json:"Plans"`
+}
+
+// fullScans lists the nodes reading a hot table whole: sequential scans and
+// index scans without a condition.
+func fullScans(n planNode) []string {
+	var res []string
+	if hotTables[n.Relation] {
+		switch n.NodeType {
+		case "Seq Scan":
+			res = append(res, n.NodeType+" on "+n.Relation)
+		case "Index Scan", "Index Only Scan":
+			if n.IndexCond == "" {
+				res = append(res, n.NodeType+" without a condition on "+n.Relation)
+			}
+		}
+	}
+	for _, p := range n.Plans {
+		res = append(res, fullScans(p)...)
+	}
+	return res
+}
+
+// usesIndex tells whether any node of the plan scans index.
+func usesIndex(n planNode, index string) bool {
+	if n.IndexName == index {
+		return true
+	}
+	for _, p := range n.Plans {
+		if usesIndex(p, index) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestHotQueriesUseIndexes runs the user facing and updater paths, then
+// explains every statement they sent with sequential scans discouraged. A
+// sequential scan left in a plan means no index serves the query.
+func TestHotQueriesUseIndexes(t *testing.T) {
+	dsn := pgtest.Start(t)
+	store, err := NewPgStore(dsn, "secret", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	rec := &queryRecorder{}
+	cfg, err := poolConfig(dsn, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.Tracer = rec
+	ctx := context.Background()
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	s := &PgStore{db: pool, secret: store.secret}
+
+	ok, uuid, err := s.Register(ctx, "gopher", "secret")
+	if err != nil || !ok {
+		t.Fatal("register:", ok, err)
+	}
+	if _, _, err = s.Login(ctx, "gopher", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.SaveOrder(ctx, uuid, "12345678903"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.SaveOrders(ctx, uuid, []string{"2377225624"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = s.ClaimOrder(ctx, OtNew, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := s.NewUpdaterTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc := float64(100)
+	if err = tx.UpdateOrder(ctx, models.Order{Number: "12345678903", Status: OtProcessed, Accrual: &acc}); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.Withdraw(ctx, models.Withdraw{Order: "79927398713", Sum: 10}, uuid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.BalanceByUser(ctx, uuid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.GetOrders(ctx, uuid, models.OrdersQuery{Limit: 10, Statuses: []string{OtProcessed}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.WithdrawalsByUser(ctx, uuid, models.WithdrawalsQuery{Limit: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Statement(ctx, uuid, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := store.db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Rollback(ctx)
+	if _, err = conn.Exec(ctx, `set local enable_seqscan = off`); err != nil {
+		t.Fatal(err)
+	}
+
+	explained, claims := 0, 0
+	for _, q := range rec.queries {
+		verb := strings.ToLower(strings.Fields(q.sql)[0])
+		switch verb {
+		case "select", "with", "insert", "update", "delete":
+		default:
+			continue
+		}
+
+		var raw []byte
+		if err = conn.QueryRow(ctx, "explain (format json) "+q.sql, q.args...).Scan(&raw); err != nil {
+			t.Fatalf("explain %s: %v", q.sql, err)
+		}
+		var plans []struct {
+			Plan planNode `json:"Plan"`
+		}
+		if err = json.Unmarshal(raw, &plans); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range plans {
+			for _, scan := range fullScans(p.Plan) {
+				t.Errorf("%s in\n%s", scan, q.sql)
+			}
+			// the updater claims orders through the index of version 7
+			if strings.HasPrefix(q.sql, "update orders set checked") {
+				claims++
+				if !usesIndex(p.Plan, "orders_status_checked_index") {
+					t.Errorf("orders_status_checked_index not used in\n%s", q.sql)
+				}
+			}
+		}
+		explained++
+	}
+	if explained == 0 {
+		t.Fatal("no queries recorded")
+	}
+	if claims == 0 {
+		t.Error("no order claims recorded")
+	}
+}
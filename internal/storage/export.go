@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+// ExportOrders passes the orders of all users uploaded in [from, to) to fn
+// row by row as they are read, oldest first. An error from fn stops the export.
+func (p *PgStore) ExportOrders(ctx context.Context, from, to *time.Time, fn func(models.OrderExport) error) error {
+	ctx, span := tracing.Start(ctx, "PgStore.ExportOrders")
+	defer span.End()
+
+	var args queryArgs
+	where := []string{"true"}
+	if from != nil {
+		where = append(where, "t1.uploaded>="+args.add(*from))
+	}
+	if to != nil {
+		where = append(where, "t1.uploaded<"+args.add(*to))
+	}
+
+	script := `select t3.login, t1.order_id, t1.uploaded, t2.type, t1.accrual from orders t1
+				join order_types t2 on t1.status=t2.id
+				join users t3 on t1.user_id=t3.id
+				where ` + strings.Join(where, " and ") + `
+				order by t1.uploaded, t1.order_id`
+	rows, err := p.reader().Query(ctx, script, args...)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var order models.OrderExport
+		err = rows.Scan(&order.Login, &order.Number, &order.Uploaded, &order.Status, &order.Accrual)
+		if err != nil {
+			return utils.ErrorHelper(err)
+		}
+		if err = fn(order); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return utils.ErrorHelper(err)
+	}
+	return nil
+}
+
+// ExportWithdrawals passes the withdrawals of all users made in [from, to)
+// to fn row by row as they are read, oldest first.
+func (p *PgStore) ExportWithdrawals(ctx context.Context, from, to *time.Time, fn func(models.WithdrawExport) error) error {
+	ctx, span := tracing.Start(ctx, "PgStore.ExportWithdrawals")
+	defer span.End()
+
+	var args queryArgs
+	where := []string{"true"}
+	if from != nil {
+		where = append(where, "t1.processed>="+args.add(*from))
+	}
+	if to != nil {
+		where = append(where, "t1.processed<"+args.add(*to))
+	}
+
+	script := `select t2.login, t1.order_id, t1.sum, t1.processed from withdrawals t1
+				join users t2 on t1.user_id=t2.id
+				where ` + strings.Join(where, " and ") + `
+				order by t1.processed, t1.order_id`
+	rows, err := p.reader().Query(ctx, script, args...)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var wd models.WithdrawExport
+		err = rows.Scan(&wd.Login, &wd.Order, &wd.Sum, &wd.Processed)
+		if err != nil {
+			return utils.ErrorHelper(err)
+		}
+		if err = fn(wd); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return utils.ErrorHelper(err)
+	}
+	return nil
+}
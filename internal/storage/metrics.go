@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+// OrdersByStatus counts orders in every status, statuses without orders
+// are reported as zero.
+func (p *PgStore) OrdersByStatus(ctx context.Context) (map[string]int64, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.OrdersByStatus")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	script := `select t2.type, count(t1.order_id) from order_types t2
+				left join orders t1
+				on t1.status=t2.id
+				group by t2.type`
+	rows, err := p.db.Query(ctx, script)
+	if err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	res := map[string]int64{}
+	for rows.Next() {
+		var status string
+		var count int64
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return nil, utils.ErrorHelper(err)
+		}
+		res[status] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
@@ -0,0 +1,420 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+// migrationsLock is the advisory lock key that serializes replicas
+// migrating the same database.
+const migrationsLock = 7_361_934
+
+type migration struct {
+	version int
+	name    string
+	sqls    []string
+	// validate are constraints the sqls add "not valid": they hold for new
+	// rows at once and are validated when the old rows allow it.
+	validate []constraint
+}
+
+type constraint struct {
+	table string
+	name  string
+}
+
+// migrations change tables created by initTables. Append only: a version
+// is never edited once released.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "outbox user index",
+		sqls: []string{
+			`create index if not exists outbox_user_index
+	on outbox (user_uuid, id)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "orders user uploaded index",
+		sqls: []string{
+			`create index if not exists orders_user_uploaded_index
+	on orders (user_id, uploaded)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "withdrawals user processed index",
+		sqls: []string{
+			`create index if not exists withdrawals_user_processed_index
+	on withdrawals (user_id, processed)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "statement entries",
+		sqls: []string{
+			`alter table orders
+	add column if not exists processed timestamp`,
+			`create table if not exists adjustments
+(
+	id      bigserial primary key,
+	user_id int       not null,
+	amount  float8    not null,
+	reason  text      not null,
+	created timestamp not null
+)`,
+			`create index if not exists adjustments_user_created_index
+	on adjustments (user_id, created)`,
+		},
+	},
+	{
+		// Lookups of withdrawals by user go through
+		// withdrawals_user_processed_index from version 3.
+		version: 5,
+		name:    "integrity constraints",
+		sqls: []string{
+			`alter table users
+	alter column uuid set not null,
+	alter column login set not null,
+	alter column hash set not null,
+	drop constraint users_pk`,
+			`alter table users
+	add constraint users_pk
+		primary key (id)`,
+			`alter table order_types
+	alter column type set not null,
+	add constraint order_types_pk
+		primary key (id)`,
+			`create unique index if not exists order_types_type_uindex
+	on order_types (type)`,
+			`drop index if exists orders_order_id_uindex`,
+			`alter table orders
+	add constraint orders_user_fk
+		foreign key (user_id) references users (id),
+	add constraint orders_status_fk
+		foreign key (status) references order_types (id),
+	add constraint orders_accrual_nonnegative
+		check (accrual >= 0)`,
+			`create index if not exists orders_status_uploaded_index
+	on orders (status, uploaded)`,
+			`alter table balances
+	alter column balance set not null,
+	add constraint balances_user_fk
+		foreign key (user_id) references users (id)`,
+			`alter table withdrawals
+	add constraint withdrawals_pk
+		primary key (id),
+	add constraint withdrawals_user_fk
+		foreign key (user_id) references users (id),
+	add constraint withdrawals_sum_positive
+		check (sum > 0)`,
+			`alter table adjustments
+	add constraint adjustments_user_fk
+		foreign key (user_id) references users (id)`,
+			`alter table webhooks
+	add constraint webhooks_user_fk
+		foreign key (user_id) references users (id)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "partner merchants",
+		sqls: []string{
+			`create table if not exists merchants
+(
+	id       bigserial primary key,
+	name     text      not null,
+	key_hash text      not null,
+	scopes   text[]    not null,
+	created  timestamp not null
+)`,
+			`create unique index if not exists merchants_name_uindex
+	on merchants (name)`,
+			`create unique index if not exists merchants_key_hash_uindex
+	on merchants (key_hash)`,
+			`create table if not exists link_tokens
+(
+	token_hash text      primary key,
+	user_id    int       not null references users (id),
+	expires    timestamp not null
+)`,
+			`alter table orders
+	add column if not exists merchant_id bigint references merchants (id)`,
+			`create index if not exists orders_merchant_index
+	on orders (merchant_id) where merchant_id is not null`,
+			`alter table withdrawals
+	add column if not exists reversed timestamp,
+	add column if not exists reversed_by bigint references merchants (id)`,
+			`create table if not exists partner_audit
+(
+	id          bigserial primary key,
+	merchant_id bigint    not null references merchants (id),
+	method      text      not null,
+	path        text      not null,
+	status      int       not null,
+	request_id  text      not null,
+	created     timestamp not null
+)`,
+			`create index if not exists partner_audit_merchant_index
+	on partner_audit (merchant_id, id)`,
+		},
+	},
+	{
+		// The updater visits the orders it checked least recently,
+		// orders_status_checked_index replaces the one from version 5.
+		version: 7,
+		name:    "orders checked",
+		sqls: []string{
+			`alter table orders
+	add column if not exists checked timestamp`,
+			`create index if not exists orders_status_checked_index
+	on orders (status, checked nulls first, uploaded)`,
+			`drop index if exists orders_status_uploaded_index`,
+		},
+	},
+	{
+		// Version 5 adds its checks and foreign keys validated: a database
+		// with rows breaking them, such as the withdrawals of a negative
+		// sum earlier versions accepted, stops there until the rows are
+		// fixed. Here they are re-added "not valid" and validated one by
+		// one, which finds them valid after version 5.
+		version: 8,
+		name:    "integrity constraints not valid",
+		sqls: []string{
+			`alter table orders
+	drop constraint if exists orders_user_fk,
+	add constraint orders_user_fk
+		foreign key (user_id) references users (id) not valid,
+	drop constraint if exists orders_status_fk,
+	add constraint orders_status_fk
+		foreign key (status) references order_types (id) not valid,
+	drop constraint if exists orders_accrual_nonnegative,
+	add constraint orders_accrual_nonnegative
+		check (accrual >= 0) not valid`,
+			`alter table balances
+	drop constraint if exists balances_user_fk,
+	add constraint balances_user_fk
+		foreign key (user_id) references users (id) not valid`,
+			`alter table withdrawals
+	drop constraint if exists withdrawals_user_fk,
+	add constraint withdrawals_user_fk
+		foreign key (user_id) references users (id) not valid,
+	drop constraint if exists withdrawals_sum_positive,
+	add constraint withdrawals_sum_positive
+		check (sum > 0) not valid`,
+			`alter table adjustments
+	drop constraint if exists adjustments_user_fk,
+	add constraint adjustments_user_fk
+		foreign key (user_id) references users (id) not valid`,
+			`alter table webhooks
+	drop constraint if exists webhooks_user_fk,
+	add constraint webhooks_user_fk
+		foreign key (user_id) references users (id) not valid`,
+		},
+		validate: []constraint{
+			{"orders", "orders_user_fk"},
+			{"orders", "orders_status_fk"},
+			{"orders", "orders_accrual_nonnegative"},
+			{"balances", "balances_user_fk"},
+			{"withdrawals", "withdrawals_user_fk"},
+			{"withdrawals", "withdrawals_sum_positive"},
+			{"adjustments", "adjustments_user_fk"},
+			{"webhooks", "webhooks_user_fk"},
+		},
+	},
+	{
+		version: 9,
+		name:    "callback signatures",
+		sqls: []string{
+			`create table if not exists callback_signatures
+(
+	signature text      primary key,
+	expires   timestamp not null
+)`,
+			`create index if not exists callback_signatures_expires_index
+	on callback_signatures (expires)`,
+		},
+	},
+	{
+		// The relay leases and marks events per sink, outbox.delivered
+		// is set once every sink has them.
+		version: 10,
+		name:    "outbox deliveries",
+		sqls: []string{
+			`create table if not exists outbox_deliveries
+(
+	event_id     bigint    not null references outbox (id) on delete cascade,
+	sink         text      not null,
+	leased_until timestamp not null,
+	delivered    timestamp,
+	primary key (event_id, sink)
+)`,
+		},
+	},
+	{
+		// PurgeEvents removes the finished webhook deliveries by age.
+		version: 11,
+		name:    "webhook deliveries finished index",
+		sqls: []string{
+			`create index if not exists webhook_deliveries_finished_index
+	on webhook_deliveries (created) where status <> 'pending'`,
+		},
+	},
+	{
+		// The user's event stream reads the outbox in commit order, by the
+		// transaction that wrote an event. The events before this version
+		// are committed and sort first.
+		version: 12,
+		name:    "outbox xact",
+		sqls: []string{
+			`alter table outbox
+	add column if not exists xact bigint not null default 0`,
+			`alter table outbox
+	alter column xact set default pg_current_xact_id()::text::bigint`,
+			`create index if not exists outbox_user_xact_index
+	on outbox (user_uuid, xact, id)`,
+		},
+	},
+	{
+		// Only the merchant of the paid order may reverse a withdrawal,
+		// the withdrawal records it instead of the merchant that reversed
+		// it. Databases that ran an interim text of version 6 have
+		// merchant_id and no reversed_by.
+		version: 13,
+		name:    "withdrawals merchant",
+		sqls: []string{
+			`alter table withdrawals
+	add column if not exists merchant_id bigint references merchants (id),
+	add column if not exists reversed_by bigint`,
+			`update withdrawals w set merchant_id=coalesce(
+		(select o.merchant_id from orders o where o.order_id=w.order_id and o.user_id=w.user_id),
+		w.reversed_by)
+	where w.merchant_id is null`,
+			`alter table withdrawals
+	drop column reversed_by`,
+		},
+	},
+}
+
+func migrate(ctx context.Context, db *pgxpool.Pool) error {
+	_, err := db.Exec(ctx, `create table if not exists schema_migrations
+(
+	version int primary key,
+	name    text      not null,
+	applied timestamp not null
+)`)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+
+	for _, m := range migrations {
+		applied, err := applyMigration(ctx, db, m)
+		if err != nil {
+			return fmt.Errorf("error migration %d %s: %w", m.version, m.name, err)
+		}
+		if applied {
+			log.Ctx(ctx).Info().Msgf("migration %d %s applied", m.version, m.name)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *pgxpool.Pool, m migration) (bool, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, utils.ErrorHelper(err)
+	}
+	rollback := func(err error) error {
+		errRoll := tx.Rollback(ctx)
+		if errRoll != nil {
+			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
+		}
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `select pg_advisory_xact_lock($1)`, migrationsLock)
+	if err != nil {
+		return false, rollback(utils.ErrorHelper(err))
+	}
+
+	var done bool
+	row := tx.QueryRow(ctx, `select exists (select from schema_migrations where version=$1)`, m.version)
+	if err = row.Scan(&done); err != nil {
+		return false, rollback(utils.ErrorHelper(err))
+	}
+	if done {
+		return false, rollback(nil)
+	}
+
+	for _, s := range m.sqls {
+		if _, err = tx.Exec(ctx, s); err != nil {
+			return false, rollback(utils.ErrorHelper(err))
+		}
+	}
+	for _, c := range m.validate {
+		if err = validateConstraint(ctx, tx, c); err != nil {
+			return false, rollback(err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `insert into schema_migrations (version, name, applied) values ($1, $2, $3)`,
+		m.version, m.name, time.Now())
+	if err != nil {
+		return false, rollback(utils.ErrorHelper(err))
+	}
+
+	return true, utils.ErrorHelper(tx.Commit(ctx))
+}
+
+// validateConstraint validates c in a savepoint. A constraint old rows
+// break stays "not valid", it still holds for new rows; the offending rows
+// are to be fixed by hand and the constraint validated then.
+func validateConstraint(ctx context.Context, tx pgx.Tx, c constraint) error {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	_, err = sp.Exec(ctx, `alter table `+c.table+` validate constraint `+c.name)
+	if err == nil {
+		return utils.ErrorHelper(sp.Commit(ctx))
+	}
+	if errRoll := sp.Rollback(ctx); errRoll != nil {
+		return multierror.Append(utils.ErrorHelper(err), fmt.Errorf("error on rollback %w", errRoll))
+	}
+	if !pgError(err, codeCheckViolation, c.name) && !pgError(err, codeForeignKeyViolation, c.name) {
+		return utils.ErrorHelper(err)
+	}
+	log.Ctx(ctx).Warn().Err(err).Str("constraint", c.name).
+		Msgf("constraint %s on %s left not valid, existing rows break it", c.name, c.table)
+	return nil
+}
+
+// CheckMigrations fails unless every migration known to this build is
+// applied.
+func (p *PgStore) CheckMigrations(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "PgStore.CheckMigrations")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var applied int
+	row := p.db.QueryRow(ctx, `select count(*) from schema_migrations where version<=$1`,
+		migrations[len(migrations)-1].version)
+	if err := row.Scan(&applied); err != nil {
+		return utils.ErrorHelper(err)
+	}
+	if applied < len(migrations) {
+		return fmt.Errorf("%d of %d migrations applied", applied, len(migrations))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/e-faizov/gophermart/internal/pgtest"
+)
+
+func TestMigrateBaselineRows(t *testing.T) {
+	conn := pgtest.Start(t)
+	ctx := context.Background()
+
+	db, err := pgxpool.New(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the tables of the first release, with rows it accepted
+	for _, create := range []func(context.Context, *pgxpool.Pool) error{
+		createUsersTable, createOrdersTable, createOrderTypesTable, createBalancesTable, createWithdrawalsTable,
+	} {
+		if err = create(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	for _, s := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		{`insert into users (uuid, login, hash) values ('gopher-uuid', 'gopher', 'hash')`, nil},
+		{`insert into balances (user_id, balance) values (1, 70)`, nil},
+		{`insert into orders (order_id, user_id, uploaded, status, accrual) values ('12345678903', 1, $1, 3, 100)`, []interface{}{now}},
+		{`insert into withdrawals (user_id, order_id, sum, processed) values (1, '2377225624', -50, $1)`, []interface{}{now}},
+		{`insert into withdrawals (user_id, order_id, sum, processed) values (1, '79927398713', 80, $1)`, []interface{}{now}},
+	} {
+		if _, err = db.Exec(ctx, s.sql, s.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// version 5 stops on the negative withdrawal and leaves no trace
+	if _, err = NewPgStore(conn, "secret", Options{}); !pgError(err, codeCheckViolation, "withdrawals_sum_positive") {
+		t.Fatal("migration passed baseline rows breaking a check:", err)
+	}
+	var applied bool
+	if err = db.QueryRow(ctx, `select exists (select from schema_migrations where version=5)`).Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied {
+		t.Error("version 5 recorded after it failed")
+	}
+
+	// and goes through once the rows are fixed
+	if _, err = db.Exec(ctx, `delete from withdrawals where sum<=0`); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewPgStore(conn, "secret", Options{})
+	if err != nil {
+		t.Fatal("migration failed on fixed rows:", err)
+	}
+	defer store.Close()
+	if err = store.CheckMigrations(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"withdrawals_sum_positive", "withdrawals_user_fk", "orders_user_fk", "orders_accrual_nonnegative",
+	} {
+		var validated bool
+		err = db.QueryRow(ctx, `select convalidated from pg_constraint where conname=$1`, name).Scan(&validated)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !validated {
+			t.Errorf("%s not validated", name)
+		}
+	}
+
+	_, err = db.Exec(ctx, `insert into withdrawals (user_id, order_id, sum, processed) values (1, '4561261212345467', -1, $1)`, now)
+	if !pgError(err, codeCheckViolation, "withdrawals_sum_positive") {
+		t.Error("negative withdrawal accepted:", err)
+	}
+
+	balance, err := store.BalanceByUser(ctx, "gopher-uuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Current != 70 || balance.Withdrawn != 80 {
+		t.Error("wrong balance", balance)
+	}
+}
+
+func TestMigrateWithdrawalsMerchant(t *testing.T) {
+	conn := pgtest.Start(t)
+	ctx := context.Background()
+
+	db, err := pgxpool.New(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// a database migrated before version 13, with withdrawals.reversed_by
+	all := migrations
+	migrations = all[:12]
+	err = initTables(ctx, db)
+	migrations = all
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, s := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		{`insert into users (uuid, login, hash) values ('gopher-uuid', 'gopher', 'hash')`, nil},
+		{`insert into merchants (name, key_hash, scopes, created) values ('shop', 'hash', '{}', $1)`, []interface{}{now}},
+		{`insert into orders (order_id, user_id, uploaded, status, merchant_id)
+			values ('12345678903', 1, $1, (select id from order_types where type='NEW'), 1)`, []interface{}{now}},
+		{`insert into withdrawals (user_id, order_id, sum, processed) values (1, '12345678903', 10, $1)`, []interface{}{now}},
+		{`insert into withdrawals (user_id, order_id, sum, processed, reversed, reversed_by)
+			values (1, '2377225624', 5, $1, $1, 1)`, []interface{}{now}},
+		{`insert into withdrawals (user_id, order_id, sum, processed) values (1, '79927398713', 3, $1)`, []interface{}{now}},
+	} {
+		if _, err = db.Exec(ctx, s.sql, s.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0 is no merchant
+	for order, want := range map[string]int64{
+		"12345678903": 1,
+		"2377225624":  1,
+		"79927398713": 0,
+	} {
+		var merchant *int64
+		err = db.QueryRow(ctx, `select merchant_id from withdrawals where order_id=$1`, order).Scan(&merchant)
+		if err != nil {
+			t.Fatal(order, err)
+		}
+		var got int64
+		if merchant != nil {
+			got = *merchant
+		}
+		if got != want {
+			t.Errorf("%s: merchant %d, want %d", order, got, want)
+		}
+	}
+
+	var left bool
+	err = db.QueryRow(ctx, `select exists (select from information_schema.columns
+		where table_name='withdrawals' and column_name='reversed_by')`).Scan(&left)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left {
+		t.Error("withdrawals.reversed_by not dropped")
+	}
+}
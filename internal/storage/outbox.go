@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+const eventsChannel = "gophermart_events"
+
+// insertEvent writes an event to the outbox inside tx, so it is published
+// only if the change it describes is committed. The NOTIFY is delivered to
+// listeners on commit as well.
+func insertEvent(ctx context.Context, tx pgx.Tx, tp, user string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+
+	sqlString := `with event as (insert into outbox (type, user_uuid, payload, created) values ($1, $2, $3, $4) returning id)
+				select pg_notify($5, json_build_object('id', id, 'user', $2::text)::text) from event`
+	_, err = tx.Exec(ctx, sqlString, tp, user, string(data), time.Now(), eventsChannel)
+	return utils.ErrorHelper(err)
+}
+
+func insertBalanceEvent(ctx context.Context, tx pgx.Tx, user string) error {
+	sqlString := `select b.balance, coalesce((select sum(sum) from withdrawals w where w.user_id=b.user_id and w.reversed is null), 0)
+				from balances b where b.user_id=(select id from users where uuid=$1)`
+	var balance models.Balance
+	err := tx.QueryRow(ctx, sqlString, user).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	return insertEvent(ctx, tx, models.EventBalanceChanged, user, balance)
+}
+
+// ClaimEvents leases up to limit events not delivered to sink yet: other
+// replicas skip them for sink until lease runs out or MarkDelivered. Each
+// sink has leases of its own, and nothing stays locked while they deliver.
+func (p *PgStore) ClaimEvents(ctx context.Context, sink string, limit int, lease time.Duration) ([]models.Event, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.ClaimEvents")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	script := `with due as (select o.id from outbox o
+					left join outbox_deliveries d on d.event_id=o.id and d.sink=$1
+					where o.delivered is null
+						and (d.event_id is null or (d.delivered is null and d.leased_until<=$2))
+					order by o.id
+					limit $3),
+				leased as (insert into outbox_deliveries (event_id, sink, leased_until)
+					select id, $1, $4 from due
+					on conflict (event_id, sink) do update set leased_until=excluded.leased_until
+						where outbox_deliveries.delivered is null and outbox_deliveries.leased_until<=$2
+					returning event_id)
+				select o.id, o.type, o.user_uuid, o.payload, o.created from outbox o
+				join leased l on l.event_id=o.id
+				order by o.id`
+	rows, err := p.db.Query(ctx, script, sink, now, limit, now.Add(lease))
+	if err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	var res []models.Event
+	for rows.Next() {
+		var ev models.Event
+		err = rows.Scan(&ev.ID, &ev.Type, &ev.User, &ev.Payload, &ev.Created)
+		if err != nil {
+			return nil, utils.ErrorHelper(err)
+		}
+		res = append(res, ev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
+
+// MarkDelivered records that sink took the events ids. An event delivered
+// to every one of sinks is delivered, PurgeEvents removes it in time.
+func (p *PgStore) MarkDelivered(ctx context.Context, sink string, ids []int64, sinks []string) error {
+	ctx, span := tracing.Start(ctx, "PgStore.MarkDelivered")
+	defer span.End()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	rollback := func(err error) error {
+		errRoll := tx.Rollback(ctx)
+		if errRoll != nil {
+			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
+		}
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(ctx, `update outbox_deliveries set delivered=$3 where sink=$1 and event_id=any($2)`,
+		sink, ids, now)
+	if err != nil {
+		return rollback(utils.ErrorHelper(err))
+	}
+
+	script := `update outbox o set delivered=$2
+				where o.id=any($1) and not exists (select from unnest($3::text[]) s (sink)
+					where not exists (select from outbox_deliveries d
+						where d.event_id=o.id and d.sink=s.sink and d.delivered is not null))`
+	_, err = tx.Exec(ctx, script, ids, now, sinks)
+	if err != nil {
+		return rollback(utils.ErrorHelper(err))
+	}
+
+	return utils.ErrorHelper(tx.Commit(ctx))
+}
+
+// PurgeEvents removes the events delivered to every sink and the delivered
+// or failed user webhook deliveries, the copies of events, created before
+// before. The count is of both.
+func (p *PgStore) PurgeEvents(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.PurgeEvents")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `delete from outbox where delivered is not null and created < $1`
+	events, err := p.db.Exec(ctx, sqlString, before)
+	if err != nil {
+		return 0, utils.ErrorHelper(err)
+	}
+
+	sqlString = `delete from webhook_deliveries where status <> $1 and created < $2`
+	deliveries, err := p.db.Exec(ctx, sqlString, models.DeliveryPending, before)
+	if err != nil {
+		return events.RowsAffected(), utils.ErrorHelper(err)
+	}
+	return events.RowsAffected() + deliveries.RowsAffected(), nil
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+var (
+	ErrWithdrawalNotFound = errors.New("withdrawal not found")
+	ErrWithdrawalReversed = errors.New("withdrawal already reversed")
+)
+
+// CreateMerchant stores the merchant with the hash of key, ok is false when
+// the name is taken.
+func (p *PgStore) CreateMerchant(ctx context.Context, merchant models.Merchant, key string) (models.Merchant, bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.CreateMerchant")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	merchant.Created = time.Now()
+	sqlString := `insert into merchants (name, key_hash, scopes, created) values ($1, $2, $3, $4) returning id`
+	row := p.db.QueryRow(ctx, sqlString, merchant.Name, calcHash(key, p.secret), merchant.Scopes, merchant.Created)
+	err := row.Scan(&merchant.ID)
+	if err != nil {
+		if pgError(err, codeUniqueViolation, "merchants_name_uindex") {
+			return models.Merchant{}, false, nil
+		}
+		return models.Merchant{}, false, utils.ErrorHelper(err)
+	}
+	return merchant, true, nil
+}
+
+func (p *PgStore) MerchantByKey(ctx context.Context, key string) (models.Merchant, bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.MerchantByKey")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var merchant models.Merchant
+	sqlString := `select id, name, scopes, created from merchants where key_hash=$1`
+	err := p.db.QueryRow(ctx, sqlString, calcHash(key, p.secret)).
+		Scan(&merchant.ID, &merchant.Name, &merchant.Scopes, &merchant.Created)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Merchant{}, false, nil
+	}
+	if err != nil {
+		return models.Merchant{}, false, utils.ErrorHelper(err)
+	}
+	return merchant, true, nil
+}
+
+// CreateLinkToken stores the hash of the user's token and drops the
+// expired ones.
+func (p *PgStore) CreateLinkToken(ctx context.Context, uuid string, token models.LinkToken) error {
+	ctx, span := tracing.Start(ctx, "PgStore.CreateLinkToken")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `with expired as (delete from link_tokens where expires<=$4)
+				insert into link_tokens (token_hash, user_id, expires)
+				select $1::text, id, $3::timestamp from users where uuid=$2`
+	res, err := p.db.Exec(ctx, sqlString, calcHash(token.Token, p.secret), uuid, token.Expires, time.Now())
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// AttachOrder saves the order for the user named by login or linking token
+// on behalf of the merchant. ErrUserNotFound reports an unknown login or a
+// spent or expired token. The token is spent by the first order inserted
+// with it, a repeat or a conflict leaves it for another try.
+func (p *PgStore) AttachOrder(ctx context.Context, merchantID int64, order models.PartnerOrder) (models.SaveResult, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.AttachOrder", tracing.AttrOrder.String(order.Number))
+	defer span.End()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return 0, utils.ErrorHelper(err)
+	}
+	rollback := func(err error) error {
+		errRoll := tx.Rollback(ctx)
+		if errRoll != nil {
+			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
+		}
+		return err
+	}
+
+	userQuery, user := userByLogin, order.Login
+	if order.LinkToken != "" {
+		userQuery, user = userByLinkToken, calcHash(order.LinkToken, p.secret)
+	}
+	res, err := saveOrder(ctx, tx, userQuery, user, order.Number, &merchantID)
+	if err != nil {
+		return 0, rollback(err)
+	}
+	if order.LinkToken != "" && res == models.OrderAccepted {
+		_, err = tx.Exec(ctx, `delete from link_tokens where token_hash=$1`, user)
+		if err != nil {
+			return 0, rollback(utils.ErrorHelper(err))
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
+
+// PartnerOrder returns an order the merchant attached, found is false for
+// any other.
+func (p *PgStore) PartnerOrder(ctx context.Context, merchantID int64, number string) (models.Order, bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.PartnerOrder", tracing.AttrOrder.String(number))
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var order models.Order
+	script := `select t1.order_id, t1.uploaded, t2.type, t1.accrual from orders t1
+				join order_types t2
+				on t1.status=t2.id
+				where t1.order_id=$1 and t1.merchant_id=$2`
+	err := p.db.QueryRow(ctx, script, number, merchantID).
+		Scan(&order.Number, &order.Uploaded, &order.Status, &order.Accrual)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Order{}, false, nil
+	}
+	if err != nil {
+		return models.Order{}, false, utils.ErrorHelper(err)
+	}
+	return order, true, nil
+}
+
+// ReverseWithdrawal returns the sum of a withdrawal to the balance. The
+// statement shows the reversal as an adjustment, the withdrawal stays.
+// Withdrawals of other merchants are reported as ErrWithdrawalNotFound.
+func (p *PgStore) ReverseWithdrawal(ctx context.Context, merchantID int64, order string) (models.ReversalResult, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.ReverseWithdrawal", tracing.AttrOrder.String(order))
+	defer span.End()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return models.ReversalResult{}, utils.ErrorHelper(err)
+	}
+	rollback := func(err error) error {
+		errRoll := tx.Rollback(ctx)
+		if errRoll != nil {
+			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
+		}
+		return err
+	}
+
+	res := models.ReversalResult{Order: order, Reversed: time.Now()}
+	var (
+		userID int
+		uuid   string
+	)
+	sqlString := `update withdrawals set reversed=$2
+				where order_id=$1 and merchant_id=$3 and reversed is null
+				returning sum, user_id, (select uuid from users where id=withdrawals.user_id)`
+	err = tx.QueryRow(ctx, sqlString, order, res.Reversed, merchantID).Scan(&res.Sum, &userID, &uuid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		err = tx.QueryRow(ctx, `select exists (select from withdrawals where order_id=$1 and merchant_id=$2)`, order, merchantID).
+			Scan(&exists)
+		if err != nil {
+			return models.ReversalResult{}, rollback(utils.ErrorHelper(err))
+		}
+		if exists {
+			return models.ReversalResult{}, rollback(ErrWithdrawalReversed)
+		}
+		return models.ReversalResult{}, rollback(ErrWithdrawalNotFound)
+	}
+	if err != nil {
+		return models.ReversalResult{}, rollback(utils.ErrorHelper(err))
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(`update balances set balance=balance+$1 where user_id=$2`, res.Sum, userID)
+	batch.Queue(`insert into adjustments (user_id, amount, reason, created) values ($1, $2, $3, $4)`,
+		userID, res.Sum, "withdrawal reversal "+order, res.Reversed)
+	if err = tx.SendBatch(ctx, batch).Close(); err != nil {
+		return models.ReversalResult{}, rollback(utils.ErrorHelper(err))
+	}
+
+	err = insertBalanceEvent(ctx, tx, uuid)
+	if err != nil {
+		return models.ReversalResult{}, rollback(err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return models.ReversalResult{}, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
+
+func (p *PgStore) SavePartnerAudit(ctx context.Context, entry models.PartnerAudit) error {
+	ctx, span := tracing.Start(ctx, "PgStore.SavePartnerAudit")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `insert into partner_audit (merchant_id, method, path, status, request_id, created)
+				values ($1, $2, $3, $4, $5, $6)`
+	_, err := p.db.Exec(ctx, sqlString, entry.MerchantID, entry.Method, entry.Path, entry.Status, entry.RequestID, entry.Created)
+	return utils.ErrorHelper(err)
+}
+
+// PartnerAudit returns the latest calls of the merchant, newest first.
+func (p *PgStore) PartnerAudit(ctx context.Context, merchantID int64, limit int) ([]models.PartnerAudit, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.PartnerAudit")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `select id, merchant_id, method, path, status, request_id, created from partner_audit
+				where merchant_id=$1
+				order by id desc
+				limit $2`
+	rows, err := p.reader().Query(ctx, sqlString, merchantID, limit)
+	if err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	var res []models.PartnerAudit
+	for rows.Next() {
+		var entry models.PartnerAudit
+		err = rows.Scan(&entry.ID, &entry.MerchantID, &entry.Method, &entry.Path, &entry.Status, &entry.RequestID, &entry.Created)
+		if err != nil {
+			return nil, utils.ErrorHelper(err)
+		}
+		res = append(res, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/pgtest"
+)
+
+func TestPartner(t *testing.T) {
+	store, err := NewPgStore(pgtest.Start(t), "secret", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	ok, uuid, err := store.Register(ctx, "gopher", "secret")
+	if err != nil || !ok {
+		t.Fatal("register:", ok, err)
+	}
+
+	merchant, ok, err := store.CreateMerchant(ctx, models.Merchant{Name: "shop", Scopes: []string{models.ScopeOrdersRead}}, "gm_key")
+	if err != nil || !ok {
+		t.Fatal("create merchant:", ok, err)
+	}
+	if _, ok, err = store.CreateMerchant(ctx, models.Merchant{Name: "shop", Scopes: []string{models.ScopeOrdersRead}}, "gm_other"); err != nil || ok {
+		t.Error("name taken: created", ok, err)
+	}
+	found, ok, err := store.MerchantByKey(ctx, "gm_key")
+	if err != nil || !ok || found.ID != merchant.ID || len(found.Scopes) != 1 {
+		t.Error("merchant by key:", found, ok, err)
+	}
+
+	t.Run("AttachOrder", func(t *testing.T) {
+		res, err := store.AttachOrder(ctx, merchant.ID, models.PartnerOrder{Number: "12345678903", Login: "gopher"})
+		if err != nil || res != models.OrderAccepted {
+			t.Fatal("attach by login:", res, err)
+		}
+
+		err = store.CreateLinkToken(ctx, uuid, models.LinkToken{Token: "token", Expires: time.Now().Add(time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// an order uploaded already doesn't spend the token
+		res, err = store.AttachOrder(ctx, merchant.ID, models.PartnerOrder{Number: "12345678903", LinkToken: "token"})
+		if err != nil || res != models.OrderAlreadyUploaded {
+			t.Fatal("attach a repeat by token:", res, err)
+		}
+		res, err = store.AttachOrder(ctx, merchant.ID, models.PartnerOrder{Number: "2377225624", LinkToken: "token"})
+		if err != nil || res != models.OrderAccepted {
+			t.Fatal("attach by token:", res, err)
+		}
+		// the token is spent by the first order
+		_, err = store.AttachOrder(ctx, merchant.ID, models.PartnerOrder{Number: "4561261212345467", LinkToken: "token"})
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Error("wrong error for a spent token:", err)
+		}
+
+		order, ok, err := store.PartnerOrder(ctx, merchant.ID, "2377225624")
+		if err != nil || !ok || order.Number != "2377225624" {
+			t.Error("partner order:", order, ok, err)
+		}
+		if _, ok, err = store.PartnerOrder(ctx, merchant.ID+1, "2377225624"); err != nil || ok {
+			t.Error("order of another merchant found:", ok, err)
+		}
+	})
+
+	t.Run("ReverseWithdrawal", func(t *testing.T) {
+		_, err := store.db.Exec(ctx, `update balances set balance=100 where user_id=(select id from users where uuid=$1)`, uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the user pays with points for an order the merchant attached and
+		// for one no merchant knows
+		if _, err = store.AttachOrder(ctx, merchant.ID, models.PartnerOrder{Number: "79927398713", Login: "gopher"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range []models.Withdraw{{Order: "79927398713", Sum: 40}, {Order: "4561261212345467", Sum: 10}} {
+			if _, err = store.Withdraw(ctx, w, uuid); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		other, ok, err := store.CreateMerchant(ctx, models.Merchant{Name: "other", Scopes: []string{models.ScopeWithdrawalsReverse}}, "gm_other_key")
+		if err != nil || !ok {
+			t.Fatal("create merchant:", ok, err)
+		}
+		if _, err = store.ReverseWithdrawal(ctx, other.ID, "79927398713"); !errors.Is(err, ErrWithdrawalNotFound) {
+			t.Error("wrong error for a withdrawal of another merchant:", err)
+		}
+		if _, err = store.ReverseWithdrawal(ctx, merchant.ID, "4561261212345467"); !errors.Is(err, ErrWithdrawalNotFound) {
+			t.Error("wrong error for a withdrawal of no merchant:", err)
+		}
+
+		res, err := store.ReverseWithdrawal(ctx, merchant.ID, "79927398713")
+		if err != nil || res.Sum != 40 {
+			t.Fatal("reverse:", res, err)
+		}
+		if _, err = store.ReverseWithdrawal(ctx, merchant.ID, "79927398713"); !errors.Is(err, ErrWithdrawalReversed) {
+			t.Error("wrong error for a second reversal:", err)
+		}
+		if _, err = store.ReverseWithdrawal(ctx, merchant.ID, "12345678903"); !errors.Is(err, ErrWithdrawalNotFound) {
+			t.Error("wrong error for an unknown withdrawal:", err)
+		}
+
+		balance, err := store.BalanceByUser(ctx, uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance.Current != 90 || balance.Withdrawn != 10 {
+			t.Error("wrong balance after reversal", balance)
+		}
+	})
+
+	if err = store.SavePartnerAudit(ctx, models.PartnerAudit{MerchantID: merchant.ID, Method: "GET", Path: "/api/partner/orders/1", Status: 200, Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.PartnerAudit(ctx, merchant.ID, 10)
+	if err != nil || len(entries) != 1 || entries[0].Status != 200 {
+		t.Error("audit:", entries, err)
+	}
+}
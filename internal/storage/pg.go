@@ -4,17 +4,21 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
-	"database/sql"
 	"errors"
 	"fmt"
-	"github.com/e-faizov/gophermart/internal/interfaces"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
-	"github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/metrics"
 	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/tracing"
 	"github.com/e-faizov/gophermart/internal/utils"
 )
 
@@ -25,41 +29,90 @@ const (
 	OtProcessed  = "PROCESSED"
 )
 
-func NewPgStore(conn, secret string) (*PgStore, error) {
-	db, err := sql.Open("postgres", conn)
+var (
+	ErrOrderNotFound = errors.New("order not found")
+	ErrUserNotFound  = errors.New("user not found")
+)
+
+func NewPgStore(conn, secret string, opts Options) (*PgStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	cfg, err := poolConfig(conn, opts)
+	if err != nil {
+		return nil, utils.ErrorHelper(fmt.Errorf("error parse db config: %w", err))
+	}
+	db, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return nil, utils.ErrorHelper(fmt.Errorf("error open db: %w", err))
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
 
 	err = initTables(ctx, db)
 	if err != nil {
+		db.Close()
 		return nil, fmt.Errorf("error init tables: %w", err)
 	}
 
-	return &PgStore{
-		db:     db,
-		secret: secret,
-	}, nil
+	res := &PgStore{
+		db:      db,
+		conn:    conn,
+		secret:  secret,
+		timeout: opts.StatementTimeout,
+	}
+	if opts.ReplicaConn != "" {
+		cfg, err = poolConfig(opts.ReplicaConn, opts)
+		if err != nil {
+			db.Close()
+			return nil, utils.ErrorHelper(fmt.Errorf("error parse replica config: %w", err))
+		}
+		res.replica, err = pgxpool.NewWithConfig(ctx, cfg)
+		if err != nil {
+			db.Close()
+			return nil, utils.ErrorHelper(fmt.Errorf("error open replica: %w", err))
+		}
+	}
+	return res, nil
 }
 
 type PgStore struct {
-	db     *sql.DB
+	db     *pgxpool.Pool
+	conn   string
 	secret string
+
+	// replica serves the read-only queries that may lag, nil sends them to
+	// db. timeout bounds every statement, zero means no bound.
+	replica *pgxpool.Pool
+	timeout time.Duration
+}
+
+func (p *PgStore) Close() {
+	p.db.Close()
+	if p.replica != nil {
+		p.replica.Close()
+	}
+}
+
+func (p *PgStore) Ping(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "PgStore.Ping")
+	defer span.End()
+
+	return utils.ErrorHelper(p.db.Ping(ctx))
 }
 
 func (p *PgStore) Register(ctx context.Context, login, password string) (bool, string, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.Register")
+	defer span.End()
+
 	hash := calcHash(password, p.secret)
 	uid := uuid.New()
 
-	tx, err := p.db.BeginTx(ctx, nil)
+	tx, err := p.begin(ctx)
 	if err != nil {
 		return false, "", utils.ErrorHelper(err)
 	}
 
 	rollback := func(err error) error {
-		errRoll := tx.Rollback()
+		errRoll := tx.Rollback(ctx)
 		if errRoll != nil {
 			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
 		}
@@ -67,31 +120,41 @@ func (p *PgStore) Register(ctx context.Context, login, password string) (bool, s
 	}
 
 	sqlString := `insert into users (uuid, login, hash) values ($1, $2, $3)`
-	_, err = tx.ExecContext(ctx, sqlString, uid.String(), login, hash)
+	_, err = tx.Exec(ctx, sqlString, uid.String(), login, hash)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "users_login_uindex" {
-			return false, "", nil
+		if pgError(err, codeUniqueViolation, "users_login_uindex") {
+			return false, "", rollback(nil)
 		}
 		return false, "", rollback(utils.ErrorHelper(err))
 	}
 
 	sqlString = `insert into balances (user_id, balance) values ((select id from users where uuid=$1), 0)`
-	_, err = tx.ExecContext(ctx, sqlString, uid.String())
+	_, err = tx.Exec(ctx, sqlString, uid.String())
 	if err != nil {
 		return false, "", rollback(utils.ErrorHelper(err))
 	}
 
-	err = tx.Commit()
+	err = insertEvent(ctx, tx, models.EventUserRegistered, uid.String(), models.UserRegisteredEvent{Login: login})
+	if err != nil {
+		return false, "", rollback(err)
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return false, "", utils.ErrorHelper(err)
 	}
 	return true, uid.String(), nil
 }
 func (p *PgStore) Login(ctx context.Context, login, password string) (string, bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.Login")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
 	hash := calcHash(password, p.secret)
 	sqlString := `select uuid from users where login=$1 and hash=$2`
 
-	rows, err := p.db.QueryContext(ctx, sqlString, login, hash)
+	rows, err := p.db.Query(ctx, sqlString, login, hash)
 	if err != nil {
 		return "", false, utils.ErrorHelper(err)
 	}
@@ -114,36 +177,131 @@ func (p *PgStore) Login(ctx context.Context, login, password string) (string, bo
 	return resUudi, true, nil
 }
 
-func (p *PgStore) SaveOrder(ctx context.Context, user, order string) (bool, bool, error) {
-	script := `insert into orders (order_id, user_id, uploaded, status)
-				values ($1, (select id from users where uuid=$2), $3, (select id from order_types where type=$4))`
-	_, err := p.db.ExecContext(ctx, script, order, user, time.Now(), OtNew)
+// SaveOrder uploads an order number of the user. The insert and the owner
+// lookup run in one transaction, a number uploaded concurrently is reported
+// as a conflict or a repeat, never as an error.
+func (p *PgStore) SaveOrder(ctx context.Context, user, order string) (models.SaveResult, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.SaveOrder", tracing.AttrOrder.String(order))
+	defer span.End()
+
+	tx, err := p.begin(ctx)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "orders_order_id_uindex" {
-			script = `select uuid from users where id=(select user_id from orders where order_id=$1)`
-			row := p.db.QueryRowContext(ctx, script, order)
-			insertedUserID := ""
-			err = row.Scan(&insertedUserID)
-			if err != nil {
-				return false, false, utils.ErrorHelper(err)
-			}
-			if insertedUserID == user {
-				return false, true, nil
-			} else {
-				return false, false, nil
-			}
+		return 0, utils.ErrorHelper(err)
+	}
+	rollback := func(err error) error {
+		errRoll := tx.Rollback(ctx)
+		if errRoll != nil {
+			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
 		}
-		return false, false, utils.ErrorHelper(err)
+		return err
+	}
+
+	res, err := saveOrder(ctx, tx, userByUUID, user, order, nil)
+	if err != nil {
+		return 0, rollback(err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
+
+// Queries finding the user an order is saved for, by the argument $2.
+// $3 is the current time.
+const (
+	userByUUID  = `select id from users where uuid=$2`
+	userByLogin = `select id from users where login=$2`
+	// the token row stays locked until it's spent, a concurrent attach
+	// with it waits and then finds it gone
+	userByLinkToken = `select user_id as id from link_tokens where token_hash=$2 and expires>$3 for update`
+)
+
+// saveOrder inserts the order for the user userQuery finds, merchant is the
+// one that attached it or nil. The insert and the owner lookup run in tx.
+func saveOrder(ctx context.Context, tx pgx.Tx, userQuery, user, order string, merchant *int64) (models.SaveResult, error) {
+	// userID is null for an unknown user, nothing is inserted then
+	script := `with u as (` + userQuery + `),
+				ins as (insert into orders (order_id, user_id, uploaded, status, merchant_id)
+					select $1::text, u.id, $3::timestamp, (select id from order_types where type=$4), $5::bigint from u
+					on conflict (order_id) do nothing
+					returning order_id)
+				select (select id from u), exists(select from ins)`
+	var (
+		userID   *int
+		inserted bool
+	)
+	err := tx.QueryRow(ctx, script, order, user, time.Now(), OtNew, merchant).Scan(&userID, &inserted)
+	if err != nil {
+		return 0, utils.ErrorHelper(err)
+	}
+	if userID == nil {
+		return 0, ErrUserNotFound
+	}
+	if inserted {
+		return models.OrderAccepted, nil
+	}
+
+	// The conflicting row may be committed after the snapshot of the
+	// insert was taken, a new statement sees it.
+	var owner int
+	err = tx.QueryRow(ctx, `select user_id from orders where order_id=$1`, order).Scan(&owner)
+	if err != nil {
+		return 0, utils.ErrorHelper(err)
+	}
+	if owner == *userID {
+		return models.OrderAlreadyUploaded, nil
 	}
-	return true, true, nil
+	return models.OrderConflict, nil
 }
 
-func (p *PgStore) GetOrders(ctx context.Context, user string) ([]models.Order, error) {
+// GetOrders returns a page of the user's orders. Pages are keyset based:
+// query.After is the sort key and number of the last order seen.
+func (p *PgStore) GetOrders(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.GetOrders")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var args queryArgs
+	where := []string{"t1.user_id=(select id from users where uuid=" + args.add(user) + ")"}
+
+	if len(query.Statuses) > 0 {
+		where = append(where, "t2.type=any("+args.add(query.Statuses)+")")
+	}
+	if query.From != nil {
+		where = append(where, "t1.uploaded>="+args.add(*query.From))
+	}
+	if query.To != nil {
+		where = append(where, "t1.uploaded<"+args.add(*query.To))
+	}
+
+	key := "t1.uploaded"
+	if query.Sort == models.SortAccrual {
+		key = "coalesce(t1.accrual, 0)"
+	}
+	dir, cmp := "asc", ">"
+	if query.Desc {
+		dir, cmp = "desc", "<"
+	}
+
+	if query.After != nil {
+		var after interface{} = query.After.Uploaded
+		if query.Sort == models.SortAccrual {
+			after = query.After.Accrual
+		}
+		where = append(where, "("+key+", t1.order_id)"+cmp+"("+args.add(after)+", "+args.add(query.After.Number)+")")
+	}
+
 	script := `select t1.order_id, t1.uploaded, t2.type, t1.accrual from orders t1
 				join order_types t2
 				on t1.status=t2.id
-				where t1.user_id=(select id from users where uuid=$1)`
-	rows, err := p.db.QueryContext(ctx, script, user)
+				where ` + strings.Join(where, " and ") + `
+				order by ` + key + " " + dir + ", t1.order_id " + dir
+	if query.Limit > 0 {
+		script += " limit " + args.add(query.Limit)
+	}
+	rows, err := p.reader().Query(ctx, script, args...)
 	if err != nil {
 		return nil, utils.ErrorHelper(err)
 	}
@@ -164,21 +322,78 @@ func (p *PgStore) GetOrders(ctx context.Context, user string) ([]models.Order, e
 	return res, nil
 }
 
+// ClaimOrder picks the order in status checked least recently and not since
+// checkedBefore and marks it checked, in a statement of its own: other
+// updaters skip the order from then on, without a lock held across the
+// check.
+func (p *PgStore) ClaimOrder(ctx context.Context, tp string, checkedBefore time.Time) (string, bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.ClaimOrder", tracing.AttrStatus.String(tp))
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	script := `update orders set checked=$3
+				where order_id=(select order_id from orders
+					where status=(select id from order_types where type=$1)
+						and (checked is null or checked<$2)
+					order by checked nulls first, uploaded
+					limit 1
+					for update skip locked)
+				returning order_id`
+	var order string
+	err := p.db.QueryRow(ctx, script, tp, checkedBefore, time.Now()).Scan(&order)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", true, nil
+	}
+	if err != nil {
+		return "", false, utils.ErrorHelper(err)
+	}
+	return order, false, nil
+}
+
 func (p *PgStore) NewUpdaterTx(ctx context.Context) (interfaces.OrderUpdateTx, error) {
-	tx, err := p.db.BeginTx(ctx, nil)
+	ctx, span := tracing.Start(ctx, "PgStore.NewUpdaterTx")
+	defer span.End()
+
+	tx, err := p.begin(ctx)
 	if err != nil {
 		return nil, err
 	}
 	res := &orderUpdateTxImpl{
-		tx: tx,
+		ctx: ctx,
+		tx:  tx,
 	}
 	return res, nil
 }
 
-func (p *PgStore) WithdrawalsByUser(ctx context.Context, uuid string) ([]models.Withdraw, error) {
-	sqlString := "select order_id, sum, processed from withdrawals where user_id=(select id from users where uuid=$1)"
+// WithdrawalsByUser returns a page of the user's withdrawals, newest first.
+func (p *PgStore) WithdrawalsByUser(ctx context.Context, uuid string, query models.WithdrawalsQuery) ([]models.Withdraw, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.WithdrawalsByUser")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var args queryArgs
+	where := []string{"user_id=(select id from users where uuid=" + args.add(uuid) + ")"}
+
+	if query.From != nil {
+		where = append(where, "processed>="+args.add(*query.From))
+	}
+	if query.To != nil {
+		where = append(where, "processed<"+args.add(*query.To))
+	}
+	if query.After != nil {
+		where = append(where, "(processed, order_id)<("+args.add(query.After.Processed)+", "+args.add(query.After.Order)+")")
+	}
+
+	sqlString := `select order_id, sum, processed from withdrawals
+				where ` + strings.Join(where, " and ") + `
+				order by processed desc, order_id desc`
+	if query.Limit > 0 {
+		sqlString += " limit " + args.add(query.Limit)
+	}
 
-	rows, err := p.db.QueryContext(ctx, sqlString, uuid)
+	rows, err := p.reader().Query(ctx, sqlString, args...)
 	if err != nil {
 		return nil, utils.ErrorHelper(err)
 	}
@@ -201,36 +416,39 @@ func (p *PgStore) WithdrawalsByUser(ctx context.Context, uuid string) ([]models.
 }
 
 func (p *PgStore) BalanceByUser(ctx context.Context, uuid string) (models.Balance, error) {
-	sqlString := `select balance from balances where user_id=(select id from users where uuid=$1)`
-	row := p.db.QueryRowContext(ctx, sqlString, uuid)
-	var res models.Balance
-	err := row.Scan(&res.Current)
-	if err != nil {
-		return models.Balance{}, utils.ErrorHelper(err)
-	}
-
-	sqlString = `select sum(sum) from withdrawals where user_id=(select id from users where uuid=$1)`
-	row = p.db.QueryRowContext(ctx, sqlString, uuid)
+	ctx, span := tracing.Start(ctx, "PgStore.BalanceByUser")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
-	var tmp *float64
-	err = row.Scan(&tmp)
-	if err != nil {
+	// both queries go in one round trip
+	batch := &pgx.Batch{}
+	var res models.Balance
+	batch.Queue(`select balance from balances where user_id=(select id from users where uuid=$1)`, uuid).
+		QueryRow(func(row pgx.Row) error {
+			return row.Scan(&res.Current)
+		})
+	batch.Queue(`select coalesce(sum(sum), 0) from withdrawals where user_id=(select id from users where uuid=$1) and reversed is null`, uuid).
+		QueryRow(func(row pgx.Row) error {
+			return row.Scan(&res.Withdrawn)
+		})
+
+	if err := p.db.SendBatch(ctx, batch).Close(); err != nil {
 		return models.Balance{}, utils.ErrorHelper(err)
 	}
-
-	if tmp != nil {
-		res.Withdrawn = *tmp
-	}
 	return res, nil
 }
 
 func (p *PgStore) Withdraw(ctx context.Context, withdraw models.Withdraw, uuid string) (bool, error) {
-	tx, err := p.db.BeginTx(ctx, nil)
+	ctx, span := tracing.Start(ctx, "PgStore.Withdraw", tracing.AttrOrder.String(withdraw.Order))
+	defer span.End()
+
+	tx, err := p.begin(ctx)
 	if err != nil {
 		return false, utils.ErrorHelper(err)
 	}
 	rollback := func(err error) error {
-		errRoll := tx.Rollback()
+		errRoll := tx.Rollback(ctx)
 		if errRoll != nil {
 			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
 		}
@@ -239,22 +457,49 @@ func (p *PgStore) Withdraw(ctx context.Context, withdraw models.Withdraw, uuid s
 
 	sqlString := `update balances set balance=balance-$1 where user_id=(select id from users where uuid=$2)`
 
-	_, err = tx.ExecContext(ctx, sqlString, withdraw.Sum, uuid)
+	_, err = tx.Exec(ctx, sqlString, withdraw.Sum, uuid)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "balances_nonnegative" {
-			return true, nil
+		if pgError(err, codeCheckViolation, "balances_nonnegative") {
+			return true, rollback(nil)
 		}
 		return false, rollback(utils.ErrorHelper(err))
 	}
 
-	sqlString = `insert into withdrawals (user_id, order_id, sum, processed) values ((select id from users where uuid=$1), $2, $3, $4)`
-	_, err = tx.ExecContext(ctx, sqlString, uuid, withdraw.Order, withdraw.Sum, time.Now())
+	// the withdrawal belongs to the merchant that attached the order it pays
+	// for, only that merchant may reverse it
+	withdraw.Processed = time.Now()
+	sqlString = `insert into withdrawals (user_id, order_id, sum, processed, merchant_id)
+				values ((select id from users where uuid=$1), $2, $3, $4,
+					(select merchant_id from orders where order_id=$2 and user_id=(select id from users where uuid=$1)))`
+	_, err = tx.Exec(ctx, sqlString, uuid, withdraw.Order, withdraw.Sum, withdraw.Processed)
 	if err != nil {
 		return false, rollback(utils.ErrorHelper(err))
 	}
 
-	err = tx.Commit()
-	return false, utils.ErrorHelper(err)
+	err = insertEvent(ctx, tx, models.EventWithdrawalCreated, uuid, withdraw)
+	if err != nil {
+		return false, rollback(err)
+	}
+
+	err = insertBalanceEvent(ctx, tx, uuid)
+	if err != nil {
+		return false, rollback(err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, utils.ErrorHelper(err)
+	}
+	metrics.PointsWithdrawn.Add(withdraw.Sum)
+	return false, nil
+}
+
+// queryArgs collects arguments of a query built on the fly.
+type queryArgs []interface{}
+
+func (q *queryArgs) add(v interface{}) string {
+	*q = append(*q, v)
+	return "$" + strconv.Itoa(len(*q))
 }
 
 func calcHash(s string, k string) string {
@@ -265,62 +510,113 @@ func calcHash(s string, k string) string {
 }
 
 type orderUpdateTxImpl struct {
-	tx *sql.Tx
+	// ctx is the context the transaction was opened with, Commit and
+	// Rollback take no context of their own.
+	ctx context.Context
+	tx  pgx.Tx
+	// finished are the orders moved to a final status, they are recorded
+	// in the metrics once the transaction commits.
+	finished []finishedOrder
 }
 
-func (o *orderUpdateTxImpl) GetOrderIdsByStatus(ctx context.Context, tp string) (string, bool, error) {
-	script := `select t1.order_id from orders t1
-				join order_types t2
-				on t1.status=t2.id
-				where t2.type=$1
-				order by uploaded
-				limit 1`
-	rows, err := o.tx.QueryContext(ctx, script, tp)
+type finishedOrder struct {
+	order    models.Order
+	uploaded time.Time
+}
+
+// UpdateOrder moves an order to a new status. INVALID and PROCESSED are
+// final, so applying the same update twice (poll and callback) credits the
+// balance only once. An unchanged status is left alone, without an event.
+func (o *orderUpdateTxImpl) UpdateOrder(ctx context.Context, order models.Order) error {
+	ctx, span := tracing.Start(ctx, "PgStore.UpdaterTx.UpdateOrder", tracing.AttrOrder.String(order.Number), tracing.AttrStatus.String(order.Status))
+	defer span.End()
+
+	var row pgx.Row
+	switch order.Status {
+	case OtInvalid, OtNew, OtProcessing:
+		script := `update orders set status=(select id from order_types where type=$1)
+					where order_id=$2 and status not in (select id from order_types where type in ($1, $3, $4))
+					returning (select uuid from users where id=orders.user_id), uploaded`
+		row = o.tx.QueryRow(ctx, script, order.Status, order.Number, OtInvalid, OtProcessed)
+	case OtProcessed:
+		script :=
+			`with order_update as (update orders set status=(select id from order_types where type=$1), accrual=$2, processed=$6
+				where order_id=$3 and status not in (select id from order_types where type in ($4, $5)) returning user_id, uploaded),
+			balance_update as (update balances set balance=balance+$2 where user_id=(select user_id from order_update))
+		select t1.uuid, t2.uploaded from users t1 join order_update t2 on t1.id=t2.user_id`
+		row = o.tx.QueryRow(ctx, script, order.Status, order.Accrual, order.Number, OtInvalid, OtProcessed, time.Now())
+	default:
+		return utils.ErrorHelper(errors.New("unknown order status: " + order.Status))
+	}
+
+	var user string
+	var uploaded time.Time
+	err := row.Scan(&user, &uploaded)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return o.orderExists(ctx, order.Number)
+	}
 	if err != nil {
-		return "", false, utils.ErrorHelper(err)
+		return utils.ErrorHelper(err)
 	}
-	defer rows.Close()
 
-	var res []string
-	for rows.Next() {
-		var order string
-		err = rows.Scan(&order)
-		if err != nil {
-			return "", false, utils.ErrorHelper(err)
-		}
-		res = append(res, order)
+	if order.Status == OtInvalid || order.Status == OtProcessed {
+		o.finished = append(o.finished, finishedOrder{order: order, uploaded: uploaded})
 	}
-	if err = rows.Err(); err != nil {
-		return "", false, utils.ErrorHelper(err)
+
+	err = insertEvent(ctx, o.tx, models.EventOrderStatusChanged, user, models.OrderStatusEvent{
+		Number:  order.Number,
+		Status:  order.Status,
+		Accrual: order.Accrual,
+	})
+	if err != nil {
+		return err
 	}
 
-	if len(res) == 0 {
-		return "", true, nil
+	if order.Status != OtProcessed || order.Accrual == nil || *order.Accrual <= 0 {
+		return nil
 	}
 
-	return res[0], false, nil
+	err = insertEvent(ctx, o.tx, models.EventAccrualCredited, user, models.AccrualCreditedEvent{
+		Order:   order.Number,
+		Accrual: *order.Accrual,
+	})
+	if err != nil {
+		return err
+	}
+	return insertBalanceEvent(ctx, o.tx, user)
 }
 
-func (o *orderUpdateTxImpl) UpdateOrder(ctx context.Context, order models.Order) error {
-	switch order.Status {
-	case OtInvalid, OtNew, OtProcessing:
-		script := "update orders set status=(select id from order_types where type=$1) where order_id=$2"
-		_, err := o.tx.ExecContext(ctx, script, order.Status, order.Number)
-		return utils.ErrorHelper(err)
-	case OtProcessed:
-		script :=
-			`with order_update as (update orders set status=(select id from order_types where type=$1), accrual=$2 where order_id=$3 returning user_id)
-		update balances set balance=balance+$2 where user_id=(select user_id from order_update)`
-		_, err := o.tx.ExecContext(ctx, script, order.Status, order.Accrual, order.Number)
+// orderExists tells an order already in a final status from an unknown one.
+func (o *orderUpdateTxImpl) orderExists(ctx context.Context, order string) error {
+	var exists bool
+	row := o.tx.QueryRow(ctx, `select exists (select from orders where order_id=$1)`, order)
+	if err := row.Scan(&exists); err != nil {
 		return utils.ErrorHelper(err)
 	}
-
-	return utils.ErrorHelper(errors.New("unknown order status: " + order.Status))
+	if !exists {
+		return ErrOrderNotFound
+	}
+	return nil
 }
 
 func (o *orderUpdateTxImpl) Rollback() error {
-	return o.tx.Rollback()
+	return o.tx.Rollback(o.ctx)
 }
 func (o *orderUpdateTxImpl) Commit() error {
-	return o.tx.Commit()
+	err := o.tx.Commit(o.ctx)
+	if err != nil {
+		return err
+	}
+	for _, f := range o.finished {
+		metrics.OrdersProcessed.WithLabelValues(f.order.Status).Inc()
+		if f.order.Status != OtProcessed {
+			continue
+		}
+		metrics.OrderTimeToProcess.Observe(time.Since(f.uploaded).Seconds())
+		if f.order.Accrual != nil {
+			metrics.PointsAccrued.Add(*f.order.Accrual)
+		}
+	}
+	o.finished = nil
+	return nil
 }
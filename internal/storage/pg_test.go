@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/pgtest"
+)
+
+func TestSaveOrder(t *testing.T) {
+	store, err := NewPgStore(pgtest.Start(t), "secret", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	users := make([]string, 2)
+	for i, login := range []string{"gopher", "rival"} {
+		ok, uuid, err := store.Register(ctx, login, "secret")
+		if err != nil || !ok {
+			t.Fatal("register:", ok, err)
+		}
+		users[i] = uuid
+	}
+
+	for _, tt := range []struct {
+		user string
+		want models.SaveResult
+	}{
+		{users[0], models.OrderAccepted},
+		{users[0], models.OrderAlreadyUploaded},
+		{users[1], models.OrderConflict},
+	} {
+		res, err := store.SaveOrder(ctx, tt.user, "12345678903")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != tt.want {
+			t.Errorf("wrong result %d, want %d", res, tt.want)
+		}
+	}
+
+	if _, err = store.SaveOrder(ctx, "unknown", "2377225624"); !errors.Is(err, ErrUserNotFound) {
+		t.Error("wrong error for an unknown user:", err)
+	}
+	if _, err = store.SaveOrders(ctx, "unknown", []string{"2377225624"}); !errors.Is(err, ErrUserNotFound) {
+		t.Error("wrong error for an unknown user in a batch:", err)
+	}
+}
+
+func TestSaveSignature(t *testing.T) {
+	conn := pgtest.Start(t)
+	ctx := context.Background()
+
+	// two replicas, or a process and its restart
+	stores := make([]*PgStore, 2)
+	for i := range stores {
+		store, err := NewPgStore(conn, "secret", Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		stores[i] = store
+	}
+
+	save := func(store *PgStore, sig string, expires time.Time) bool {
+		tx, err := store.NewUpdaterTx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fresh, err := tx.SaveSignature(ctx, sig, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		return fresh
+	}
+
+	expires := time.Now().Add(time.Minute)
+	for i, want := range []bool{true, false} {
+		if fresh := save(stores[i], "sha256=00", expires); fresh != want {
+			t.Errorf("store %d: fresh %v, want %v", i, fresh, want)
+		}
+	}
+
+	// a callback rolled back leaves its signature free for the retry
+	tx, err := stores[0].NewUpdaterTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.SaveSignature(ctx, "sha256=03", expires); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if !save(stores[1], "sha256=03", expires) {
+		t.Error("signature of a rolled back callback remembered")
+	}
+
+	save(stores[0], "sha256=01", time.Now().Add(-time.Minute))
+	save(stores[0], "sha256=02", expires)
+	var left int
+	if err := stores[0].db.QueryRow(ctx, `select count(*) from callback_signatures where signature='sha256=01'`).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Error("expired signature not swept")
+	}
+}
+
+func TestPurgeEvents(t *testing.T) {
+	store, err := NewPgStore(pgtest.Start(t), "secret", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	ok, uuid, err := store.Register(ctx, "gopher", "secret")
+	if err != nil || !ok {
+		t.Fatal("register:", ok, err)
+	}
+	hook, err := store.CreateWebhook(ctx, uuid, models.Webhook{URL: "https://example.com", Events: []string{models.EventPing}, Secret: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	for i, status := range []string{models.DeliveryDelivered, models.DeliveryFailed, models.DeliveryPending} {
+		_, err = store.db.Exec(ctx, `insert into webhook_deliveries
+			(webhook_id, event_id, event_type, payload, event_created, status, attempts, created)
+			values ($1, $2, 'ping', '{}', $3, $4, 1, $3)`, hook.ID, i+1, old, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = store.PurgeEvents(ctx, time.Now().Add(-7*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	rows, err := store.db.Query(ctx, `select status from webhook_deliveries`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var s string
+		if err = rows.Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, s)
+	}
+	if len(statuses) != 1 || statuses[0] != models.DeliveryPending {
+		t.Error("wrong deliveries left", statuses)
+	}
+}
+
+func TestEventsAfterCommitOrder(t *testing.T) {
+	store, err := NewPgStore(pgtest.Start(t), "secret", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	types := []string{models.EventBalanceChanged}
+	cursor, err := store.EventCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// first takes the smaller id and commits after second
+	first, err := store.db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback(ctx)
+	if err = insertEvent(ctx, first, models.EventBalanceChanged, "gopher", models.Balance{Current: 1}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = insertEvent(ctx, second, models.EventBalanceChanged, "gopher", models.Balance{Current: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err = second.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	evs, held, err := store.EventsAfter(ctx, "gopher", cursor, types, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 0 || !held {
+		t.Fatalf("event read ahead of a running transaction: %v, held %v", evs, held)
+	}
+
+	if err = first.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	evs, held, err = store.EventsAfter(ctx, "gopher", cursor, types, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 || held || evs[0].ID > evs[1].ID {
+		t.Fatalf("wrong events after both commits: %v, held %v", evs, held)
+	}
+
+	// resuming after the last event read finds nothing missed
+	cursor = models.EventCursor{Xact: evs[1].Xact, ID: evs[1].ID}
+	evs, _, err = store.EventsAfter(ctx, "gopher", cursor, types, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 0 {
+		t.Error("events read twice", evs)
+	}
+}
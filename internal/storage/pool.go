@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+// PostgreSQL error codes the store tells apart.
+const (
+	codeUniqueViolation     = "23505"
+	codeForeignKeyViolation = "23503"
+	codeCheckViolation      = "23514"
+)
+
+// Options tune the store. Zero values keep the pgxpool defaults, no
+// statement timeout and no replica.
+type Options struct {
+	// MaxConns and MinConns bound each pool, MinConns connections are kept
+	// open even when idle.
+	MaxConns        int
+	MinConns        int
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+
+	// StatementTimeout bounds every statement.
+	StatementTimeout time.Duration
+	// ReplicaConn serves the read-only queries that tolerate replication
+	// lag, the order and withdrawal lists, the statement and the exports.
+	ReplicaConn string
+	// QueryExecMode is one of queryExecModes, cache_describe when empty.
+	QueryExecMode string
+}
+
+// queryExecModes are named as in the pgx default_query_exec_mode connection
+// parameter. cache_describe keeps no prepared statements on the server, so
+// unlike cache_statement it doesn't fail with "cached plan must not change
+// result type" after a migration of a rolling deploy; behind a PgBouncer in
+// transaction mode only exec and simple_protocol work.
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// poolConfig parses conn and applies the pool options.
+func poolConfig(conn string, opts Options) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(conn)
+	if err != nil {
+		return nil, err
+	}
+	name := opts.QueryExecMode
+	if name == "" {
+		name = "cache_describe"
+	}
+	mode, ok := queryExecModes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown query exec mode %q", name)
+	}
+	cfg.ConnConfig.DefaultQueryExecMode = mode
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = int32(opts.MaxConns)
+	}
+	if opts.MinConns > 0 {
+		cfg.MinConns = int32(opts.MinConns)
+	}
+	if opts.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+	return cfg, nil
+}
+
+// Pool exposes the primary pool for its statistics.
+func (p *PgStore) Pool() *pgxpool.Pool {
+	return p.db
+}
+
+// Replica exposes the replica pool for its statistics, nil without one.
+func (p *PgStore) Replica() *pgxpool.Pool {
+	return p.replica
+}
+
+// PingReplica checks the replica like Ping the primary, without one it
+// pings the primary.
+func (p *PgStore) PingReplica(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "PgStore.PingReplica")
+	defer span.End()
+
+	return utils.ErrorHelper(p.reader().Ping(ctx))
+}
+
+func (p *PgStore) reader() *pgxpool.Pool {
+	if p.replica != nil {
+		return p.replica
+	}
+	return p.db
+}
+
+// withTimeout bounds a query run outside a transaction. Exports and the
+// event listener stream for long and aren't bounded.
+func (p *PgStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.timeout)
+}
+
+// begin opens a transaction with statement_timeout set for it. A context
+// deadline would also count the time between statements, the updater
+// calls the accrual system inside its transaction.
+func (p *PgStore) begin(ctx context.Context) (pgx.Tx, error) {
+	tx, err := p.db.Begin(ctx)
+	if err != nil || p.timeout <= 0 {
+		return tx, err
+	}
+
+	ms := strconv.FormatInt(p.timeout.Milliseconds(), 10)
+	_, err = tx.Exec(ctx, `select set_config('statement_timeout', $1, true)`, ms)
+	if err != nil {
+		if errRoll := tx.Rollback(ctx); errRoll != nil {
+			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
+		}
+		return nil, err
+	}
+	return tx, nil
+}
+
+// pgError tells whether err is a PostgreSQL error with code, on constraint
+// when it isn't empty.
+func pgError(err error, code, constraint string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != code {
+		return false
+	}
+	return constraint == "" || pgErr.ConstraintName == constraint
+}
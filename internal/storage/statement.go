@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+// statementEntries is every balance movement of user $1 with the running
+// balance. Accruals are dated by processing, older orders by upload.
+const statementEntries = `with entries as (
+	select coalesce(o.processed, o.uploaded) as at, 'accrual' as kind, o.order_id as ref, o.accrual as amount
+	from orders o
+	where o.user_id=(select id from users where uuid=$1)
+	and o.status=(select id from order_types where type='PROCESSED') and o.accrual>0
+	union all
+	select w.processed, 'withdrawal', w.order_id, -w.sum
+	from withdrawals w
+	where w.user_id=(select id from users where uuid=$1)
+	union all
+	select a.created, 'adjustment', a.reason, a.amount
+	from adjustments a
+	where a.user_id=(select id from users where uuid=$1)
+), running as (
+	select at, kind, ref, amount,
+		sum(amount) over (order by at, kind, ref rows between unbounded preceding and current row) as balance
+	from entries
+)
+`
+
+// Statement returns the user's movements in [from, to). Nil bounds are open.
+func (p *PgStore) Statement(ctx context.Context, uuid string, from, to *time.Time) (models.Statement, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.Statement")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	res := models.Statement{
+		From:    from,
+		To:      to,
+		Entries: []models.StatementEntry{},
+	}
+
+	sqlString := statementEntries + `select coalesce(sum(amount), 0) from running where at<$2`
+	if from != nil {
+		err := p.reader().QueryRow(ctx, sqlString, uuid, *from).Scan(&res.Opening)
+		if err != nil {
+			return models.Statement{}, utils.ErrorHelper(err)
+		}
+	}
+
+	sqlString = statementEntries + `select at, kind, ref, amount, balance from running
+				where ($2::timestamp is null or at>=$2) and ($3::timestamp is null or at<$3)
+				order by at, kind, ref`
+	rows, err := p.reader().Query(ctx, sqlString, uuid, from, to)
+	if err != nil {
+		return models.Statement{}, utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	res.Closing = res.Opening
+	for rows.Next() {
+		var e models.StatementEntry
+		err = rows.Scan(&e.Time, &e.Kind, &e.Reference, &e.Amount, &e.Balance)
+		if err != nil {
+			return models.Statement{}, utils.ErrorHelper(err)
+		}
+		res.Entries = append(res.Entries, e)
+		res.Closing = e.Balance
+	}
+	if err = rows.Err(); err != nil {
+		return models.Statement{}, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
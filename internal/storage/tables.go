@@ -2,16 +2,16 @@ package storage
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 
 	"github.com/e-faizov/gophermart/internal/utils"
 )
 
-func tableExist(ctx context.Context, db *sql.DB, tb string) bool {
+func tableExist(ctx context.Context, db *pgxpool.Pool, tb string) bool {
 	sql := `select exists (
 	   select from information_schema.tables
 	   where  table_schema = 'public'
@@ -20,7 +20,7 @@ func tableExist(ctx context.Context, db *sql.DB, tb string) bool {
 `
 
 	var exists bool
-	row := db.QueryRowContext(ctx, sql, tb)
+	row := db.QueryRow(ctx, sql, tb)
 	err := row.Scan(&exists)
 	if err != nil {
 		return false
@@ -28,32 +28,32 @@ func tableExist(ctx context.Context, db *sql.DB, tb string) bool {
 	return exists
 }
 
-func createTable(ctx context.Context, db *sql.DB, sqls ...string) error {
-	tx, err := db.BeginTx(ctx, nil)
+func createTable(ctx context.Context, db *pgxpool.Pool, sqls ...string) error {
+	tx, err := db.Begin(ctx)
 	if err != nil {
 		return utils.ErrorHelper(fmt.Errorf("error open Tx: %w", err))
 	}
 	rollback := func(err error) error {
-		errRoll := tx.Rollback()
+		errRoll := tx.Rollback(ctx)
 		if errRoll != nil {
 			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
 		}
 		return err
 	}
 	for _, s := range sqls {
-		_, err = tx.ExecContext(ctx, s)
+		_, err = tx.Exec(ctx, s)
 		if err != nil {
 			return rollback(err)
 		}
 	}
-	err = tx.Commit()
+	err = tx.Commit(ctx)
 	if err != nil {
 		return utils.ErrorHelper(err)
 	}
 	return nil
 }
 
-func createOrderTypesTable(ctx context.Context, db *sql.DB) error {
+func createOrderTypesTable(ctx context.Context, db *pgxpool.Pool) error {
 	err := createTable(ctx, db,
 		`create table order_types
 (
@@ -67,7 +67,7 @@ func createOrderTypesTable(ctx context.Context, db *sql.DB) error {
 	return utils.ErrorHelper(err)
 }
 
-func createOrdersTable(ctx context.Context, db *sql.DB) error {
+func createOrdersTable(ctx context.Context, db *pgxpool.Pool) error {
 	err := createTable(ctx, db,
 		`create table orders
 (
@@ -86,7 +86,7 @@ func createOrdersTable(ctx context.Context, db *sql.DB) error {
 	return utils.ErrorHelper(err)
 }
 
-func createUsersTable(ctx context.Context, db *sql.DB) error {
+func createUsersTable(ctx context.Context, db *pgxpool.Pool) error {
 
 	err := createTable(ctx, db,
 		`create table users
@@ -106,7 +106,7 @@ func createUsersTable(ctx context.Context, db *sql.DB) error {
 	return utils.ErrorHelper(err)
 }
 
-func createWithdrawalsTable(ctx context.Context, db *sql.DB) error {
+func createWithdrawalsTable(ctx context.Context, db *pgxpool.Pool) error {
 	err := createTable(ctx, db,
 		`create table withdrawals
 (
@@ -121,7 +121,7 @@ func createWithdrawalsTable(ctx context.Context, db *sql.DB) error {
 	return utils.ErrorHelper(err)
 }
 
-func createBalancesTable(ctx context.Context, db *sql.DB) error {
+func createBalancesTable(ctx context.Context, db *pgxpool.Pool) error {
 	err := createTable(ctx, db,
 		`create table balances
 (
@@ -134,7 +134,65 @@ func createBalancesTable(ctx context.Context, db *sql.DB) error {
 	return utils.ErrorHelper(err)
 }
 
-func initTables(ctx context.Context, db *sql.DB) error {
+func createOutboxTable(ctx context.Context, db *pgxpool.Pool) error {
+	err := createTable(ctx, db,
+		`create table outbox
+(
+	id        bigserial primary key,
+	type      text      not null,
+	user_uuid text      not null,
+	payload   jsonb     not null,
+	created   timestamp not null,
+	delivered timestamp
+)`,
+		`create index outbox_pending_index
+	on outbox (id) where delivered is null`)
+	return utils.ErrorHelper(err)
+}
+
+func createWebhooksTable(ctx context.Context, db *pgxpool.Pool) error {
+	err := createTable(ctx, db,
+		`create table webhooks
+(
+	id      bigserial primary key,
+	user_id int       not null,
+	url     text      not null,
+	secret  text      not null,
+	events  text[]    not null,
+	created timestamp not null
+)`,
+		`create index webhooks_user_id_index
+	on webhooks (user_id)`)
+	return utils.ErrorHelper(err)
+}
+
+func createWebhookDeliveriesTable(ctx context.Context, db *pgxpool.Pool) error {
+	err := createTable(ctx, db,
+		`create table webhook_deliveries
+(
+	id            bigserial primary key,
+	webhook_id    bigint    not null
+		references webhooks on delete cascade,
+	event_id      bigint,
+	event_type    text      not null,
+	payload       jsonb     not null,
+	event_created timestamp not null,
+	status        text      not null,
+	attempts      int       not null,
+	response_code int,
+	last_error    text,
+	next_attempt  timestamp,
+	created       timestamp not null,
+	delivered     timestamp
+)`,
+		`create unique index webhook_deliveries_event_uindex
+	on webhook_deliveries (webhook_id, event_id)`,
+		`create index webhook_deliveries_pending_index
+	on webhook_deliveries (next_attempt) where status = 'pending'`)
+	return utils.ErrorHelper(err)
+}
+
+func initTables(ctx context.Context, db *pgxpool.Pool) error {
 	var err error
 	exist := tableExist(ctx, db, "users")
 	if !exist {
@@ -142,7 +200,7 @@ func initTables(ctx context.Context, db *sql.DB) error {
 		if err != nil {
 			return fmt.Errorf("error create users: %v", err)
 		}
-		log.Info().Msg("users table created")
+		log.Ctx(ctx).Info().Msg("users table created")
 	}
 
 	exist = tableExist(ctx, db, "orders")
@@ -151,7 +209,7 @@ func initTables(ctx context.Context, db *sql.DB) error {
 		if err != nil {
 			return fmt.Errorf("error create orders: %v", err)
 		}
-		log.Info().Msg("users orders created")
+		log.Ctx(ctx).Info().Msg("users orders created")
 	}
 
 	exist = tableExist(ctx, db, "order_types")
@@ -160,7 +218,7 @@ func initTables(ctx context.Context, db *sql.DB) error {
 		if err != nil {
 			return fmt.Errorf("error create order_types: %v", err)
 		}
-		log.Info().Msg("users order_types created")
+		log.Ctx(ctx).Info().Msg("users order_types created")
 	}
 
 	exist = tableExist(ctx, db, "balances")
@@ -169,7 +227,7 @@ func initTables(ctx context.Context, db *sql.DB) error {
 		if err != nil {
 			return fmt.Errorf("error create order_types: %v", err)
 		}
-		log.Info().Msg("users balances created")
+		log.Ctx(ctx).Info().Msg("users balances created")
 	}
 
 	exist = tableExist(ctx, db, "withdrawals")
@@ -178,15 +236,53 @@ func initTables(ctx context.Context, db *sql.DB) error {
 		if err != nil {
 			return fmt.Errorf("error create order_types: %v", err)
 		}
-		log.Info().Msg("users withdrawals created")
+		log.Ctx(ctx).Info().Msg("users withdrawals created")
 	}
-	return nil
+
+	exist = tableExist(ctx, db, "outbox")
+	if !exist {
+		err = createOutboxTable(ctx, db)
+		if err != nil {
+			return fmt.Errorf("error create outbox: %v", err)
+		}
+		log.Ctx(ctx).Info().Msg("outbox created")
+	}
+
+	exist = tableExist(ctx, db, "webhooks")
+	if !exist {
+		err = createWebhooksTable(ctx, db)
+		if err != nil {
+			return fmt.Errorf("error create webhooks: %v", err)
+		}
+		log.Ctx(ctx).Info().Msg("webhooks created")
+	}
+
+	exist = tableExist(ctx, db, "webhook_deliveries")
+	if !exist {
+		err = createWebhookDeliveriesTable(ctx, db)
+		if err != nil {
+			return fmt.Errorf("error create webhook_deliveries: %v", err)
+		}
+		log.Ctx(ctx).Info().Msg("webhook_deliveries created")
+	}
+
+	return migrate(ctx, db)
 }
 
-func clearTable(db *sql.DB) {
-	db.Exec("drop table users")
-	db.Exec("drop table orders")
-	db.Exec("drop table order_types")
-	db.Exec("drop table balances")
-	db.Exec("drop table withdrawals")
+func clearTable(ctx context.Context, db *pgxpool.Pool) {
+	db.Exec(ctx, "drop table partner_audit")
+	db.Exec(ctx, "drop table link_tokens")
+	db.Exec(ctx, "drop table webhook_deliveries")
+	db.Exec(ctx, "drop table webhooks")
+	db.Exec(ctx, "drop table adjustments")
+	db.Exec(ctx, "drop table orders")
+	db.Exec(ctx, "drop table balances")
+	db.Exec(ctx, "drop table withdrawals")
+	db.Exec(ctx, "drop table outbox_deliveries")
+	db.Exec(ctx, "drop table outbox")
+	db.Exec(ctx, "drop table callback_signatures")
+	db.Exec(ctx, "drop table merchants")
+	db.Exec(ctx, "drop table users")
+	db.Exec(ctx, "drop table order_types")
+	db.Exec(ctx, "drop table schema_migrations")
 }
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/tracing"
+	"github.com/e-faizov/gophermart/internal/utils"
+)
+
+func (p *PgStore) CreateWebhook(ctx context.Context, uuid string, hook models.Webhook) (models.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.CreateWebhook")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	hook.Created = time.Now()
+	sqlString := `insert into webhooks (user_id, url, secret, events, created)
+				values ((select id from users where uuid=$1), $2, $3, $4, $5) returning id`
+	row := p.db.QueryRow(ctx, sqlString, uuid, hook.URL, hook.Secret, hook.Events, hook.Created)
+	err := row.Scan(&hook.ID)
+	if err != nil {
+		return models.Webhook{}, utils.ErrorHelper(err)
+	}
+	return hook, nil
+}
+
+func (p *PgStore) Webhooks(ctx context.Context, uuid string) ([]models.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.Webhooks")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `select id, url, events, created from webhooks
+				where user_id=(select id from users where uuid=$1)
+				order by id`
+	rows, err := p.db.Query(ctx, sqlString, uuid)
+	if err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	var res []models.Webhook
+	for rows.Next() {
+		var hook models.Webhook
+		err = rows.Scan(&hook.ID, &hook.URL, &hook.Events, &hook.Created)
+		if err != nil {
+			return nil, utils.ErrorHelper(err)
+		}
+		res = append(res, hook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
+
+func (p *PgStore) Webhook(ctx context.Context, uuid string, id int64) (models.Webhook, bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.Webhook")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `select id, url, events, created from webhooks
+				where id=$2 and user_id=(select id from users where uuid=$1)`
+	row := p.db.QueryRow(ctx, sqlString, uuid, id)
+
+	var hook models.Webhook
+	err := row.Scan(&hook.ID, &hook.URL, &hook.Events, &hook.Created)
+	if err == pgx.ErrNoRows {
+		return models.Webhook{}, false, nil
+	}
+	if err != nil {
+		return models.Webhook{}, false, utils.ErrorHelper(err)
+	}
+	return hook, true, nil
+}
+
+func (p *PgStore) UpdateWebhook(ctx context.Context, uuid string, hook models.Webhook) (bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.UpdateWebhook")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `update webhooks set url=$3, events=$4
+				where id=$2 and user_id=(select id from users where uuid=$1)`
+	res, err := p.db.Exec(ctx, sqlString, uuid, hook.ID, hook.URL, hook.Events)
+	return affected(res, err)
+}
+
+func (p *PgStore) DeleteWebhook(ctx context.Context, uuid string, id int64) (bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.DeleteWebhook")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `delete from webhooks where id=$2 and user_id=(select id from users where uuid=$1)`
+	res, err := p.db.Exec(ctx, sqlString, uuid, id)
+	return affected(res, err)
+}
+
+func (p *PgStore) PingWebhook(ctx context.Context, uuid string, id int64) (bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.PingWebhook")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	sqlString := `insert into webhook_deliveries
+				(webhook_id, event_type, payload, event_created, status, attempts, next_attempt, created)
+				select id, $3, '{}', $4, $5, 0, $4, $4 from webhooks
+				where id=$2 and user_id=(select id from users where uuid=$1)`
+	res, err := p.db.Exec(ctx, sqlString, uuid, id, models.EventPing, time.Now(), models.DeliveryPending)
+	return affected(res, err)
+}
+
+func (p *PgStore) WebhookDeliveries(ctx context.Context, uuid string, id int64, limit int) ([]models.WebhookDelivery, bool, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.WebhookDeliveries")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	_, found, err := p.Webhook(ctx, uuid, id)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	sqlString := `select d.id, d.webhook_id, coalesce(d.event_id, 0), d.event_type, d.payload, d.event_created,
+				d.status, d.attempts, d.response_code, d.last_error, d.next_attempt, d.created, d.delivered, u.uuid
+				from webhook_deliveries d
+				join webhooks w on w.id=d.webhook_id
+				join users u on u.id=w.user_id
+				where d.webhook_id=$1
+				order by d.id desc
+				limit $2`
+	rows, err := p.db.Query(ctx, sqlString, id, limit)
+	if err != nil {
+		return nil, false, utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	var res []models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.Event.ID, &d.Event.Type, &d.Event.Payload, &d.Event.Created,
+			&d.Status, &d.Attempts, &d.ResponseCode, &d.LastError, &d.NextAttempt, &d.Created, &d.Delivered, &d.Event.User)
+		if err != nil {
+			return nil, false, utils.ErrorHelper(err)
+		}
+		res = append(res, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, utils.ErrorHelper(err)
+	}
+	return res, true, nil
+}
+
+// EnqueueWebhookEvents creates a pending delivery of every event for each
+// matching subscription of the event's user. Enqueueing the same event again
+// is a no-op, so it is safe for the at-least-once outbox relay.
+func (p *PgStore) EnqueueWebhookEvents(ctx context.Context, events []models.Event) error {
+	ctx, span := tracing.Start(ctx, "PgStore.EnqueueWebhookEvents")
+	defer span.End()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return utils.ErrorHelper(err)
+	}
+	rollback := func(err error) error {
+		errRoll := tx.Rollback(ctx)
+		if errRoll != nil {
+			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
+		}
+		return err
+	}
+
+	sqlString := `insert into webhook_deliveries
+				(webhook_id, event_id, event_type, payload, event_created, status, attempts, next_attempt, created)
+				select w.id, $1, $2, $3, $4, $5, 0, $6, $6 from webhooks w
+				where w.user_id=(select id from users where uuid=$7) and $2=any(w.events)
+				on conflict (webhook_id, event_id) do nothing`
+	now := time.Now()
+	batch := &pgx.Batch{}
+	for _, ev := range events {
+		batch.Queue(sqlString, ev.ID, ev.Type, string(ev.Payload), ev.Created, models.DeliveryPending, now, ev.User)
+	}
+	// the events go in one round trip, Close reports the first failure
+	if err = tx.SendBatch(ctx, batch).Close(); err != nil {
+		return rollback(utils.ErrorHelper(err))
+	}
+
+	return utils.ErrorHelper(tx.Commit(ctx))
+}
+
+// ClaimDeliveries leases up to limit due deliveries: their next attempt is
+// moved lease ahead, so other replicas skip them until the attempt is saved
+// or the lease runs out. Nothing stays locked while the requests are sent.
+func (p *PgStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	ctx, span := tracing.Start(ctx, "PgStore.ClaimDeliveries")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	script := `with due as (select id from webhook_deliveries
+					where status=$1 and next_attempt<=$2
+					order by next_attempt
+					limit $3
+					for update skip locked)
+				update webhook_deliveries d set next_attempt=$4
+				from due, webhooks w, users u
+				where d.id=due.id and w.id=d.webhook_id and u.id=w.user_id
+				returning d.id, d.webhook_id, coalesce(d.event_id, 0), d.event_type, d.payload, d.event_created,
+					d.status, d.attempts, d.created, w.url, w.secret, u.uuid`
+	rows, err := p.db.Query(ctx, script, models.DeliveryPending, now, limit, now.Add(lease))
+	if err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	defer rows.Close()
+
+	var res []models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.Event.ID, &d.Event.Type, &d.Event.Payload, &d.Event.Created,
+			&d.Status, &d.Attempts, &d.Created, &d.URL, &d.Secret, &d.Event.User)
+		if err != nil {
+			return nil, utils.ErrorHelper(err)
+		}
+		res = append(res, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, utils.ErrorHelper(err)
+	}
+	return res, nil
+}
+
+// SaveAttempt records an attempt of a claimed delivery. An attempt made
+// after the lease ran out and the delivery was attempted again is dropped.
+func (p *PgStore) SaveAttempt(ctx context.Context, d models.WebhookDelivery) error {
+	ctx, span := tracing.Start(ctx, "PgStore.SaveAttempt")
+	defer span.End()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	script := `update webhook_deliveries
+				set status=$2, attempts=$3, response_code=$4, last_error=$5, next_attempt=$6, delivered=$7
+				where id=$1 and attempts=$3-1`
+	_, err := p.db.Exec(ctx, script, d.ID, d.Status, d.Attempts, d.ResponseCode, d.LastError,
+		d.NextAttempt, d.Delivered)
+	return utils.ErrorHelper(err)
+}
+
+func affected(res pgconn.CommandTag, err error) (bool, error) {
+	if err != nil {
+		return false, utils.ErrorHelper(err)
+	}
+	return res.RowsAffected() > 0, nil
+}
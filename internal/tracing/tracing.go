@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone = ""
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+)
+
+// Attribute keys shared by the spans of the service.
+const (
+	AttrOrder  = attribute.Key("gophermart.order.number")
+	AttrStatus = attribute.Key("gophermart.order.status")
+	// AttrNewStatus is the status the accrual system reported.
+	AttrNewStatus = attribute.Key("gophermart.order.new_status")
+)
+
+const tracerName = "github.com/e-faizov/gophermart"
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. ExporterOTLP sends spans over OTLP/HTTP configured by the
+// standard OTEL_EXPORTER_OTLP_* variables, ExporterFile appends them as
+// JSON to file. With ExporterNone spans are not recorded. The returned
+// function flushes pending spans.
+func Setup(ctx context.Context, exporter, file string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var (
+		exp     sdktrace.SpanExporter
+		closeFn = func() error { return nil }
+		err     error
+	)
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exp, err = otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error create otlp exporter: %w", err)
+		}
+	case ExporterFile:
+		if file == "" {
+			return nil, errors.New("trace file is not set")
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("error open trace file: %w", err)
+		}
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error create file exporter: %w", err)
+		}
+		closeFn = f.Close
+	default:
+		return nil, errors.New("unknown trace exporter: " + exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("gophermart"))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if errClose := closeFn(); err == nil {
+			err = errClose
+		}
+		return err
+	}, nil
+}
+
+// Tracer returns the tracer of the service from the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Start opens a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records a non nil err on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
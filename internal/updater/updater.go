@@ -2,115 +2,280 @@ package updater
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/e-faizov/gophermart/internal/storage"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/hashicorp/go-multierror"
 	"github.com/rs/zerolog/log"
-	"time"
 
 	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/metrics"
+	"github.com/e-faizov/gophermart/internal/storage"
+	"github.com/e-faizov/gophermart/internal/tracing"
 )
 
 type OrderUpdater struct {
 	Scores interfaces.Scores
 	Store  interfaces.OrdersStorage
-	cancel context.CancelFunc
-	done   chan struct{}
+	// Interval is the pause between sweeps over unfinished orders.
+	Interval time.Duration
+	// ErrorBackoff is the pause after a failed sweep, a second by default,
+	// RateLimitBackoff the one after the accrual system answered 429, a
+	// minute by default.
+	ErrorBackoff     time.Duration
+	RateLimitBackoff time.Duration
+	// StaleAfter is how long the worker may go without progress before
+	// Healthy fails, 5 minutes or 3 intervals by default.
+	StaleAfter time.Duration
+	// Concurrency is how many orders a sweep checks at once, 1 by default.
+	Concurrency int
+	cancel      context.CancelFunc
+	done        chan struct{}
+	wake        chan struct{}
+
+	timing   atomic.Pointer[Timing]
+	running  atomic.Bool
+	progress atomic.Int64
+}
+
+// Timing is the part of the settings that SetTiming changes on a running
+// updater, zero values take the defaults described on OrderUpdater.
+type Timing struct {
+	Interval         time.Duration
+	ErrorBackoff     time.Duration
+	RateLimitBackoff time.Duration
+	StaleAfter       time.Duration
+	Concurrency      int
+}
+
+func (t Timing) withDefaults() Timing {
+	if t.Interval == 0 {
+		t.Interval = time.Second
+	}
+	if t.ErrorBackoff == 0 {
+		t.ErrorBackoff = time.Second
+	}
+	if t.RateLimitBackoff == 0 {
+		t.RateLimitBackoff = time.Minute
+	}
+	if t.Concurrency == 0 {
+		t.Concurrency = 1
+	}
+	if t.StaleAfter == 0 {
+		t.StaleAfter = 5 * time.Minute
+		for _, d := range []time.Duration{t.Interval, t.RateLimitBackoff} {
+			if 3*d > t.StaleAfter {
+				t.StaleAfter = 3 * d
+			}
+		}
+	}
+	return t
 }
 
 func (s *OrderUpdater) Start() {
-	ctx, cancel := context.WithCancel(context.Background())
+	logger := log.With().Str("component", "updater").Logger()
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
 	s.done = make(chan struct{})
+	s.wake = make(chan struct{}, 1)
 	s.cancel = cancel
+	s.storeTiming(Timing{
+		Interval:         s.Interval,
+		ErrorBackoff:     s.ErrorBackoff,
+		RateLimitBackoff: s.RateLimitBackoff,
+		StaleAfter:       s.StaleAfter,
+		Concurrency:      s.Concurrency,
+	})
+	s.running.Store(true)
+	s.markProgress()
 	go s.worker(ctx)
 }
 
+// SetTiming replaces the intervals and the concurrency of a started
+// updater. A pause in progress is cut short or stretched to the new value,
+// a sweep in progress keeps its workers.
+func (s *OrderUpdater) SetTiming(t Timing) {
+	s.storeTiming(t)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *OrderUpdater) storeTiming(t Timing) {
+	t = t.withDefaults()
+	s.timing.Store(&t)
+}
+
+// Healthy fails once the worker has exited or made no progress for
+// StaleAfter. A sweep waiting out the accrual rate limit is progress.
+func (s *OrderUpdater) Healthy(ctx context.Context) error {
+	if !s.running.Load() {
+		return errors.New("updater is not running")
+	}
+	last := time.Unix(0, s.progress.Load())
+	if since := time.Since(last); since > s.timing.Load().StaleAfter {
+		return fmt.Errorf("no progress for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+func (s *OrderUpdater) markProgress() {
+	s.progress.Store(time.Now().UnixNano())
+}
+
 func (s *OrderUpdater) Stop() {
 	s.cancel()
 	<-s.done
 }
 
 func (s *OrderUpdater) worker(ctx context.Context) {
-	var sleep time.Duration
+	// pause is read from the current timing when the wait starts and again
+	// on every SetTiming.
+	pause := func(t *Timing) time.Duration { return 0 }
 	for {
-		select {
-		case <-ctx.Done():
-			s.done <- struct{}{}
-			return
-		default:
-			time.Sleep(sleep)
-			toManyReq, err := s.update(ctx, storage.OtNew)
-			if err != nil {
-				log.Error().Err(err).Msg("OrderUpdater.worker error update " + storage.OtNew)
-				sleep = time.Second
-				continue
-			}
-
-			if toManyReq {
-				sleep = time.Minute
-				continue
-			}
-
-			toManyReq, err = s.update(ctx, storage.OtProcessing)
-			if err != nil {
-				log.Error().Err(err).Msg("OrderUpdater.worker error update " + storage.OtProcessing)
-				sleep = time.Second
-				continue
+		start := time.Now()
+		timer := time.NewTimer(pause(s.timing.Load()))
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				s.running.Store(false)
+				s.done <- struct{}{}
+				return
+			case <-s.wake:
+				timer.Stop()
+				timer = time.NewTimer(time.Until(start.Add(pause(s.timing.Load()))))
+			case <-timer.C:
+				break wait
 			}
-
-			if toManyReq {
-				sleep = time.Minute
-				continue
-			}
-			sleep = time.Second
 		}
+
+		s.markProgress()
+		metrics.AccrualCircuitOpen.Set(0)
+		pause = s.sweep(ctx)
 	}
 }
-func (s *OrderUpdater) update(ctx context.Context, status string) (bool, error) {
 
-	for {
-		tx, err := s.Store.NewUpdaterTx(ctx)
+// sweep moves the new and then the processing orders forward and returns
+// the pause before the next sweep. Each order is checked once a sweep.
+func (s *OrderUpdater) sweep(ctx context.Context) func(t *Timing) time.Duration {
+	start := time.Now()
+	for _, status := range []string{storage.OtNew, storage.OtProcessing} {
+		toManyReq, err := s.update(ctx, status, start)
 		if err != nil {
-			return false, err
+			log.Ctx(ctx).Error().Err(err).Msg("OrderUpdater.worker error update " + status)
+			metrics.UpdaterFailures.Inc()
+			return func(t *Timing) time.Duration { return t.ErrorBackoff }
 		}
 
-		rollback := func(err error) error {
-			errRoll := tx.Rollback()
-			if errRoll != nil {
-				err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
-			}
-			return err
+		if toManyReq {
+			metrics.AccrualCircuitOpen.Set(1)
+			return func(t *Timing) time.Duration { return t.RateLimitBackoff }
 		}
+	}
+	return func(t *Timing) time.Duration { return t.Interval }
+}
 
-		order, notFound, err := tx.GetOrderIdsByStatus(ctx, status)
-		if err != nil {
-			return false, rollback(err)
-		}
+// update runs Concurrency workers over the orders in status, the first of
+// them to fail or hit the rate limit stops the others.
+func (s *OrderUpdater) update(ctx context.Context, status string, start time.Time) (bool, error) {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		stop      atomic.Bool
+		toManyReq bool
+		errs      error
+	)
+	for i := 0; i < s.timing.Load().Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				done, tmr, err := s.updateNext(ctx, status, start)
+				s.markProgress()
+				if err != nil || done {
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						errs = multierror.Append(errs, err)
+					}
+					if err != nil || tmr {
+						stop.Store(true)
+					}
+					toManyReq = toManyReq || tmr
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return toManyReq, errs
+}
 
-		if notFound {
-			return false, nil
-		}
+// updateNext claims the order in status checked least recently and not
+// since start, asks the accrual system about it and applies a changed status
+// in a transaction of its own, so no transaction waits on the accrual
+// system. done is set when the sweep over status is over. An order the
+// accrual system failed on stays claimed and the sweep goes on with the
+// others.
+func (s *OrderUpdater) updateNext(ctx context.Context, status string, start time.Time) (done, toManyReq bool, err error) {
+	ctx, span := tracing.Start(ctx, "OrderUpdater.updateNext", tracing.AttrStatus.String(status))
+	defer func() { tracing.End(span, err) }()
 
-		log.Info().Msg("update order " + order + " with status " + status)
-		updatedOrder, toManyReq, err := s.Scores.GetScore(ctx, order)
-		if err != nil {
-			return false, rollback(err)
-		}
+	order, notFound, err := s.Store.ClaimOrder(ctx, status, start)
+	if err != nil {
+		return true, false, err
+	}
+	if notFound {
+		return true, false, nil
+	}
+	span.SetAttributes(tracing.AttrOrder.String(order))
 
-		if toManyReq {
-			return toManyReq, rollback(nil)
-		}
+	logger := log.Ctx(ctx).With().
+		Str("order", order).
+		Str("trace_id", span.SpanContext().TraceID().String()).
+		Logger()
+	ctx = logger.WithContext(ctx)
 
-		if updatedOrder.Status != status {
-			err = tx.UpdateOrder(ctx, updatedOrder)
-			if err != nil {
-				return false, rollback(err)
-			}
+	logger.Info().Str("status", status).Msg("update order")
+	updatedOrder, toManyReq, err := s.Scores.GetScore(ctx, order)
+	if err != nil {
+		// one failing order doesn't end the sweep for the others
+		logger.Error().Err(err).Msg("error get score, check the order next sweep")
+		span.RecordError(err)
+		return false, false, nil
+	}
 
-			err = tx.Commit()
-			if err != nil {
-				return false, err
-			}
+	if toManyReq {
+		return true, toManyReq, nil
+	}
+
+	span.SetAttributes(tracing.AttrNewStatus.String(updatedOrder.Status))
+	if updatedOrder.Status == status {
+		return false, false, nil
+	}
+
+	tx, err := s.Store.NewUpdaterTx(ctx)
+	if err != nil {
+		return true, false, err
+	}
+
+	err = tx.UpdateOrder(ctx, updatedOrder)
+	if err != nil {
+		errRoll := tx.Rollback()
+		if errRoll != nil {
+			err = multierror.Append(err, fmt.Errorf("error on rollback %w", errRoll))
 		}
+		return true, false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return true, false, err
 	}
+	return false, false, nil
 }
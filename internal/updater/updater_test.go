@@ -0,0 +1,332 @@
+package updater
+
+import (
+	"context"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/accrualmock"
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/scores"
+	"github.com/e-faizov/gophermart/internal/storage"
+)
+
+func TestOrderUpdaterWithAccrualMock(t *testing.T) {
+	mock := accrualmock.New()
+	mock.AddGoods(accrualmock.Goods{Match: "Bork", Reward: 10, RewardType: accrualmock.RewardPercent})
+	mock.RegisterOrder(accrualmock.Order{
+		Order: "12345678903",
+		Goods: []accrualmock.Item{{Description: "Чайник Bork", Price: 7000}},
+	})
+	mock.RegisterOrder(accrualmock.Order{Order: "12345678904"})
+	acc := float64(42)
+	mock.Script("4561261212345467",
+		accrualmock.Step{Code: 500},
+		accrualmock.Step{Status: accrualmock.StatusProcessed, Accrual: &acc})
+
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	store := newMemStore("12345678903", "12345678904", "4561261212345467")
+
+	upd := OrderUpdater{
+		Store:  store,
+		Scores: &scores.Scores{URL: srv.URL},
+	}
+	upd.Start()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) && !store.done() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	upd.Stop()
+
+	checkOrder(t, store.order("12345678903"), storage.OtProcessed, 700)
+	checkOrder(t, store.order("12345678904"), storage.OtInvalid, 0)
+	checkOrder(t, store.order("4561261212345467"), storage.OtProcessed, 42)
+}
+
+func TestOrderUpdaterSkipsStuckOrder(t *testing.T) {
+	mock := accrualmock.New()
+	// the oldest order never leaves PROCESSING
+	mock.Script("4561261212345467", accrualmock.Step{Status: accrualmock.StatusProcessing})
+	mock.RegisterOrder(accrualmock.Order{Order: "12345678903"})
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	store := newMemStore("4561261212345467", "12345678903")
+	upd := OrderUpdater{
+		Store:    store,
+		Scores:   &scores.Scores{URL: srv.URL},
+		Interval: 10 * time.Millisecond,
+	}
+	upd.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && store.order("12345678903").Status != storage.OtProcessed {
+		time.Sleep(10 * time.Millisecond)
+	}
+	upd.Stop()
+
+	checkOrder(t, store.order("12345678903"), storage.OtProcessed, 0)
+	checkOrder(t, store.order("4561261212345467"), storage.OtProcessing, 0)
+}
+
+func TestOrderUpdaterSkipsFailingOrder(t *testing.T) {
+	mock := accrualmock.New()
+	// the oldest order always fails, the error backoff would outlast the test
+	mock.Script("4561261212345467", accrualmock.Step{Code: 500})
+	mock.RegisterOrder(accrualmock.Order{Order: "12345678903"})
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	store := newMemStore("4561261212345467", "12345678903")
+	upd := OrderUpdater{
+		Store:        store,
+		Scores:       &scores.Scores{URL: srv.URL},
+		Interval:     10 * time.Millisecond,
+		ErrorBackoff: time.Hour,
+	}
+	upd.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && store.order("12345678903").Status != storage.OtProcessed {
+		time.Sleep(10 * time.Millisecond)
+	}
+	upd.Stop()
+
+	checkOrder(t, store.order("12345678903"), storage.OtProcessed, 0)
+	checkOrder(t, store.order("4561261212345467"), storage.OtNew, 0)
+}
+
+func TestOrderUpdaterSetTiming(t *testing.T) {
+	mock := accrualmock.New()
+	mock.RegisterOrder(accrualmock.Order{Order: "12345678903"})
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	store := newMemStore()
+	upd := OrderUpdater{
+		Store:    store,
+		Scores:   &scores.Scores{URL: srv.URL},
+		Interval: time.Hour,
+	}
+	upd.Start()
+	defer upd.Stop()
+
+	// the first sweep finds nothing, the order waits for the next one
+	time.Sleep(100 * time.Millisecond)
+	store.mu.Lock()
+	store.orders["12345678903"] = models.Order{Number: "12345678903", Status: storage.OtNew}
+	store.mu.Unlock()
+
+	upd.SetTiming(Timing{Interval: 10 * time.Millisecond})
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !store.done() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if o := store.order("12345678903"); o.Status == storage.OtNew {
+		t.Error("order is not updated after SetTiming")
+	}
+
+	if stale := upd.timing.Load().StaleAfter; stale != 5*time.Minute {
+		t.Errorf("wrong default stale after %s", stale)
+	}
+}
+
+// slowScores processes every order after a pause and counts the calls in
+// flight and the ones made with an update transaction of store open.
+type slowScores struct {
+	store    *memStore
+	mu       sync.Mutex
+	inFlight int
+	max      int
+	inTx     int
+}
+
+func (s *slowScores) GetScore(ctx context.Context, order string) (models.Order, bool, error) {
+	s.store.mu.Lock()
+	open := s.store.open
+	s.store.mu.Unlock()
+
+	s.mu.Lock()
+	if open > 0 {
+		s.inTx++
+	}
+	s.inFlight++
+	if s.inFlight > s.max {
+		s.max = s.inFlight
+	}
+	s.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+	return models.Order{Number: order, Status: storage.OtInvalid}, false, nil
+}
+
+func TestOrderUpdaterConcurrency(t *testing.T) {
+	store := newMemStore("12345678903", "12345678904", "4561261212345467", "2377225624")
+	sc := &slowScores{store: store}
+	upd := OrderUpdater{
+		Store:       store,
+		Scores:      sc,
+		Interval:    time.Hour,
+		Concurrency: 3,
+	}
+	upd.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !store.done() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	upd.Stop()
+
+	if !store.done() {
+		t.Fatal("orders are not updated")
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.max != 3 {
+		t.Errorf("%d orders checked at once, want 3", sc.max)
+	}
+	if sc.inTx != 0 {
+		t.Errorf("%d orders checked with a transaction open", sc.inTx)
+	}
+}
+
+func checkOrder(t *testing.T, order models.Order, status string, accrual float64) {
+	t.Helper()
+	if order.Status != status {
+		t.Errorf("order %s: wrong status %s, want %s", order.Number, order.Status, status)
+		return
+	}
+	if status != storage.OtProcessed {
+		return
+	}
+	if order.Accrual == nil || *order.Accrual != accrual {
+		t.Errorf("order %s: wrong accrual %v, want %v", order.Number, order.Accrual, accrual)
+	}
+}
+
+type memStore struct {
+	mu      sync.Mutex
+	orders  map[string]models.Order
+	checked map[string]time.Time
+	// open counts the update transactions in progress
+	open int
+}
+
+func newMemStore(numbers ...string) *memStore {
+	s := &memStore{orders: map[string]models.Order{}, checked: map[string]time.Time{}}
+	tm := time.Now()
+	for i, n := range numbers {
+		s.orders[n] = models.Order{
+			Number:   n,
+			Status:   storage.OtNew,
+			Uploaded: tm.Add(time.Duration(i) * time.Second),
+		}
+	}
+	return s
+}
+
+func (s *memStore) order(number string) models.Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.orders[number]
+}
+
+func (s *memStore) done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.orders {
+		if o.Status == storage.OtNew || o.Status == storage.OtProcessing {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *memStore) SaveOrder(ctx context.Context, user, order string) (models.SaveResult, error) {
+	return models.OrderAccepted, nil
+}
+
+func (s *memStore) SaveOrders(ctx context.Context, user string, orders []string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (s *memStore) GetOrders(ctx context.Context, user string, query models.OrdersQuery) ([]models.Order, error) {
+	return nil, nil
+}
+
+func (s *memStore) ClaimOrder(ctx context.Context, status string, checkedBefore time.Time) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []models.Order
+	for _, o := range s.orders {
+		if o.Status == status && s.checked[o.Number].Before(checkedBefore) {
+			found = append(found, o)
+		}
+	}
+	if len(found) == 0 {
+		return "", true, nil
+	}
+	sort.Slice(found, func(i, j int) bool {
+		ci, cj := s.checked[found[i].Number], s.checked[found[j].Number]
+		if !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		return found[i].Uploaded.Before(found[j].Uploaded)
+	})
+	s.checked[found[0].Number] = time.Now()
+	return found[0].Number, false, nil
+}
+
+func (s *memStore) NewUpdaterTx(ctx context.Context) (interfaces.OrderUpdateTx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open++
+	return &memTx{store: s}, nil
+}
+
+type memTx struct {
+	store   *memStore
+	updates []models.Order
+}
+
+func (m *memTx) UpdateOrder(ctx context.Context, order models.Order) error {
+	m.updates = append(m.updates, order)
+	return nil
+}
+
+func (m *memTx) SaveSignature(ctx context.Context, sig string, expires time.Time) (bool, error) {
+	return true, nil
+}
+
+func (m *memTx) Rollback() error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	m.store.open--
+	m.updates = nil
+	return nil
+}
+
+func (m *memTx) Commit() error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+	m.store.open--
+	for _, u := range m.updates {
+		o := m.store.orders[u.Number]
+		o.Status = u.Status
+		o.Accrual = u.Accrual
+		m.store.orders[u.Number] = o
+	}
+	return nil
+}
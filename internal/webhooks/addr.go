@@ -0,0 +1,80 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is the error for webhook urls pointing at this host
+// or the internal network.
+var ErrForbiddenAddress = errors.New("webhook address not allowed")
+
+// lookupIPAddr is replaced in tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// forbidden reports loopback, link-local, private, multicast and
+// unspecified addresses.
+func forbidden(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// CheckURL rejects a url whose host is or resolves to a forbidden address.
+// A name that doesn't resolve passes, the delivery client checks the
+// address again on every connection.
+func CheckURL(ctx context.Context, u *url.URL) error {
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if forbidden(ip) {
+			return ErrForbiddenAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		if forbidden(a.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// dialControl runs after the name is resolved, so a name rebound to an
+// internal address after CheckURL is refused too.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || forbidden(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// NewClient is the client deliveries are sent with. It connects only to
+// allowed addresses, redirects included, and ignores the proxy settings:
+// through a proxy the check would see only the proxy address.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
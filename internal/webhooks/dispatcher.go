@@ -0,0 +1,179 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog/log"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/signature"
+)
+
+const HeaderDelivery = "X-Gophermart-Delivery"
+
+// Dispatcher sends pending webhook deliveries. A failed attempt is retried
+// with exponential backoff until MaxAttempts, then the delivery is failed.
+type Dispatcher struct {
+	Store       interfaces.WebhookDispatchStorage
+	Client      *http.Client
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Lease is how long claimed deliveries are hidden from other replicas,
+	// it must outlast sending a batch.
+	Lease  time.Duration
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.done = make(chan struct{})
+	d.cancel = cancel
+	if d.Client == nil {
+		d.Client = NewClient(10 * time.Second)
+	}
+	if d.Interval == 0 {
+		d.Interval = time.Second
+	}
+	if d.BatchSize == 0 {
+		d.BatchSize = 20
+	}
+	if d.MaxAttempts == 0 {
+		d.MaxAttempts = 10
+	}
+	if d.BaseBackoff == 0 {
+		d.BaseBackoff = 10 * time.Second
+	}
+	if d.MaxBackoff == 0 {
+		d.MaxBackoff = time.Hour
+	}
+	if d.Lease == 0 {
+		d.Lease = 5 * time.Minute
+	}
+	go d.worker(ctx)
+}
+
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	<-d.done
+}
+
+func (d *Dispatcher) worker(ctx context.Context) {
+	var sleep time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			d.done <- struct{}{}
+			return
+		case <-time.After(sleep):
+			n, err := d.dispatch(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Dispatcher.worker error dispatch")
+			}
+			if err == nil && n == d.BatchSize {
+				sleep = 0
+				continue
+			}
+			sleep = d.Interval
+		}
+	}
+}
+
+// dispatch claims a batch and sends it outside of any transaction. Each
+// attempt is saved on its own: a failed save only makes that delivery go
+// again once its lease runs out.
+func (d *Dispatcher) dispatch(ctx context.Context) (int, error) {
+	deliveries, err := d.Store.ClaimDeliveries(ctx, d.BatchSize, d.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	var errs error
+	for _, delivery := range deliveries {
+		err = d.Store.SaveAttempt(ctx, d.attempt(ctx, delivery))
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("error save attempt of delivery %d: %w", delivery.ID, err))
+		}
+	}
+	return len(deliveries), errs
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.WebhookDelivery) models.WebhookDelivery {
+	delivery.Attempts++
+	code, err := d.send(ctx, delivery)
+
+	now := time.Now()
+	if code != 0 {
+		delivery.ResponseCode = &code
+	}
+	if err == nil {
+		delivery.Status = models.DeliveryDelivered
+		delivery.Delivered = &now
+		delivery.NextAttempt = nil
+		delivery.LastError = nil
+		return delivery
+	}
+
+	msg := err.Error()
+	delivery.LastError = &msg
+	if delivery.Attempts >= d.MaxAttempts {
+		delivery.Status = models.DeliveryFailed
+		delivery.NextAttempt = nil
+		return delivery
+	}
+	next := now.Add(d.backoff(delivery.Attempts))
+	delivery.NextAttempt = &next
+	return delivery
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	res := d.BaseBackoff
+	for i := 1; i < attempts && res < d.MaxBackoff; i++ {
+		res *= 2
+	}
+	if res > d.MaxBackoff {
+		res = d.MaxBackoff
+	}
+	return res
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(signature.HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(signature.HeaderSignature, signature.Sign([]byte(delivery.Secret), now, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,132 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/e-faizov/gophermart/internal/models"
+	"github.com/e-faizov/gophermart/internal/signature"
+)
+
+func TestDispatcherAttempt(t *testing.T) {
+	secret := "hook secret"
+	codes := []int{http.StatusInternalServerError, http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		v := signature.Verifier{Secret: []byte(secret)}
+		if err := v.Verify(r.Context(), r.Header.Get(signature.HeaderTimestamp), r.Header.Get(signature.HeaderSignature), body); err != nil {
+			t.Error("wrong signature:", err)
+		}
+		if r.Header.Get(HeaderDelivery) != "7" {
+			t.Error("wrong delivery header:", r.Header.Get(HeaderDelivery))
+		}
+		w.WriteHeader(codes[0])
+		codes = codes[1:]
+	}))
+	defer srv.Close()
+
+	d := Dispatcher{
+		Client:      srv.Client(),
+		MaxAttempts: 3,
+		BaseBackoff: 10 * time.Second,
+		MaxBackoff:  time.Minute,
+	}
+	delivery := models.WebhookDelivery{
+		ID:     7,
+		Event:  models.Event{Type: models.EventPing},
+		Status: models.DeliveryPending,
+		URL:    srv.URL,
+		Secret: secret,
+	}
+
+	delivery = d.attempt(context.Background(), delivery)
+	if delivery.Status != models.DeliveryPending || delivery.Attempts != 1 || delivery.NextAttempt == nil ||
+		*delivery.ResponseCode != http.StatusInternalServerError || delivery.LastError == nil {
+		t.Fatal("wrong failed attempt", delivery)
+	}
+
+	delivery = d.attempt(context.Background(), delivery)
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 2 || delivery.NextAttempt != nil ||
+		delivery.Delivered == nil || delivery.LastError != nil {
+		t.Fatal("wrong successful attempt", delivery)
+	}
+
+	delivery = models.WebhookDelivery{Attempts: 2, URL: "http://127.0.0.1:1", Status: models.DeliveryPending}
+	delivery = d.attempt(context.Background(), delivery)
+	if delivery.Status != models.DeliveryFailed || delivery.NextAttempt != nil {
+		t.Fatal("delivery not failed after max attempts", delivery)
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := Dispatcher{BaseBackoff: 10 * time.Second, MaxBackoff: time.Minute}
+
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, w := range want {
+		if got := d.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+type testDispatchStore struct {
+	claimed []models.WebhookDelivery
+	lease   time.Duration
+	saved   []models.WebhookDelivery
+	failID  int64
+}
+
+func (t *testDispatchStore) EnqueueWebhookEvents(ctx context.Context, events []models.Event) error {
+	return nil
+}
+
+func (t *testDispatchStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	t.lease = lease
+	res := t.claimed
+	t.claimed = nil
+	return res, nil
+}
+
+func (t *testDispatchStore) SaveAttempt(ctx context.Context, delivery models.WebhookDelivery) error {
+	if delivery.ID == t.failID {
+		return errors.New("connection lost")
+	}
+	t.saved = append(t.saved, delivery)
+	return nil
+}
+
+func TestDispatcherSaveFailure(t *testing.T) {
+	sent := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+	}))
+	defer srv.Close()
+
+	store := &testDispatchStore{failID: 1}
+	for id := int64(1); id <= 3; id++ {
+		store.claimed = append(store.claimed, models.WebhookDelivery{ID: id, URL: srv.URL, Status: models.DeliveryPending})
+	}
+	d := Dispatcher{Store: store, Client: srv.Client(), BatchSize: 3, MaxAttempts: 3, Lease: time.Minute}
+
+	n, err := d.dispatch(context.Background())
+	if n != 3 || err == nil {
+		t.Fatal("dispatch:", n, err)
+	}
+	// the failed save doesn't undo the attempts around it
+	if sent != 3 || len(store.saved) != 2 || store.saved[0].ID != 2 || store.saved[1].ID != 3 {
+		t.Error("wrong attempts: sent", sent, "saved", store.saved)
+	}
+	for _, s := range store.saved {
+		if s.Status != models.DeliveryDelivered || s.Attempts != 1 {
+			t.Error("wrong saved attempt", s)
+		}
+	}
+	if store.lease != time.Minute {
+		t.Error("wrong lease", store.lease)
+	}
+}
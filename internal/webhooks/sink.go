@@ -0,0 +1,22 @@
+package webhooks
+
+import (
+	"context"
+
+	"github.com/e-faizov/gophermart/internal/interfaces"
+	"github.com/e-faizov/gophermart/internal/models"
+)
+
+// Sink is an outbox sink that turns events into deliveries for the user
+// webhooks subscribed to them.
+type Sink struct {
+	Store interfaces.WebhookDispatchStorage
+}
+
+func (s *Sink) Name() string {
+	return "user-webhooks"
+}
+
+func (s *Sink) Deliver(ctx context.Context, events []models.Event) error {
+	return s.Store.EnqueueWebhookEvents(ctx, events)
+}